@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"go/version"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/mod/modfile"
+)
+
+// alignMax is the -go and -toolchain policy picking the highest value across
+// the workspace.
+const alignMax = "max"
+
+// alignedFile is a file of the workspace whose go and toolchain directives
+// are aligned.
+type alignedFile struct {
+	path string
+	enc  fileEncoding
+	// goVersion and toolchain are the current directives ("" if missing).
+	goVersion, toolchain string
+	edit                 interface {
+		AddGoStmt(version string) error
+		AddToolchainStmt(name string) error
+		DropToolchainStmt()
+	}
+	format func() ([]byte, error)
+}
+
+// runAlignGo sets the go directive, and the toolchain directive, of the
+// go.work file of a workspace and of every module it uses to the same value:
+// the highest across them, or the one given.
+func runAlignGo(ctx context.Context, args []string) error {
+	var (
+		workFile  string
+		goVersion string
+		toolchain string
+		write     bool
+	)
+	fs := flag.NewFlagSet("modtransplant align-go", flag.ExitOnError)
+	fs.StringVar(&workFile, "work", "", "go.work file of the workspace (defaults to the one the go command uses in the current directory)")
+	fs.StringVar(&goVersion, "go", alignMax, "go version to set, or max for the highest of the workspace's files")
+	fs.StringVar(&toolchain, "toolchain", alignMax, "toolchain to set (e.g. go1.22.3), max for the highest of the workspace's files, or none to drop the directive")
+	fs.BoolVar(&write, "w", false, "write the changed files instead of only reporting them")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 0 {
+		return errors.New(usage)
+	}
+	if goVersion != alignMax && !version.IsValid("go"+goVersion) {
+		return fmt.Errorf("invalid -go version %q", goVersion)
+	}
+	if toolchain != alignMax && toolchain != "none" && !version.IsValid(toolchain) {
+		return fmt.Errorf("invalid -toolchain %q (e.g. go1.22.3)", toolchain)
+	}
+
+	if workFile == "" {
+		var err error
+		if workFile, err = workspaceFile(ctx, "."); err != nil {
+			return err
+		}
+		if workFile == "" {
+			return errors.New("not in a workspace; use -work to name its go.work file")
+		}
+	}
+	files, err := workspaceFiles(workFile)
+	if err != nil {
+		return err
+	}
+
+	if goVersion == alignMax {
+		goVersion = ""
+		for _, f := range files {
+			if f.goVersion != "" && (goVersion == "" || version.Compare("go"+f.goVersion, "go"+goVersion) > 0) {
+				goVersion = f.goVersion
+			}
+		}
+		if goVersion == "" {
+			return fmt.Errorf("%s: no go directive in the workspace to align with; use -go", workFile)
+		}
+	}
+	switch toolchain {
+	case alignMax:
+		toolchain = ""
+		for _, f := range files {
+			if f.toolchain != "" && (toolchain == "" || version.Compare(f.toolchain, toolchain) > 0) {
+				toolchain = f.toolchain
+			}
+		}
+	case "none":
+		toolchain = ""
+	}
+	// Like the go command, leave out a toolchain that the go version already
+	// implies.
+	if toolchain != "" && version.Compare(toolchain, "go"+goVersion) <= 0 {
+		toolchain = ""
+	}
+
+	var tx transaction
+	var changed int
+	for _, f := range files {
+		if f.goVersion == goVersion && f.toolchain == toolchain {
+			continue
+		}
+		changed++
+		fmt.Fprintf(os.Stderr, "(align) %s: %s\n", f.path, alignChange(f, goVersion, toolchain))
+		if err := f.edit.AddGoStmt(goVersion); err != nil {
+			return fmt.Errorf("%s: %w", f.path, err)
+		}
+		if toolchain == "" {
+			f.edit.DropToolchainStmt()
+		} else if err := f.edit.AddToolchainStmt(toolchain); err != nil {
+			return fmt.Errorf("%s: %w", f.path, err)
+		}
+		out, err := f.format()
+		if err != nil {
+			return err
+		}
+		tx.stage(f.path, f.enc.apply(out))
+	}
+	fmt.Fprintf(os.Stderr, "(align) %d of %d files changed\n", changed, len(files))
+	if !write {
+		return nil
+	}
+	return tx.commit(ctx)
+}
+
+// alignChange describes the directive changes aligning f.
+func alignChange(f alignedFile, goVersion, toolchain string) string {
+	describe := func(name, from, to string) string {
+		switch {
+		case from == to:
+			return ""
+		case from == "":
+			return fmt.Sprintf("%s %s (added)", name, to)
+		case to == "":
+			return fmt.Sprintf("%s %s (dropped)", name, from)
+		}
+		return fmt.Sprintf("%s %s -> %s", name, from, to)
+	}
+	goChange, toolchainChange := describe("go", f.goVersion, goVersion), describe("toolchain", f.toolchain, toolchain)
+	switch {
+	case goChange == "":
+		return toolchainChange
+	case toolchainChange == "":
+		return goChange
+	}
+	return goChange + ", " + toolchainChange
+}
+
+// workspaceFiles reads the go.work file at workFile and the go.mod files of
+// the modules it uses.
+func workspaceFiles(workFile string) ([]alignedFile, error) {
+	content, err := ioutil.ReadFile(workFile)
+	if err != nil {
+		return nil, err
+	}
+	enc, normalized := detectEncoding(content)
+	work, err := modfile.ParseWork(workFile, normalized, nil)
+	if err != nil {
+		return nil, err
+	}
+	files := []alignedFile{{
+		path:   workFile,
+		enc:    enc,
+		edit:   work,
+		format: func() ([]byte, error) { return modfile.Format(work.Syntax), nil },
+	}}
+	if work.Go != nil {
+		files[0].goVersion = work.Go.Version
+	}
+	if work.Toolchain != nil {
+		files[0].toolchain = work.Toolchain.Name
+	}
+
+	for _, u := range work.Use {
+		dir := filepath.FromSlash(u.Path)
+		if !filepath.IsAbs(dir) {
+			dir = filepath.Join(filepath.Dir(workFile), dir)
+		}
+		path := filepath.Join(dir, "go.mod")
+		f, enc, err := readModFile(path)
+		if err != nil {
+			return nil, err
+		}
+		af := alignedFile{path: path, enc: enc, edit: f, format: f.Format}
+		if f.Go != nil {
+			af.goVersion = f.Go.Version
+		}
+		if f.Toolchain != nil {
+			af.toolchain = f.Toolchain.Name
+		}
+		files = append(files, af)
+	}
+	return files, nil
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// tokensEnv names the environment variable mapping hosts to bearer tokens, as
+// a comma-separated list of host=token pairs.
+const tokensEnv = "MODTRANSPLANT_AUTH_TOKENS"
+
+// authTransport adds credentials to requests based on their host: a bearer
+// token from MODTRANSPLANT_AUTH_TOKENS if one is configured for the host,
+// otherwise basic auth from the user's .netrc file. Requests that already
+// carry credentials (e.g. from user info in a GOPROXY URL) are left alone.
+type authTransport struct {
+	base   http.RoundTripper
+	tokens map[string]string
+	netrc  []netrcLine
+}
+
+func (t *authTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Header.Get("Authorization") != "" || req.URL.User != nil {
+		return t.base.RoundTrip(req)
+	}
+	host, hostname := req.URL.Host, req.URL.Hostname()
+
+	token, ok := t.tokens[host]
+	if !ok {
+		token, ok = t.tokens[hostname]
+	}
+	if ok {
+		req = req.Clone(req.Context())
+		req.Header.Set("Authorization", "Bearer "+token)
+		return t.base.RoundTrip(req)
+	}
+
+	for _, l := range t.netrc {
+		if l.machine == hostname {
+			req = req.Clone(req.Context())
+			req.SetBasicAuth(l.login, l.password)
+			break
+		}
+	}
+	return t.base.RoundTrip(req)
+}
+
+func parseHostTokens(value string) map[string]string {
+	tokens := map[string]string{}
+	for _, pair := range strings.Split(value, ",") {
+		if kv := strings.SplitN(strings.TrimSpace(pair), "=", 2); len(kv) == 2 && kv[0] != "" {
+			tokens[kv[0]] = kv[1]
+		}
+	}
+	return tokens
+}
+
+// netrcLine is a machine entry of a .netrc file.
+type netrcLine struct {
+	machine  string
+	login    string
+	password string
+}
+
+// readNetrc reads the file named by NETRC, or .netrc (_netrc on Windows) in
+// the user's home directory, the same file the go command uses.
+func readNetrc() []netrcLine {
+	path := os.Getenv("NETRC")
+	if path == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil
+		}
+		base := ".netrc"
+		if runtime.GOOS == "windows" {
+			base = "_netrc"
+		}
+		path = filepath.Join(home, base)
+	}
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	return parseNetrc(string(content))
+}
+
+// parseNetrc parses the complete machine entries of a .netrc file. Like the go
+// command, it stops at the default entry rather than sending its credentials
+// to arbitrary hosts.
+func parseNetrc(data string) []netrcLine {
+	var (
+		lines   []netrcLine
+		l       netrcLine
+		inMacro bool
+	)
+	for _, line := range strings.Split(data, "\n") {
+		if inMacro {
+			// A macro definition ends with an empty line.
+			if line == "" {
+				inMacro = false
+			}
+			continue
+		}
+		f := strings.Fields(line)
+		for i := 0; i < len(f); i += 2 {
+			if f[i] == "default" {
+				return lines
+			}
+			if i+1 == len(f) {
+				break
+			}
+			switch f[i] {
+			case "machine":
+				l = netrcLine{machine: f[i+1]}
+			case "login":
+				l.login = f[i+1]
+			case "password":
+				l.password = f[i+1]
+			case "macdef":
+				inMacro = true
+			}
+			if l.machine != "" && l.login != "" && l.password != "" {
+				lines = append(lines, l)
+				l = netrcLine{}
+			}
+		}
+	}
+	return lines
+}
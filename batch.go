@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/mod/modfile"
+)
+
+// findModFiles returns the go.mod files below dir, skipping the directories
+// the go command ignores (vendor, testdata, and those starting with "." or
+// "_") and the paths matched by ignore files.
+func findModFiles(dir string) ([]string, error) {
+	var (
+		files []string
+		rules ignoreRules
+	)
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if d.IsDir() {
+			name := d.Name()
+			if path != dir && (name == "vendor" || name == "testdata" || strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_") || rules.ignored(rel, true)) {
+				return filepath.SkipDir
+			}
+			return rules.load(path, rel)
+		}
+		if d.Name() == "go.mod" && !rules.ignored(rel, false) {
+			files = append(files, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no go.mod files found below %s", dir)
+	}
+	return files, nil
+}
+
+// isGlobPattern reports whether a -dest value is a glob pattern rather than a
+// file name.
+func isGlobPattern(dest string) bool {
+	return strings.ContainsAny(dest, "*?[")
+}
+
+// globModFiles returns the files matching pattern, in the syntax of
+// filepath.Match.
+func globModFiles(pattern string) ([]string, error) {
+	files, err := filepath.Glob(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("-dest %s: %w", pattern, err)
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no files match %s", pattern)
+	}
+	return files, nil
+}
+
+// mergeBatch merges src into each of destFiles in turn. A failing destination
+// aborts the batch, unless continueOnError is set, in which case the remaining
+// destinations are still merged and every failure is listed at the end.
+func mergeBatch(ctx context.Context, destFiles []string, src *modfile.File, opts *mergeOptions, continueOnError bool) error {
+	type failure struct {
+		destFile string
+		err      error
+	}
+	var failures []failure
+	for i, destFile := range destFiles {
+		fmt.Fprintf(os.Stderr, "(batch) merge: %s (%d of %d, %d remaining)\n", destFile, i+1, len(destFiles), len(destFiles)-i-1)
+		err := mergeInto(ctx, destFile, src, opts)
+		if err == nil {
+			continue
+		}
+		if !continueOnError || ctx.Err() != nil {
+			return fmt.Errorf("%s: %w", destFile, err)
+		}
+		fmt.Fprintf(os.Stderr, "(batch) failed: %s\n", destFile)
+		failures = append(failures, failure{destFile, err})
+	}
+
+	fmt.Fprintf(os.Stderr, "(batch) merged %d of %d destinations\n", len(destFiles)-len(failures), len(destFiles))
+	if len(failures) == 0 {
+		return nil
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "%d of %d destinations failed:", len(failures), len(destFiles))
+	for _, f := range failures {
+		fmt.Fprintf(&b, "\n\t%s: %s", f.destFile, strings.ReplaceAll(f.err.Error(), "\n", "\n\t"))
+	}
+	return errors.New(b.String())
+}
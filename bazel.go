@@ -0,0 +1,399 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"golang.org/x/mod/modfile"
+	"golang.org/x/mod/module"
+)
+
+// runBazelSync mirrors module versions between a go.mod file and the
+// go_repository rules (or MODULE.bazel go_deps.module calls) of a Bazel file.
+// The updated file is written to stdout.
+func runBazelSync(args []string) error {
+	var (
+		destFile  string
+		bzlFile   string
+		fromBazel bool
+		goSumFile string
+	)
+	fs := flag.NewFlagSet("modtransplant bazel-sync", flag.ExitOnError)
+	fs.StringVar(&destFile, "dest", "", "destination go.mod file")
+	fs.StringVar(&bzlFile, "bzl", "", "Bazel file containing go_repository rules or go_deps.module calls")
+	fs.BoolVar(&fromBazel, "from-bazel", false, "update go.mod from the Bazel file instead of the other way around")
+	fs.StringVar(&goSumFile, "gosum", "", "go.sum file used to update rule checksums (defaults to the one next to -dest)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if destFile == "" || bzlFile == "" {
+		return errors.New(usage)
+	}
+
+	dest, err := parseModFile(destFile)
+	if err != nil {
+		return err
+	}
+	bzl, err := ioutil.ReadFile(bzlFile)
+	if err != nil {
+		return err
+	}
+	rules := parseBazelRules(bzl)
+
+	if fromBazel {
+		if err := syncFromBazel(dest, rules); err != nil {
+			return err
+		}
+		out, err := dest.Format()
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(out))
+		return nil
+	}
+
+	if goSumFile == "" {
+		goSumFile = filepath.Join(filepath.Dir(destFile), "go.sum")
+	}
+	sums, err := readGoSum(goSumFile)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	fmt.Print(string(syncToBazel(bzl, rules, dest, sums)))
+	return nil
+}
+
+// bazelRule is a go_repository rule or go_deps.module call found in a Bazel
+// file.
+type bazelRule struct {
+	attrs map[string]bazelAttr
+}
+
+// bazelAttr is a string attribute of a bazelRule along with the offsets of its
+// value and of the whole attribute.
+type bazelAttr struct {
+	value                string
+	valueStart, valueEnd int
+	start, end           int
+}
+
+// importPath returns the module path the rule describes.
+func (r bazelRule) importPath() string {
+	if a, ok := r.attrs["importpath"]; ok {
+		return a.value
+	}
+	return r.attrs["path"].value
+}
+
+var (
+	bazelCallRe = regexp.MustCompile(`\b(?:go_repository|go_deps\.module)\s*\(`)
+	bazelAttrRe = regexp.MustCompile(`\b(name|importpath|path|version|sum|replace)\s*=\s*"([^"]*)"`)
+)
+
+func parseBazelRules(src []byte) []bazelRule {
+	var rules []bazelRule
+	for _, loc := range bazelCallRe.FindAllSubmatchIndex(src, -1) {
+		// Skip a definition of go_repository itself.
+		if lineStart, _ := lineBounds(src, loc[0]); strings.TrimSpace(string(src[lineStart:loc[0]])) == "def" {
+			continue
+		}
+		end := closingParen(src, loc[1])
+		if end < 0 {
+			continue
+		}
+		rule := bazelRule{attrs: map[string]bazelAttr{}}
+		body := src[loc[1]:end]
+		for _, m := range bazelAttrRe.FindAllSubmatchIndex(body, -1) {
+			key := string(body[m[2]:m[3]])
+			rule.attrs[key] = bazelAttr{
+				value:      string(body[m[4]:m[5]]),
+				valueStart: loc[1] + m[4],
+				valueEnd:   loc[1] + m[5],
+				start:      loc[1] + m[0],
+				end:        loc[1] + m[1],
+			}
+		}
+		if rule.importPath() != "" {
+			rules = append(rules, rule)
+		}
+	}
+	return rules
+}
+
+// closingParen returns the offset of the parenthesis closing the one opened
+// just before start, skipping over strings and comments.
+func closingParen(src []byte, start int) int {
+	depth := 1
+	for i := start; i < len(src); i++ {
+		switch src[i] {
+		case '#':
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+		case '"', '\'':
+			q := src[i]
+			for i++; i < len(src) && src[i] != q; i++ {
+				if src[i] == '\\' {
+					i++
+				}
+			}
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+func lineBounds(src []byte, offset int) (int, int) {
+	start := offset
+	for start > 0 && src[start-1] != '\n' {
+		start--
+	}
+	end := offset
+	for end < len(src) && src[end] != '\n' {
+		end++
+	}
+	if end < len(src) {
+		end++
+	}
+	return start, end
+}
+
+// removeAttr returns the edit removing a from src along with the comma
+// separating it from the next attribute, or from the previous one if it is the
+// last. An attribute alone on its line is removed with the line.
+func removeAttr(src []byte, a bazelAttr) textEdit {
+	start, end := a.start, a.end
+	for end < len(src) && (src[end] == ' ' || src[end] == '\t') {
+		end++
+	}
+	if end < len(src) && src[end] == ',' {
+		end++
+		for end < len(src) && (src[end] == ' ' || src[end] == '\t') {
+			end++
+		}
+	} else {
+		for start > 0 && (src[start-1] == ' ' || src[start-1] == '\t') {
+			start--
+		}
+		if start > 0 && src[start-1] == ',' {
+			start--
+		}
+	}
+	lineStart, lineEnd := lineBounds(src, a.start)
+	if strings.TrimSpace(string(src[lineStart:start])) == "" && strings.TrimSpace(string(src[end:lineEnd])) == "" {
+		return textEdit{lineStart, lineEnd, ""}
+	}
+	return textEdit{start, end, ""}
+}
+
+// textEdit replaces src[start:end] with text.
+type textEdit struct {
+	start, end int
+	text       string
+}
+
+func applyEdits(src []byte, edits []textEdit) []byte {
+	sort.Slice(edits, func(i, j int) bool { return edits[i].start > edits[j].start })
+	out := append([]byte(nil), src...)
+	for _, e := range edits {
+		out = append(out[:e.start], append([]byte(e.text), out[e.end:]...)...)
+	}
+	return out
+}
+
+// syncToBazel rewrites the version (and checksum) of every rule whose module
+// is required by f at a different version. Replacements in f are honored for
+// rules that already carry a replace attribute. Checksums are taken from sums;
+// when none is known for the new version the stale sum attribute is removed.
+func syncToBazel(src []byte, rules []bazelRule, f *modfile.File, sums map[module.Version]string) []byte {
+	required := map[string]string{}
+	for _, r := range f.Require {
+		required[r.Mod.Path] = r.Mod.Version
+	}
+
+	var edits []textEdit
+	for _, rule := range rules {
+		path := rule.importPath()
+		version, ok := required[path]
+		if !ok {
+			continue
+		}
+		want := module.Version{Path: path, Version: version}
+		if newMod := replacedModule(f, path, version); newMod.Path != "" {
+			r, hasReplaceAttr := rule.attrs["replace"]
+			if !hasReplaceAttr || newMod.Version == "" {
+				fmt.Fprintf(os.Stderr, "(bazel) skip replaced module: %s -> %s\n", path, newMod)
+				continue
+			}
+			if r.value != newMod.Path {
+				edits = append(edits, textEdit{r.valueStart, r.valueEnd, newMod.Path})
+			}
+			want = newMod
+		}
+
+		v, ok := rule.attrs["version"]
+		if !ok || v.value == want.Version {
+			continue
+		}
+		fmt.Fprintf(os.Stderr, "(bazel) update: %s %s -> %s\n", path, v.value, want.Version)
+		edits = append(edits, textEdit{v.valueStart, v.valueEnd, want.Version})
+
+		if s, ok := rule.attrs["sum"]; ok {
+			if sum, ok := sums[want]; ok {
+				edits = append(edits, textEdit{s.valueStart, s.valueEnd, sum})
+			} else {
+				fmt.Fprintf(os.Stderr, "(bazel) drop unknown sum: %s\n", want)
+				edits = append(edits, removeAttr(src, s))
+			}
+		}
+	}
+	return applyEdits(src, edits)
+}
+
+// syncFromBazel updates the requirements of f to the versions found in the
+// Bazel rules. Rules for replaced modules are skipped, since their version
+// describes the replacement rather than the requirement.
+func syncFromBazel(f *modfile.File, rules []bazelRule) error {
+	for _, rule := range rules {
+		path := rule.importPath()
+		v, ok := rule.attrs["version"]
+		if !ok {
+			continue
+		}
+		if _, ok := rule.attrs["replace"]; ok {
+			fmt.Fprintf(os.Stderr, "(bazel) skip replaced module: %s\n", path)
+			continue
+		}
+		for _, r := range f.Require {
+			if r.Mod.Path != path || r.Mod.Version == v.value {
+				continue
+			}
+			fmt.Fprintf(os.Stderr, "(bazel) update: %s %s -> %s\n", path, r.Mod.Version, v.value)
+			if err := f.AddRequire(path, v.value); err != nil {
+				return err
+			}
+			break
+		}
+	}
+	return nil
+}
+
+// readGoSum reads the module (not go.mod) hashes of a go.sum file.
+func readGoSum(path string) (map[module.Version]string, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	sums := map[module.Version]string{}
+	for _, line := range strings.Split(string(content), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 3 || strings.HasSuffix(fields[1], "/go.mod") {
+			continue
+		}
+		sums[module.Version{Path: fields[0], Version: fields[1]}] = fields[2]
+	}
+	return sums, nil
+}
+
+// writeBazelMacro writes a deps.bzl-style file defining a macro that declares
+// a go_repository rule for every module required by f, in the same form
+// gazelle's update-repos produces. Modules replaced by local directories are
+// skipped since they can't be fetched as repositories.
+func writeBazelMacro(w io.Writer, f *modfile.File, macroName string, sums map[module.Version]string) error {
+	type repo struct {
+		name, importPath, replace, sum, version string
+	}
+	var repos []repo
+	for _, r := range f.Require {
+		mod := r.Mod
+		var replace string
+		if newMod := replacedModule(f, r.Mod.Path, r.Mod.Version); newMod.Path != "" {
+			if newMod.Version == "" {
+				fmt.Fprintf(os.Stderr, "(bazel) skip local replacement: %s -> %s\n", r.Mod.Path, newMod.Path)
+				continue
+			}
+			mod, replace = newMod, newMod.Path
+		}
+		repos = append(repos, repo{
+			name:       bazelRepoName(r.Mod.Path),
+			importPath: r.Mod.Path,
+			replace:    replace,
+			sum:        sums[mod],
+			version:    mod.Version,
+		})
+	}
+	sort.Slice(repos, func(i, j int) bool { return repos[i].name < repos[j].name })
+
+	var b strings.Builder
+	b.WriteString("load(\"@bazel_gazelle//:deps.bzl\", \"go_repository\")\n\n")
+	fmt.Fprintf(&b, "def %s():\n", macroName)
+	if len(repos) == 0 {
+		b.WriteString("    pass\n")
+	}
+	for _, r := range repos {
+		b.WriteString("    go_repository(\n")
+		fmt.Fprintf(&b, "        name = %q,\n", r.name)
+		fmt.Fprintf(&b, "        importpath = %q,\n", r.importPath)
+		if r.replace != "" {
+			fmt.Fprintf(&b, "        replace = %q,\n", r.replace)
+		}
+		if r.sum != "" {
+			fmt.Fprintf(&b, "        sum = %q,\n", r.sum)
+		}
+		fmt.Fprintf(&b, "        version = %q,\n", r.version)
+		b.WriteString("    )\n")
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// bazelRepoName converts a module path to the repository name gazelle would
+// choose for it (e.g. github.com/pkg/errors -> com_github_pkg_errors).
+func bazelRepoName(path string) string {
+	components := strings.Split(strings.ToLower(path), "/")
+	labels := strings.Split(components[0], ".")
+	var reversed []string
+	for i := len(labels) - 1; i >= 0; i-- {
+		reversed = append(reversed, labels[i])
+	}
+	repo := strings.Join(append(reversed, components[1:]...), ".")
+	return strings.NewReplacer("-", "_", ".", "_").Replace(repo)
+}
+
+// bazelMacroFile returns the content of the -bzl-macro file for the merged
+// module f. Checksums are taken from the go.sum files next to the destination
+// and source, since transplanted versions are usually only known to the
+// latter.
+func bazelMacroFile(macroName string, f *modfile.File, destFile, srcFile string) ([]byte, error) {
+	sums := map[module.Version]string{}
+	for _, dir := range []string{sourceDir(srcFile), filepath.Dir(destFile)} {
+		s, err := readGoSum(filepath.Join(dir, "go.sum"))
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		for k, v := range s {
+			sums[k] = v
+		}
+	}
+	var out bytes.Buffer
+	if err := writeBazelMacro(&out, f, macroName, sums); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
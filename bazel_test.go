@@ -0,0 +1,49 @@
+package main
+
+import (
+	"testing"
+
+	"golang.org/x/mod/modfile"
+	"golang.org/x/mod/module"
+)
+
+func TestSyncToBazelUnknownSum(t *testing.T) {
+	f, err := modfile.Parse("go.mod", []byte("module example.com/app\n\nrequire example.com/a v1.2.0\n"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		name, in, want string
+	}{
+		{
+			name: "one line",
+			in:   `go_repository(name = "com_example_a", importpath = "example.com/a", sum = "h1:old=", version = "v1.0.0")` + "\n",
+			want: `go_repository(name = "com_example_a", importpath = "example.com/a", version = "v1.2.0")` + "\n",
+		},
+		{
+			name: "one line, sum last",
+			in:   `go_repository(name = "com_example_a", importpath = "example.com/a", version = "v1.0.0", sum = "h1:old=")` + "\n",
+			want: `go_repository(name = "com_example_a", importpath = "example.com/a", version = "v1.2.0")` + "\n",
+		},
+		{
+			name: "one attribute per line",
+			in: "go_repository(\n" +
+				"    name = \"com_example_a\",\n" +
+				"    importpath = \"example.com/a\",\n" +
+				"    sum = \"h1:old=\",\n" +
+				"    version = \"v1.0.0\",\n" +
+				")\n",
+			want: "go_repository(\n" +
+				"    name = \"com_example_a\",\n" +
+				"    importpath = \"example.com/a\",\n" +
+				"    version = \"v1.2.0\",\n" +
+				")\n",
+		},
+	} {
+		src := []byte(tt.in)
+		got := string(syncToBazel(src, parseBazelRules(src), f, map[module.Version]string{}))
+		if got != tt.want {
+			t.Errorf("%s:\ngot:  %q\nwant: %q", tt.name, got, tt.want)
+		}
+	}
+}
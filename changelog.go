@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"text/template"
+
+	"golang.org/x/mod/semver"
+)
+
+// defaultChangelogTemplate renders a Markdown list of the dependency changes.
+const defaultChangelogTemplate = `### Dependencies
+
+{{range .Upgraded}}- Upgraded {{.Path}} {{.From}} → {{.To}}{{if .Indirect}} (indirect){{end}}
+{{end}}{{range .Downgraded}}- Downgraded {{.Path}} {{.From}} → {{.To}}{{if .Indirect}} (indirect){{end}}
+{{end}}{{range .Added}}- Added {{.Path}} {{.To}}{{if .Indirect}} (indirect){{end}}
+{{end}}{{range .Removed}}- Removed {{.Path}} {{.From}}
+{{end}}{{range .Replaced}}- Replaced {{.Path}}{{with .Version}} {{.}}{{end}} with {{.To}}
+{{end}}{{range .Unreplaced}}- Dropped the replacement of {{.Path}}{{with .Version}} {{.}}{{end}} with {{.From}}
+{{end}}`
+
+// changelog is what a changelog template is executed with.
+type changelog struct {
+	// Module is the destination's module path.
+	Module string
+	Dest   string
+	Src    string
+	// Upgraded, Downgraded, Added and Removed are the requirement changes,
+	// and Replaced and Unreplaced the replacements added or changed and
+	// removed.
+	Upgraded, Downgraded, Added, Removed []change
+	Replaced, Unreplaced                 []change
+	// Changes are all changes, including exclusions and ignored
+	// directories.
+	Changes []change
+}
+
+// parseChangelogTemplate parses the changelog template in the file at path,
+// or the default one if path is empty.
+func parseChangelogTemplate(path string) (*template.Template, error) {
+	text := defaultChangelogTemplate
+	if path != "" {
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		text = string(content)
+	}
+	tmpl, err := template.New("changelog").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("changelog template: %w", err)
+	}
+	return tmpl, nil
+}
+
+// renderChangelog renders the changelog fragment of the changes of a merge of
+// src into the module at destFile.
+func renderChangelog(tmpl *template.Template, module, destFile, src string, changes []change) ([]byte, error) {
+	data := changelog{Module: module, Dest: destFile, Src: src, Changes: changes}
+	for _, c := range changes {
+		switch {
+		case c.Kind == "require" && c.Action == actionAdd:
+			data.Added = append(data.Added, c)
+		case c.Kind == "require" && c.Action == actionRemove:
+			data.Removed = append(data.Removed, c)
+		case c.Kind == "require" && semver.Compare(c.To, c.From) > 0:
+			data.Upgraded = append(data.Upgraded, c)
+		case c.Kind == "require" && semver.Compare(c.To, c.From) < 0:
+			data.Downgraded = append(data.Downgraded, c)
+		case c.Kind == "replace" && c.Action == actionRemove:
+			data.Unreplaced = append(data.Unreplaced, c)
+		case c.Kind == "replace":
+			data.Replaced = append(data.Replaced, c)
+		}
+	}
+	var b bytes.Buffer
+	if err := tmpl.Execute(&b, data); err != nil {
+		return nil, fmt.Errorf("changelog template: %w", err)
+	}
+	return b.Bytes(), nil
+}
+
+// changelogFile returns where the changelog fragment of destFile is written:
+// path itself if it is absolute, and relative to the destination's directory
+// otherwise.
+func changelogFile(path, destFile string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(filepath.Dir(destFile), path)
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"sort"
+
+	"golang.org/x/mod/modfile"
+	"golang.org/x/mod/module"
+)
+
+// Actions of a change.
+const (
+	actionAdd    = "add"
+	actionUpdate = "update"
+	actionRemove = "remove"
+)
+
+// change is one difference between the destination before and after a merge.
+type change struct {
+	// Kind is "module" (for a renamed module, with the old and new path as
+	// From and To), "require", "replace", "exclude" or "ignore" (with the
+	// directory pattern as Path).
+	Kind   string `json:"kind"`
+	Action string `json:"action"`
+	Path   string `json:"path"`
+	// Version is the version a replace or exclude statement applies to.
+	Version string `json:"version,omitempty"`
+	// From and To are the required versions, or replacements, before and
+	// after.
+	From     string `json:"from,omitempty"`
+	To       string `json:"to,omitempty"`
+	Indirect bool   `json:"indirect,omitempty"`
+	// Why are the log lines of the steps of the merge that led to the
+	// change.
+	Why []string `json:"why,omitempty"`
+}
+
+// diffModFiles lists the changes that turn before into after: a module
+// rename first, then requirements, replacements, exclusions and ignored
+// directories, each ordered by path.
+func diffModFiles(before, after *modfile.File) []change {
+	var changes []change
+	if before.Module != nil && after.Module != nil && before.Module.Mod.Path != after.Module.Mod.Path {
+		changes = append(changes, change{Kind: "module", Action: actionUpdate, Path: after.Module.Mod.Path, From: before.Module.Mod.Path, To: after.Module.Mod.Path})
+	}
+
+	beforeReqs := map[string]*modfile.Require{}
+	for _, r := range before.Require {
+		beforeReqs[r.Mod.Path] = r
+	}
+	afterReqs := map[string]*modfile.Require{}
+	for _, r := range after.Require {
+		afterReqs[r.Mod.Path] = r
+		b, ok := beforeReqs[r.Mod.Path]
+		switch {
+		case !ok:
+			changes = append(changes, change{Kind: "require", Action: actionAdd, Path: r.Mod.Path, To: r.Mod.Version, Indirect: r.Indirect})
+		case b.Mod.Version != r.Mod.Version || b.Indirect != r.Indirect:
+			changes = append(changes, change{Kind: "require", Action: actionUpdate, Path: r.Mod.Path, From: b.Mod.Version, To: r.Mod.Version, Indirect: r.Indirect})
+		}
+	}
+	for _, r := range before.Require {
+		if _, ok := afterReqs[r.Mod.Path]; !ok {
+			changes = append(changes, change{Kind: "require", Action: actionRemove, Path: r.Mod.Path, From: r.Mod.Version, Indirect: r.Indirect})
+		}
+	}
+
+	beforeReps := map[module.Version]module.Version{}
+	for _, r := range before.Replace {
+		beforeReps[r.Old] = r.New
+	}
+	afterReps := map[module.Version]bool{}
+	for _, r := range after.Replace {
+		afterReps[r.Old] = true
+		b, ok := beforeReps[r.Old]
+		switch {
+		case !ok:
+			changes = append(changes, change{Kind: "replace", Action: actionAdd, Path: r.Old.Path, Version: r.Old.Version, To: r.New.String()})
+		case b != r.New:
+			changes = append(changes, change{Kind: "replace", Action: actionUpdate, Path: r.Old.Path, Version: r.Old.Version, From: b.String(), To: r.New.String()})
+		}
+	}
+	for _, r := range before.Replace {
+		if !afterReps[r.Old] {
+			changes = append(changes, change{Kind: "replace", Action: actionRemove, Path: r.Old.Path, Version: r.Old.Version, From: r.New.String()})
+		}
+	}
+
+	beforeExcl := map[module.Version]bool{}
+	for _, e := range before.Exclude {
+		beforeExcl[e.Mod] = true
+	}
+	afterExcl := map[module.Version]bool{}
+	for _, e := range after.Exclude {
+		afterExcl[e.Mod] = true
+		if !beforeExcl[e.Mod] {
+			changes = append(changes, change{Kind: "exclude", Action: actionAdd, Path: e.Mod.Path, Version: e.Mod.Version})
+		}
+	}
+	for _, e := range before.Exclude {
+		if !afterExcl[e.Mod] {
+			changes = append(changes, change{Kind: "exclude", Action: actionRemove, Path: e.Mod.Path, Version: e.Mod.Version})
+		}
+	}
+
+	beforeIgn := map[string]bool{}
+	for _, i := range before.Ignore {
+		beforeIgn[i.Path] = true
+	}
+	afterIgn := map[string]bool{}
+	for _, i := range after.Ignore {
+		afterIgn[i.Path] = true
+		if !beforeIgn[i.Path] {
+			changes = append(changes, change{Kind: "ignore", Action: actionAdd, Path: i.Path})
+		}
+	}
+	for _, i := range before.Ignore {
+		if !afterIgn[i.Path] {
+			changes = append(changes, change{Kind: "ignore", Action: actionRemove, Path: i.Path})
+		}
+	}
+
+	kindOrder := map[string]int{"module": 0, "require": 1, "replace": 2, "exclude": 3, "ignore": 4}
+	sort.SliceStable(changes, func(i, j int) bool {
+		if changes[i].Kind != changes[j].Kind {
+			return kindOrder[changes[i].Kind] < kindOrder[changes[j].Kind]
+		}
+		if changes[i].Path != changes[j].Path {
+			return changes[i].Path < changes[j].Path
+		}
+		return changes[i].Version < changes[j].Version
+	})
+	return changes
+}
+
+// cloneModFile returns an independent copy of f.
+func cloneModFile(f *modfile.File) (*modfile.File, error) {
+	out, err := f.Format()
+	if err != nil {
+		return nil, err
+	}
+	return modfile.Parse(f.Syntax.Name, out, nil)
+}
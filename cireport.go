@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// writeBuildkiteReport writes the Markdown report of the results to path and,
+// when running in a Buildkite job, adds it to the build as an annotation,
+// styled as an error if any destination failed.
+func writeBuildkiteReport(ctx context.Context, path, srcFile string, results []fileResult) error {
+	var b bytes.Buffer
+	if err := writeMarkdownReport(&b, srcFile, results); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(path, b.Bytes(), 0o644); err != nil {
+		return err
+	}
+	if os.Getenv("BUILDKITE") != "true" {
+		return nil
+	}
+	if _, err := exec.LookPath("buildkite-agent"); err != nil {
+		fmt.Fprintf(os.Stderr, "(report) buildkite-agent not found; annotation left in %s\n", path)
+		return nil
+	}
+	style := "success"
+	for _, r := range results {
+		if r.Err != nil {
+			style = "error"
+		}
+	}
+	cmd := exec.CommandContext(ctx, "buildkite-agent", "annotate", "--context", "modtransplant", "--style", style)
+	cmd.Stdin = &b
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("buildkite-agent annotate: %s", strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// writeTeamCityReport writes the results as TeamCity service messages: a
+// block per destination with a message per change, a build problem for each
+// failed destination, and the number of changes as a build statistic.
+func writeTeamCityReport(w io.Writer, results []fileResult) error {
+	var total int
+	for _, r := range results {
+		name := r.Dest
+		if r.Module != "" {
+			name += " (" + r.Module + ")"
+		}
+		fmt.Fprintf(w, "##teamcity[blockOpened name='%s']\n", teamCityEscape("modtransplant "+name))
+		if r.Err != nil {
+			summary := strings.SplitN(r.Err.Error(), "\n", 2)[0]
+			fmt.Fprintf(w, "##teamcity[message text='%s' errorDetails='%s' status='ERROR']\n", teamCityEscape(r.Dest+": "+summary), teamCityEscape(r.Err.Error()))
+			fmt.Fprintf(w, "##teamcity[buildProblem description='%s' identity='%s']\n", teamCityEscape("modtransplant: "+r.Dest+": "+summary), teamCityEscape("modtransplant:"+r.Dest))
+		} else {
+			fmt.Fprintf(w, "##teamcity[message text='%s' status='NORMAL']\n", teamCityEscape(fmt.Sprintf("%s: %d change%s", r.Dest, len(r.Changes), plural(len(r.Changes)))))
+			for _, c := range r.Changes {
+				fmt.Fprintf(w, "##teamcity[message text='%s' status='NORMAL']\n", teamCityEscape(changeText(c)))
+			}
+			if r.Vendor != nil {
+				fmt.Fprintf(w, "##teamcity[message text='%s' status='NORMAL']\n", teamCityEscape(fmt.Sprintf("vendor files: %s; modules: %s", r.Vendor.Files, r.Vendor.Modules)))
+			}
+			total += len(r.Changes)
+		}
+		fmt.Fprintf(w, "##teamcity[blockClosed name='%s']\n", teamCityEscape("modtransplant "+name))
+	}
+	_, err := fmt.Fprintf(w, "##teamcity[buildStatisticValue key='modtransplant.changes' value='%d']\n", total)
+	return err
+}
+
+// changeText describes c on one line.
+func changeText(c change) string {
+	s := c.Kind + " " + c.Action
+	if c.Path != "" {
+		s += " " + c.Path
+	}
+	if c.Version != "" {
+		s += "@" + c.Version
+	}
+	switch {
+	case c.From != "" && c.To != "":
+		s += ": " + c.From + " -> " + c.To
+	case c.To != "":
+		s += ": " + c.To
+	case c.From != "":
+		s += ": " + c.From
+	}
+	return s
+}
+
+// teamCityEscape escapes s for a value of a TeamCity service message.
+var teamCityEscape = strings.NewReplacer(
+	"|", "||",
+	"'", "|'",
+	"\n", "|n",
+	"\r", "|r",
+	"[", "|[",
+	"]", "|]",
+).Replace
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"text/template"
+)
+
+// commitMsgBody is the template of the body of the commit message of a merge,
+// executed like a changelog template.
+var commitMsgBody = template.Must(template.New("commit-msg").Parse(`{{with .Upgraded}}
+Upgraded:
+{{range .}}	{{.Path}} {{.From}} -> {{.To}}
+{{end}}{{end}}{{with .Downgraded}}
+Downgraded:
+{{range .}}	{{.Path}} {{.From}} -> {{.To}}
+{{end}}{{end}}{{with .Added}}
+Added:
+{{range .}}	{{.Path}} {{.To}}{{if .Indirect}} // indirect{{end}}
+{{end}}{{end}}{{with .Removed}}
+Removed:
+{{range .}}	{{.Path}} {{.From}}
+{{end}}{{end}}{{with .Replaced}}
+Replaced:
+{{range .}}	{{.Path}}{{with .Version}} {{.}}{{end}} => {{.To}}
+{{end}}{{end}}{{with .Unreplaced}}
+Dropped replacements:
+{{range .}}	{{.Path}}{{with .Version}} {{.}}{{end}} => {{.From}}
+{{end}}{{end}}`))
+
+// writeCommitMsg writes a commit message for the changes of a merge of the
+// source module srcModule, read from srcFile, into the module at destFile:
+// a subject naming the destination's directory in the repository (or go.mod
+// at its root) and the source, and a body listing the changes.
+func writeCommitMsg(w io.Writer, destFile, module, srcFile, srcModule string, changes []change) error {
+	scope := "go.mod"
+	if destFile != stdinDest {
+		dir, err := filepath.Abs(filepath.Dir(destFile))
+		if err != nil {
+			return err
+		}
+		root, err := repoRoot(dir)
+		if err != nil {
+			return err
+		}
+		if rel, err := filepath.Rel(root, dir); err == nil && rel != "." {
+			scope = filepath.ToSlash(rel)
+		}
+	}
+	if srcModule == "" {
+		srcModule = srcFile
+	}
+	fmt.Fprintf(w, "%s: transplant dependencies from %s\n\n", scope, srcModule)
+	fmt.Fprintf(w, "Merge the dependencies of %s into %s.\n", srcFile, module)
+	body, err := renderChangelog(commitMsgBody, module, destFile, srcFile, changes)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(body)
+	return err
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"go/version"
+	"io/ioutil"
+
+	"github.com/brettbuddin/modtransplant/transplant"
+)
+
+// config is the content of the file named by -config. Settings there cover
+// what doesn't map onto flags.
+type config struct {
+	// ConflictPolicy is a CEL expression deciding how each require or
+	// replace conflict is resolved; see newCELResolver.
+	ConflictPolicy string `json:"conflict_policy"`
+	// Hooks are shell commands run at points of a merge; see runHook.
+	Hooks struct {
+		PreMerge   string `json:"pre-merge"`
+		PostMerge  string `json:"post-merge"`
+		OnConflict string `json:"on-conflict"`
+	} `json:"hooks"`
+	// GoDirective bounds the go directive of the merged go.mod; see
+	// checkGoDirective.
+	GoDirective struct {
+		Min string `json:"min"`
+		Max string `json:"max"`
+	} `json:"go_directive"`
+	// Freeze lists glob path prefixes of frozen modules, in addition to
+	// those of -freeze; see checkFrozen.
+	Freeze []string `json:"freeze"`
+	// Mapping pairs the modules of a source directory with the destinations
+	// they are merged into; see mergeMapping.
+	Mapping []mappingEntry `json:"mapping"`
+}
+
+// mappingEntry pairs a module of the source directory, Src, with the
+// destination it is merged into, Dest. Src is the module's directory (or
+// go.mod file) relative to -src, and Dest the destination's go.mod file (or
+// directory) relative to -dest.
+type mappingEntry struct {
+	Src  string `json:"src"`
+	Dest string `json:"dest"`
+}
+
+// loadConfig reads a JSON config file. An empty path yields the zero config.
+func loadConfig(path string) (*config, error) {
+	var c config
+	if path == "" {
+		return &c, nil
+	}
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(content, &c); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	for i, m := range c.Mapping {
+		if m.Src == "" || m.Dest == "" {
+			return nil, fmt.Errorf("%s: mapping entry %d needs both src and dest", path, i+1)
+		}
+	}
+	for _, v := range []string{c.GoDirective.Min, c.GoDirective.Max} {
+		if v != "" && !version.IsValid("go"+v) {
+			return nil, fmt.Errorf("%s: invalid go version %q in go_directive", path, v)
+		}
+	}
+	return &c, nil
+}
+
+// resolver returns the conflict resolver described by the config, or nil.
+func (c *config) resolver() (transplant.Resolver, error) {
+	if c.ConflictPolicy == "" {
+		return nil, nil
+	}
+	return newCELResolver(c.ConflictPolicy)
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"golang.org/x/mod/modfile"
+)
+
+// confirmChanges shows each of the changes turning before into after as a
+// small diff on w, and asks on in whether to apply it. Declined changes are
+// reverted in after. Quitting declines the change asked about and all the
+// following ones.
+func confirmChanges(in io.Reader, w io.Writer, before, after *modfile.File, changes []change) error {
+	r := bufio.NewReader(in)
+	var quit bool
+	for i, c := range changes {
+		apply := false
+		if !quit {
+			fmt.Fprintf(w, "(%d/%d) %s %s %s\n", i+1, len(changes), c.Kind, c.Action, c.Path)
+			if line := entryLine(before, c); line != nil {
+				fmt.Fprintf(w, "- %s\n", lineText(c.Kind, line))
+			}
+			if line := entryLine(after, c); line != nil {
+				fmt.Fprintf(w, "+ %s\n", lineText(c.Kind, line))
+			}
+			for _, why := range c.Why {
+				fmt.Fprintf(w, "\t%s\n", why)
+			}
+			for answered := false; !answered; {
+				fmt.Fprint(w, "Apply this change [y,n,q]? ")
+				answer, err := r.ReadString('\n')
+				if err != nil && answer == "" {
+					if errors.Is(err, io.EOF) {
+						return errors.New("-confirm: no answer")
+					}
+					return fmt.Errorf("-confirm: %w", err)
+				}
+				answered = true
+				switch strings.ToLower(strings.TrimSpace(answer)) {
+				case "y", "yes":
+					apply = true
+				case "n", "no":
+				case "q", "quit":
+					quit = true
+				default:
+					fmt.Fprintln(w, "y - apply the change, n - leave it out, q - leave it and all following changes out")
+					answered = false
+				}
+			}
+		}
+		if !apply {
+			if err := revertChange(before, after, c); err != nil {
+				return err
+			}
+		}
+	}
+	after.Cleanup()
+	after.SetRequire(after.Require)
+	return nil
+}
+
+// revertChange turns the entry of after that c changed back into what it was
+// in before.
+func revertChange(before, after *modfile.File, c change) error {
+	switch c.Kind {
+	case "module":
+		return after.AddModuleStmt(c.From)
+	case "require":
+		var prev, cur *modfile.Require
+		for _, r := range before.Require {
+			if r.Mod.Path == c.Path {
+				prev = r
+			}
+		}
+		for _, r := range after.Require {
+			if r.Mod.Path == c.Path {
+				cur = r
+			}
+		}
+		switch {
+		case prev != nil && cur != nil:
+			cur.Mod.Version, cur.Indirect = prev.Mod.Version, prev.Indirect
+		case prev != nil:
+			after.AddNewRequire(prev.Mod.Path, prev.Mod.Version, prev.Indirect)
+		default:
+			return after.DropRequire(c.Path)
+		}
+	case "replace":
+		for _, r := range before.Replace {
+			if r.Old.Path == c.Path && r.Old.Version == c.Version {
+				return after.AddReplace(r.Old.Path, r.Old.Version, r.New.Path, r.New.Version)
+			}
+		}
+		return after.DropReplace(c.Path, c.Version)
+	case "exclude":
+		if c.Action == actionRemove {
+			return after.AddExclude(c.Path, c.Version)
+		}
+		return after.DropExclude(c.Path, c.Version)
+	case "ignore":
+		if c.Action == actionRemove {
+			return after.AddIgnore(c.Path)
+		}
+		return after.DropIgnore(c.Path)
+	default:
+		return fmt.Errorf("can't revert %s %s", c.Kind, c.Action)
+	}
+	return nil
+}
+
+// entryLine returns the line of f holding the entry c is about, or nil if f
+// has none.
+func entryLine(f *modfile.File, c change) *modfile.Line {
+	switch c.Kind {
+	case "module":
+		if f.Module != nil {
+			return f.Module.Syntax
+		}
+	case "require":
+		for _, r := range f.Require {
+			if r.Mod.Path == c.Path {
+				return r.Syntax
+			}
+		}
+	case "replace":
+		for _, r := range f.Replace {
+			if r.Old.Path == c.Path && r.Old.Version == c.Version {
+				return r.Syntax
+			}
+		}
+	case "exclude":
+		for _, e := range f.Exclude {
+			if e.Mod.Path == c.Path && e.Mod.Version == c.Version {
+				return e.Syntax
+			}
+		}
+	case "ignore":
+		for _, i := range f.Ignore {
+			if i.Path == c.Path {
+				return i.Syntax
+			}
+		}
+	}
+	return nil
+}
+
+// lineText formats line as a statement of the given kind, whether or not it
+// is part of a block, with its trailing comment.
+func lineText(kind string, line *modfile.Line) string {
+	tokens := line.Token
+	if len(tokens) == 0 || tokens[0] != kind {
+		tokens = append([]string{kind}, tokens...)
+	}
+	for _, c := range line.Comments.Suffix {
+		tokens = append(tokens, c.Token)
+	}
+	return strings.Join(tokens, " ")
+}
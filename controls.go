@@ -0,0 +1,152 @@
+package main
+
+import (
+	"strings"
+
+	"golang.org/x/mod/modfile"
+)
+
+// Control comments a line of the destination can carry to take its module
+// out of the merge's hands.
+const (
+	// controlKeep keeps the destination's entries of the module as they
+	// are; what the source would have changed is still logged.
+	controlKeep = "modtransplant:keep"
+	// controlSkip leaves the module out of the merge altogether.
+	controlSkip = "modtransplant:skip"
+)
+
+// lineControl returns the control comment of line, or "" if it has none.
+func lineControl(line *modfile.Line) string {
+	if line == nil {
+		return ""
+	}
+	for _, comments := range [][]modfile.Comment{line.Comments.Before, line.Comments.Suffix} {
+		for _, c := range comments {
+			for _, field := range strings.Fields(strings.TrimPrefix(c.Token, "//")) {
+				switch field = strings.TrimRight(field, ",;"); field {
+				case controlKeep, controlSkip:
+					return field
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// destControls returns the control of each module with a require, replace or
+// exclude line of f carrying a control comment. A module with lines carrying
+// both is skipped.
+func destControls(f *modfile.File) map[string]string {
+	controls := map[string]string{}
+	add := func(path string, line *modfile.Line) {
+		if c := lineControl(line); c != "" && controls[path] != controlSkip {
+			controls[path] = c
+		}
+	}
+	for _, r := range f.Require {
+		add(r.Mod.Path, r.Syntax)
+	}
+	for _, r := range f.Replace {
+		add(r.Old.Path, r.Syntax)
+	}
+	for _, e := range f.Exclude {
+		add(e.Mod.Path, e.Syntax)
+	}
+	return controls
+}
+
+// holdControlled drops the requirements, replacements and exclusions of the
+// modules controlled in dest from src, so the merge leaves them alone. For
+// kept modules, the source's entries that differ from the destination's are
+// recorded in t.
+func holdControlled(dest, src *modfile.File, t trace) error {
+	controls := destControls(dest)
+	if len(controls) == 0 {
+		return nil
+	}
+	destRequires := map[string]*modfile.Require{}
+	for _, r := range dest.Require {
+		destRequires[r.Mod.Path] = r
+	}
+	destReplaces := map[string]string{}
+	for _, r := range dest.Replace {
+		destReplaces[r.Old.String()] = r.New.String()
+	}
+	destExcludes := map[string]bool{}
+	for _, e := range dest.Exclude {
+		destExcludes[e.Mod.String()] = true
+	}
+
+	skipped := map[string]bool{}
+	held := func(path, format string, args ...interface{}) bool {
+		switch controls[path] {
+		case controlKeep:
+			if format != "" {
+				t.logf(path, "(control) keep: "+format, args...)
+			}
+			return true
+		case controlSkip:
+			if !skipped[path] {
+				t.logf(path, "(control) skip: %s", path)
+				skipped[path] = true
+			}
+			return true
+		}
+		return false
+	}
+
+	// Dropping an entry clears it, so the entries are copied first.
+	var requires []modfile.Require
+	for _, r := range src.Require {
+		requires = append(requires, *r)
+	}
+	for _, r := range requires {
+		var format string
+		var args []interface{}
+		if d, ok := destRequires[r.Mod.Path]; !ok {
+			format, args = "%s (src requires %s)", []interface{}{r.Mod.Path, r.Mod.Version}
+		} else if d.Mod.Version != r.Mod.Version || d.Indirect && !r.Indirect {
+			format, args = "%s (src requires %s%s)", []interface{}{d.Mod, r.Mod.Version, indirectComment(r.Indirect)}
+		}
+		if held(r.Mod.Path, format, args...) {
+			if err := src.DropRequire(r.Mod.Path); err != nil {
+				return err
+			}
+		}
+	}
+
+	var replaces []modfile.Replace
+	for _, r := range src.Replace {
+		replaces = append(replaces, *r)
+	}
+	for _, r := range replaces {
+		var format string
+		if to, ok := destReplaces[r.Old.String()]; !ok || to != r.New.String() {
+			format = "replacement of %s (src replaces it with %s)"
+		}
+		if held(r.Old.Path, format, r.Old, r.New) {
+			if err := src.DropReplace(r.Old.Path, r.Old.Version); err != nil {
+				return err
+			}
+		}
+	}
+
+	var excludes []modfile.Exclude
+	for _, e := range src.Exclude {
+		excludes = append(excludes, *e)
+	}
+	for _, e := range excludes {
+		var format string
+		if !destExcludes[e.Mod.String()] {
+			format = "%s (src excludes it)"
+		}
+		if held(e.Mod.Path, format, e.Mod) {
+			if err := src.DropExclude(e.Mod.Path, e.Mod.Version); err != nil {
+				return err
+			}
+		}
+	}
+	src.Cleanup()
+	return nil
+}
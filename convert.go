@@ -0,0 +1,423 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"golang.org/x/mod/modfile"
+)
+
+// modDocument is the canonical structured form of a go.mod file, for generic
+// diff and patch tooling. Statements are in the order of the file, and every
+// list is flat, so that the YAML form needs nothing but scalars, lists and
+// lists of mappings.
+type modDocument struct {
+	Module     string       `json:"module"`
+	Deprecated string       `json:"deprecated,omitempty"`
+	Go         string       `json:"go,omitempty"`
+	Toolchain  string       `json:"toolchain,omitempty"`
+	Godebug    []godebugDoc `json:"godebug,omitempty"`
+	Require    []requireDoc `json:"require,omitempty"`
+	Exclude    []moduleDoc  `json:"exclude,omitempty"`
+	Replace    []replaceDoc `json:"replace,omitempty"`
+	Retract    []retractDoc `json:"retract,omitempty"`
+	Tool       []string     `json:"tool,omitempty"`
+	Ignore     []string     `json:"ignore,omitempty"`
+}
+
+type godebugDoc struct {
+	Key   string `json:"key"`
+	Value string `json:"value"`
+}
+
+type requireDoc struct {
+	Path     string `json:"path"`
+	Version  string `json:"version"`
+	Indirect bool   `json:"indirect,omitempty"`
+}
+
+type moduleDoc struct {
+	Path    string `json:"path"`
+	Version string `json:"version"`
+}
+
+type replaceDoc struct {
+	OldPath    string `json:"old_path"`
+	OldVersion string `json:"old_version,omitempty"`
+	NewPath    string `json:"new_path"`
+	NewVersion string `json:"new_version,omitempty"`
+}
+
+type retractDoc struct {
+	Low       string `json:"low"`
+	High      string `json:"high"`
+	Rationale string `json:"rationale,omitempty"`
+}
+
+// runConvert translates a go.mod file to its canonical YAML or JSON document,
+// or such a document back to a go.mod file. The direction follows the
+// extension of the input: .json, .yaml and .yml files are documents.
+func runConvert(ctx context.Context, args []string) error {
+	var format string
+	fs := flag.NewFlagSet("modtransplant convert", flag.ExitOnError)
+	fs.StringVar(&format, "format", "yaml", "document format a go.mod file is converted to (yaml or json)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New(usage)
+	}
+	path := fs.Arg(0)
+
+	var out []byte
+	switch filepath.Ext(path) {
+	case ".json", ".yaml", ".yml":
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		var doc modDocument
+		if filepath.Ext(path) == ".json" {
+			err = json.Unmarshal(content, &doc)
+		} else {
+			err = unmarshalYAML(content, &doc)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		f, err := doc.modFile()
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		if out, err = f.Format(); err != nil {
+			return err
+		}
+	default:
+		f, err := parseModFile(path)
+		if err != nil {
+			return err
+		}
+		doc := newModDocument(f)
+		switch format {
+		case "yaml":
+			out = marshalYAML(doc)
+		case "json":
+			if out, err = json.MarshalIndent(doc, "", "  "); err != nil {
+				return err
+			}
+			out = append(out, '\n')
+		default:
+			return fmt.Errorf("unsupported format %q", format)
+		}
+	}
+	_, err := os.Stdout.Write(out)
+	return err
+}
+
+// newModDocument returns the document of f.
+func newModDocument(f *modfile.File) modDocument {
+	var doc modDocument
+	if f.Module != nil {
+		doc.Module = f.Module.Mod.Path
+		doc.Deprecated = f.Module.Deprecated
+	}
+	if f.Go != nil {
+		doc.Go = f.Go.Version
+	}
+	if f.Toolchain != nil {
+		doc.Toolchain = f.Toolchain.Name
+	}
+	for _, g := range f.Godebug {
+		doc.Godebug = append(doc.Godebug, godebugDoc{Key: g.Key, Value: g.Value})
+	}
+	for _, r := range f.Require {
+		doc.Require = append(doc.Require, requireDoc{Path: r.Mod.Path, Version: r.Mod.Version, Indirect: r.Indirect})
+	}
+	for _, e := range f.Exclude {
+		doc.Exclude = append(doc.Exclude, moduleDoc{Path: e.Mod.Path, Version: e.Mod.Version})
+	}
+	for _, r := range f.Replace {
+		doc.Replace = append(doc.Replace, replaceDoc{OldPath: r.Old.Path, OldVersion: r.Old.Version, NewPath: r.New.Path, NewVersion: r.New.Version})
+	}
+	for _, r := range f.Retract {
+		doc.Retract = append(doc.Retract, retractDoc{Low: r.Low, High: r.High, Rationale: r.Rationale})
+	}
+	for _, t := range f.Tool {
+		doc.Tool = append(doc.Tool, t.Path)
+	}
+	for _, i := range f.Ignore {
+		doc.Ignore = append(doc.Ignore, i.Path)
+	}
+	return doc
+}
+
+// modFile builds the go.mod file of the document. Statements are validated as
+// go.mod parsing would.
+func (doc modDocument) modFile() (*modfile.File, error) {
+	if doc.Module == "" {
+		return nil, errors.New("document has no module")
+	}
+	f := &modfile.File{Syntax: &modfile.FileSyntax{}}
+	if err := f.AddModuleStmt(doc.Module); err != nil {
+		return nil, err
+	}
+	if doc.Deprecated != "" {
+		f.Module.Syntax.Before = append(f.Module.Syntax.Before, modfile.Comment{Token: "// Deprecated: " + doc.Deprecated})
+		f.Module.Deprecated = doc.Deprecated
+	}
+	if doc.Go != "" {
+		if err := f.AddGoStmt(doc.Go); err != nil {
+			return nil, err
+		}
+	}
+	if doc.Toolchain != "" {
+		if err := f.AddToolchainStmt(doc.Toolchain); err != nil {
+			return nil, err
+		}
+	}
+	for _, g := range doc.Godebug {
+		if err := f.AddGodebug(g.Key, g.Value); err != nil {
+			return nil, err
+		}
+	}
+	for _, r := range doc.Require {
+		f.AddNewRequire(r.Path, r.Version, r.Indirect)
+	}
+	f.SetRequireSeparateIndirect(f.Require)
+	for _, e := range doc.Exclude {
+		if err := f.AddExclude(e.Path, e.Version); err != nil {
+			return nil, err
+		}
+	}
+	for _, r := range doc.Replace {
+		if err := f.AddReplace(r.OldPath, r.OldVersion, r.NewPath, r.NewVersion); err != nil {
+			return nil, err
+		}
+	}
+	for _, r := range doc.Retract {
+		if err := f.AddRetract(modfile.VersionInterval{Low: r.Low, High: r.High}, r.Rationale); err != nil {
+			return nil, err
+		}
+	}
+	for _, t := range doc.Tool {
+		if err := f.AddTool(t); err != nil {
+			return nil, err
+		}
+	}
+	for _, i := range doc.Ignore {
+		if err := f.AddIgnore(i); err != nil {
+			return nil, err
+		}
+	}
+	f.Cleanup()
+	// Reparse, so that requirements are checked the way they would be in a
+	// go.mod file.
+	out, err := f.Format()
+	if err != nil {
+		return nil, err
+	}
+	return modfile.Parse("go.mod", out, nil)
+}
+
+// marshalYAML writes v, a struct of the shape of modDocument, as YAML, with
+// the fields in the order of the struct and empty ones left out.
+func marshalYAML(v interface{}) []byte {
+	var b bytes.Buffer
+	rv := reflect.ValueOf(v)
+	for i := 0; i < rv.NumField(); i++ {
+		name, field := yamlName(rv.Type().Field(i)), rv.Field(i)
+		switch {
+		case field.IsZero():
+		case field.Kind() == reflect.Slice:
+			fmt.Fprintf(&b, "%s:\n", name)
+			for j := 0; j < field.Len(); j++ {
+				item := field.Index(j)
+				if item.Kind() != reflect.Struct {
+					fmt.Fprintf(&b, "  - %s\n", yamlScalar(item))
+					continue
+				}
+				prefix := "  - "
+				for k := 0; k < item.NumField(); k++ {
+					if item.Field(k).IsZero() {
+						continue
+					}
+					fmt.Fprintf(&b, "%s%s: %s\n", prefix, yamlName(item.Type().Field(k)), yamlScalar(item.Field(k)))
+					prefix = "    "
+				}
+			}
+		default:
+			fmt.Fprintf(&b, "%s: %s\n", name, yamlScalar(field))
+		}
+	}
+	return b.Bytes()
+}
+
+// yamlName returns the key of a field: its JSON name.
+func yamlName(f reflect.StructField) string {
+	name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+	return name
+}
+
+// yamlScalar formats a string or bool, quoting strings YAML would otherwise
+// read as something else, such as a go version read as a number.
+func yamlScalar(v reflect.Value) string {
+	if v.Kind() == reflect.Bool {
+		return strconv.FormatBool(v.Bool())
+	}
+	s := v.String()
+	if s == "" || s != strings.TrimSpace(s) || strings.ContainsAny(s[:1], "!&*-?[]{}|>'\"%@`#,:") ||
+		strings.Contains(s, ": ") || strings.Contains(s, " #") {
+		return strconv.Quote(s)
+	}
+	switch strings.ToLower(s) {
+	case "true", "false", "yes", "no", "on", "off", "null", "~":
+		return strconv.Quote(s)
+	}
+	if _, err := strconv.ParseFloat(s, 64); err == nil {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+// unmarshalYAML reads the subset of YAML marshalYAML writes into v: a mapping
+// of scalars and of lists of scalars or of mappings of scalars. The document
+// is turned into JSON and decoded as such, so that both forms are read by
+// the same rules.
+func unmarshalYAML(content []byte, v interface{}) error {
+	doc := map[string]interface{}{}
+	var (
+		key  string                 // key of the list being read
+		item map[string]interface{} // mapping item being read
+	)
+	for i, line := range strings.Split(string(content), "\n") {
+		line = stripYAMLComment(line)
+		if strings.TrimSpace(line) == "" || strings.TrimSpace(line) == "---" {
+			continue
+		}
+		indent := len(line) - len(strings.TrimLeft(line, " "))
+		text := strings.TrimSpace(line)
+		switch {
+		case indent == 0:
+			k, val, ok := strings.Cut(text, ":")
+			if !ok {
+				return fmt.Errorf("line %d: expected key: value", i+1)
+			}
+			key, item = "", nil
+			val = strings.TrimSpace(val)
+			switch val {
+			case "":
+				key = k
+				doc[k] = []interface{}{}
+			case "[]":
+				doc[k] = []interface{}{}
+			default:
+				s, err := yamlValue(val)
+				if err != nil {
+					return fmt.Errorf("line %d: %w", i+1, err)
+				}
+				doc[k] = s
+			}
+		case key == "":
+			return fmt.Errorf("line %d: unexpected indentation", i+1)
+		case strings.HasPrefix(text, "- ") || text == "-":
+			text = strings.TrimSpace(strings.TrimPrefix(text, "-"))
+			item = nil
+			k, val, isMap := cutYAMLKey(text)
+			if !isMap {
+				s, err := yamlValue(text)
+				if err != nil {
+					return fmt.Errorf("line %d: %w", i+1, err)
+				}
+				doc[key] = append(doc[key].([]interface{}), s)
+				continue
+			}
+			item = map[string]interface{}{}
+			doc[key] = append(doc[key].([]interface{}), item)
+			s, err := yamlValue(val)
+			if err != nil {
+				return fmt.Errorf("line %d: %w", i+1, err)
+			}
+			item[k] = s
+		case item != nil:
+			k, val, ok := cutYAMLKey(text)
+			if !ok {
+				return fmt.Errorf("line %d: expected key: value", i+1)
+			}
+			s, err := yamlValue(val)
+			if err != nil {
+				return fmt.Errorf("line %d: %w", i+1, err)
+			}
+			item[k] = s
+		default:
+			return fmt.Errorf("line %d: expected a list item", i+1)
+		}
+	}
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	return dec.Decode(v)
+}
+
+// cutYAMLKey splits a "key: value" mapping entry. Quoted scalars are never
+// entries.
+func cutYAMLKey(text string) (key, value string, ok bool) {
+	if strings.HasPrefix(text, `"`) || strings.HasPrefix(text, "'") {
+		return "", "", false
+	}
+	if strings.HasSuffix(text, ":") {
+		return strings.TrimSuffix(text, ":"), "", true
+	}
+	key, value, ok = strings.Cut(text, ": ")
+	return key, strings.TrimSpace(value), ok
+}
+
+// yamlValue reads a scalar: a bool, or a plain, single- or double-quoted
+// string.
+func yamlValue(s string) (interface{}, error) {
+	switch {
+	case s == "true" || s == "false":
+		return s == "true", nil
+	case strings.HasPrefix(s, `"`):
+		return strconv.Unquote(s)
+	case strings.HasPrefix(s, "'"):
+		if len(s) < 2 || !strings.HasSuffix(s, "'") {
+			return nil, fmt.Errorf("unterminated string %s", s)
+		}
+		return strings.ReplaceAll(s[1:len(s)-1], "''", "'"), nil
+	}
+	return s, nil
+}
+
+// stripYAMLComment removes a comment from a line: a "#" at its start or after
+// a space, outside of quotes.
+func stripYAMLComment(line string) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#' && (i == 0 || line[i-1] == ' '):
+			return line[:i]
+		}
+	}
+	return line
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+
+	"github.com/brettbuddin/modtransplant/transplant"
+)
+
+// debugTrace records every comparison and rule evaluation of the merge into
+// one destination, for -debug-trace.
+type debugTrace struct {
+	Dest  string            `json:"dest"`
+	Steps []transplant.Step `json:"steps"`
+	// Err is the error the merge failed with, if it did.
+	Err string `json:"error,omitempty"`
+}
+
+// writeDebugTrace writes the traces to path as JSON.
+func writeDebugTrace(path string, traces []debugTrace) error {
+	for i := range traces {
+		if traces[i].Steps == nil {
+			traces[i].Steps = []transplant.Step{}
+		}
+	}
+	out, err := json.MarshalIndent(traces, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append(out, '\n'), 0o644)
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"golang.org/x/mod/modfile"
+	"golang.org/x/mod/module"
+	"golang.org/x/mod/semver"
+
+	"github.com/brettbuddin/modtransplant/transplant"
+)
+
+// deepenSource adds the transitive requirements of src to it, at the versions
+// minimal version selection picks for them, so the destination gets the
+// source's build list instead of just its literal requirements. The module
+// graph is loaded level by level through the module proxy (or the module
+// cache) the way the go command loads it, following the replacements of src:
+// from go 1.17 on, the requirements of a module that is itself at go 1.17 or
+// higher are part of the graph but not loaded any further (graph pruning).
+// Requirements on versions src excludes are ignored. Modules new to src are
+// added as indirect requirements, and existing requirements are raised where
+// the graph needs a higher version.
+func deepenSource(ctx context.Context, src *modfile.File, srcFile string, opts *netOptions, t trace) error {
+	p, err := newModuleFetcher(opts)
+	if err != nil {
+		return err
+	}
+	excluded := map[module.Version]bool{}
+	for _, e := range src.Exclude {
+		excluded[e.Mod] = true
+	}
+
+	// A node of the graph is loaded pruned if only its own requirements are
+	// part of the graph, and unpruned if theirs are as well, transitively.
+	type node struct {
+		mod      module.Version
+		unpruned bool
+	}
+	selected := map[string]string{}
+	loaded := map[node]bool{}
+	// via records the module that first required each version, to explain
+	// where it comes from.
+	via := map[module.Version]module.Version{}
+	roots := map[module.Version]bool{}
+	var level []node
+	for _, r := range src.Require {
+		roots[r.Mod] = true
+		level = append(level, node{r.Mod, !prunedGraph(src)})
+	}
+	// The graph is loaded before anything is logged, so its progress has the
+	// terminal to itself.
+	progress := startProgress(opts, "deep", 0)
+	defer progress.finish()
+	for len(level) > 0 {
+		var todo []node
+		for _, n := range level {
+			if loaded[n] || excluded[n.mod] || n.mod.Path == src.Module.Mod.Path {
+				continue
+			}
+			loaded[n] = true
+			todo = append(todo, n)
+		}
+
+		reqs := make([]*modfile.File, len(todo))
+		errs := make([]error, len(todo))
+		progress.grow(len(todo))
+		runJobs(ctx, opts.jobs, len(todo), func(i int) {
+			reqs[i], errs[i] = moduleGoMod(ctx, p, src, srcFile, todo[i].mod)
+			progress.add(1)
+		})
+
+		level = nil
+		for i, n := range todo {
+			if errs[i] != nil {
+				return fmt.Errorf("deep: %s: %w", n.mod, errs[i])
+			}
+			for _, r := range reqs[i].Require {
+				if excluded[r.Mod] || r.Mod.Path == src.Module.Mod.Path {
+					continue
+				}
+				if semver.Compare(r.Mod.Version, selected[r.Mod.Path]) > 0 {
+					selected[r.Mod.Path] = r.Mod.Version
+				}
+				if _, ok := via[r.Mod]; !ok && !roots[r.Mod] {
+					via[r.Mod] = n.mod
+				}
+				if n.unpruned || !prunedGraph(reqs[i]) {
+					level = append(level, node{r.Mod, true})
+				}
+			}
+		}
+	}
+	progress.finish()
+
+	required := map[string]*modfile.Require{}
+	for _, r := range src.Require {
+		required[r.Mod.Path] = r
+	}
+	paths := make([]string, 0, len(selected))
+	for path := range selected {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	chain := func(m module.Version) string {
+		var parts []string
+		for p, ok := via[m]; ok && len(parts) <= len(via); p, ok = via[p] {
+			parts = append(parts, p.String())
+		}
+		return strings.Join(append(parts, "the source"), " <- ")
+	}
+	for _, path := range paths {
+		v := selected[path]
+		r, ok := required[path]
+		switch {
+		case !ok:
+			t.logf(path, "(deep) add: %s@%s (required by %s)", path, v, chain(module.Version{Path: path, Version: v}))
+			src.AddNewRequire(path, v, true)
+		case semver.Compare(v, r.Mod.Version) > 0:
+			t.logf(path, "(deep) raise: %s %s -> %s (required by %s)", path, r.Mod.Version, v, chain(module.Version{Path: path, Version: v}))
+			r.Mod.Version = v
+		}
+	}
+	src.Cleanup()
+	src.SetRequire(src.Require)
+	return nil
+}
+
+// moduleGoMod returns the go.mod file of m, or of its replacement in src, if
+// any. Directory replacements are relative to the directory of srcFile, or to
+// srcFile itself for a source tree.
+func moduleGoMod(ctx context.Context, p transplant.ModuleFetcher, src *modfile.File, srcFile string, m module.Version) (*modfile.File, error) {
+	var (
+		content []byte
+		err     error
+	)
+	switch r := replacedModule(src, m.Path, m.Version); {
+	case r.Path != "" && r.Version == "":
+		dir := r.Path
+		if !filepath.IsAbs(dir) {
+			dir = filepath.Join(sourceDir(srcFile), dir)
+		}
+		content, err = ioutil.ReadFile(filepath.Join(dir, "go.mod"))
+	case r.Path != "":
+		content, err = p.GoMod(ctx, r.Path, r.Version)
+	default:
+		content, err = p.GoMod(ctx, m.Path, m.Version)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return modfile.ParseLax("go.mod", content, nil)
+}
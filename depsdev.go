@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const defaultDepsDevURL = "https://api.deps.dev"
+
+// errNotInDepsDev is returned for modules deps.dev has no data on, such as
+// private ones.
+var errNotInDepsDev = errors.New("unknown to deps.dev")
+
+// moduleHealth are the maintenance signals deps.dev has on a module a merge
+// adds, for reviewers to judge the health of what a transplant drags in.
+// Scorecard is the OpenSSF Scorecard score of the module's source repository,
+// if it has one. Err is why the signals couldn't be looked up.
+type moduleHealth struct {
+	Path          string   `json:"path"`
+	Version       string   `json:"version"`
+	LatestVersion string   `json:"latest_version,omitempty"`
+	LatestRelease string   `json:"latest_release,omitempty"`
+	Dependents    int      `json:"dependents"`
+	Project       string   `json:"project,omitempty"`
+	Scorecard     *float64 `json:"scorecard,omitempty"`
+	Err           string   `json:"error,omitempty"`
+}
+
+// String summarizes the signals on one line.
+func (h moduleHealth) String() string {
+	if h.Err != "" {
+		return h.Err
+	}
+	parts := []string{fmt.Sprintf("%d dependent%s", h.Dependents, plural(h.Dependents))}
+	if h.LatestVersion != "" {
+		parts = append(parts, fmt.Sprintf("latest release %s on %s", h.LatestVersion, h.LatestRelease))
+	}
+	if h.Scorecard != nil {
+		parts = append(parts, fmt.Sprintf("scorecard %.1f", *h.Scorecard))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// addedModulesHealth looks up the maintenance signals of the modules changes
+// add. A module that can't be looked up gets its error recorded rather than
+// failing the merge.
+func addedModulesHealth(ctx context.Context, changes []change, opts *netOptions, baseURL string) ([]moduleHealth, error) {
+	defer opts.metrics.time(phaseNetwork)()
+	d := depsDevClient{client: newHTTPClient(opts), baseURL: strings.TrimSuffix(baseURL, "/")}
+	var health []moduleHealth
+	for _, c := range changes {
+		if c.Kind != "require" || c.Action != actionAdd {
+			continue
+		}
+		h, err := d.health(ctx, c.Path, c.To)
+		if errors.Is(err, context.Canceled) {
+			return nil, err
+		}
+		if err != nil {
+			h.Err = err.Error()
+		}
+		fmt.Fprintf(os.Stderr, "(depsdev) %s@%s: %s\n", c.Path, c.To, h)
+		health = append(health, h)
+	}
+	return health, nil
+}
+
+// depsDevClient queries the deps.dev API.
+type depsDevClient struct {
+	client  *http.Client
+	baseURL string
+}
+
+// health looks up the signals of path@version: the latest release of the
+// module, the number of packages depending on the version, and the scorecard
+// of the source repository.
+func (d depsDevClient) health(ctx context.Context, path, version string) (moduleHealth, error) {
+	h := moduleHealth{Path: path, Version: version}
+	pkg := "/systems/go/packages/" + url.PathEscape(path)
+	ver := pkg + "/versions/" + url.PathEscape(version)
+
+	var p struct {
+		Versions []struct {
+			VersionKey struct {
+				Version string `json:"version"`
+			} `json:"versionKey"`
+			PublishedAt time.Time `json:"publishedAt"`
+			IsDefault   bool      `json:"isDefault"`
+		} `json:"versions"`
+	}
+	if err := d.get(ctx, "/v3"+pkg, &p); err != nil {
+		return h, err
+	}
+	for _, v := range p.Versions {
+		if v.IsDefault {
+			h.LatestVersion = v.VersionKey.Version
+			h.LatestRelease = v.PublishedAt.UTC().Format("2006-01-02")
+		}
+	}
+
+	var dependents struct {
+		DependentCount int `json:"dependentCount"`
+	}
+	if err := d.get(ctx, "/v3alpha"+ver+":dependents", &dependents); err != nil && !errors.Is(err, errNotInDepsDev) {
+		return h, err
+	}
+	h.Dependents = dependents.DependentCount
+
+	var v struct {
+		RelatedProjects []struct {
+			ProjectKey struct {
+				ID string `json:"id"`
+			} `json:"projectKey"`
+			RelationType string `json:"relationType"`
+		} `json:"relatedProjects"`
+	}
+	if err := d.get(ctx, "/v3"+ver, &v); err != nil {
+		return h, err
+	}
+	for _, rp := range v.RelatedProjects {
+		if rp.RelationType == "SOURCE_REPO" {
+			h.Project = rp.ProjectKey.ID
+		}
+	}
+	if h.Project == "" {
+		return h, nil
+	}
+	var project struct {
+		Scorecard *struct {
+			OverallScore float64 `json:"overallScore"`
+		} `json:"scorecard"`
+	}
+	if err := d.get(ctx, "/v3/projects/"+url.PathEscape(h.Project), &project); err != nil && !errors.Is(err, errNotInDepsDev) {
+		return h, err
+	}
+	if project.Scorecard != nil {
+		h.Scorecard = &project.Scorecard.OverallScore
+	}
+	return h, nil
+}
+
+// get decodes the JSON response of the API endpoint at path into v.
+func (d depsDevClient) get(ctx context.Context, path string, v interface{}) error {
+	resp, err := httpGet(ctx, d.client, d.baseURL+path)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return errNotInDepsDev
+	default:
+		return fmt.Errorf("deps.dev: %s", resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("deps.dev: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"golang.org/x/mod/modfile"
+)
+
+// runDiff prints the semantic differences between two go.mod files, without
+// merging anything.
+func runDiff(ctx context.Context, args []string) error {
+	var (
+		format   string
+		exitCode bool
+	)
+	fs := flag.NewFlagSet("modtransplant diff", flag.ExitOnError)
+	fs.StringVar(&format, "format", "text", "output format (text or json)")
+	fs.BoolVar(&exitCode, "exit-code", false, "exit with status 1 if the files differ")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 2 {
+		return errors.New(usage)
+	}
+	var write func(io.Writer, []change) error
+	switch format {
+	case "text":
+		write = writeDiffText
+	case "json":
+		write = writeDiffJSON
+	default:
+		return fmt.Errorf("unsupported format %q", format)
+	}
+
+	a, err := parseModFile(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	b, err := parseModFile(fs.Arg(1))
+	if err != nil {
+		return err
+	}
+	changes := diffSemantic(a, b)
+	if err := write(os.Stdout, changes); err != nil {
+		return err
+	}
+	if exitCode && len(changes) > 0 {
+		return fmt.Errorf("%s and %s differ in %d statement(s)", fs.Arg(0), fs.Arg(1), len(changes))
+	}
+	return nil
+}
+
+// diffSemantic lists the changes that turn a into b: those of diffModFiles,
+// preceded by changes of the go and toolchain directives, which have no Path.
+func diffSemantic(a, b *modfile.File) []change {
+	var changes []change
+	var aGo, bGo, aToolchain, bToolchain string
+	if a.Go != nil {
+		aGo = a.Go.Version
+	}
+	if b.Go != nil {
+		bGo = b.Go.Version
+	}
+	if a.Toolchain != nil {
+		aToolchain = a.Toolchain.Name
+	}
+	if b.Toolchain != nil {
+		bToolchain = b.Toolchain.Name
+	}
+	if c, ok := directiveChange("go", aGo, bGo); ok {
+		changes = append(changes, c)
+	}
+	if c, ok := directiveChange("toolchain", aToolchain, bToolchain); ok {
+		changes = append(changes, c)
+	}
+	return append(changes, diffModFiles(a, b)...)
+}
+
+// directiveChange returns the change of a directive from one value to the
+// other, where "" means it is missing, and whether there is one.
+func directiveChange(kind, from, to string) (change, bool) {
+	switch {
+	case from == to:
+		return change{}, false
+	case from == "":
+		return change{Kind: kind, Action: actionAdd, To: to}, true
+	case to == "":
+		return change{Kind: kind, Action: actionRemove, From: from}, true
+	}
+	return change{Kind: kind, Action: actionUpdate, From: from, To: to}, true
+}
+
+// writeDiffText prints the changes one per line.
+func writeDiffText(w io.Writer, changes []change) error {
+	for _, c := range changes {
+		line := changeText(c)
+		if c.Kind == "require" && c.Indirect {
+			line += " // indirect"
+		}
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeDiffJSON prints the changes as a JSON array.
+func writeDiffJSON(w io.Writer, changes []change) error {
+	if changes == nil {
+		changes = []change{}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(changes)
+}
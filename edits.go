@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"strings"
+)
+
+// position is a location in a file. Line and Column are 1-based, Offset is
+// the 0-based byte offset.
+type position struct {
+	Line   int `json:"line"`
+	Column int `json:"column"`
+	Offset int `json:"offset"`
+}
+
+// fileEdit replaces the text between Start and End of a file with New.
+type fileEdit struct {
+	Start position `json:"start"`
+	End   position `json:"end"`
+	Old   string   `json:"old"`
+	New   string   `json:"new"`
+}
+
+// computeEdits returns the edits turning before into after, each covering
+// whole lines, in file order. Positions refer to before.
+func computeEdits(before, after string) []fileEdit {
+	lines := func(s string) []string {
+		l := strings.SplitAfter(s, "\n")
+		if l[len(l)-1] == "" {
+			l = l[:len(l)-1]
+		}
+		return l
+	}
+	edits := []fileEdit{}
+	var (
+		cur    *fileEdit
+		line   = 1
+		offset = 0
+	)
+	flush := func() {
+		if cur != nil {
+			cur.End = position{Line: line, Column: 1, Offset: offset}
+			edits = append(edits, *cur)
+			cur = nil
+		}
+	}
+	for _, d := range diffSlices(lines(before), lines(after)) {
+		if d.Op == " " {
+			flush()
+			line++
+			offset += len(d.Text)
+			continue
+		}
+		if cur == nil {
+			cur = &fileEdit{Start: position{Line: line, Column: 1, Offset: offset}}
+		}
+		if d.Op == "+" {
+			cur.New += d.Text
+			continue
+		}
+		cur.Old += d.Text
+		line++
+		offset += len(d.Text)
+	}
+	flush()
+	return edits
+}
+
+// writeEdits writes the edits turning before into after as JSON.
+func writeEdits(w io.Writer, before, after []byte) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+	return enc.Encode(computeEdits(string(before), string(after)))
+}
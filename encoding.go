@@ -0,0 +1,42 @@
+package main
+
+import "bytes"
+
+var utf8BOM = []byte("\ufeff")
+
+// fileEncoding is the byte-order mark and line endings of a go.mod file, which
+// the go.mod parser doesn't accept or formatting drops. Keeping them avoids
+// rewriting every line of files edited on Windows.
+type fileEncoding struct {
+	bom  bool
+	crlf bool
+}
+
+// detectEncoding returns the encoding of content, and content without a
+// byte-order mark and with LF line endings.
+func detectEncoding(content []byte) (fileEncoding, []byte) {
+	var enc fileEncoding
+	if bytes.HasPrefix(content, utf8BOM) {
+		enc.bom = true
+		content = content[len(utf8BOM):]
+	}
+	// The first line ending decides.
+	if i := bytes.IndexByte(content, '\n'); i > 0 && content[i-1] == '\r' {
+		enc.crlf = true
+	}
+	if bytes.Contains(content, []byte("\r\n")) {
+		content = bytes.ReplaceAll(content, []byte("\r\n"), []byte("\n"))
+	}
+	return enc, content
+}
+
+// apply converts content with LF line endings to the encoding.
+func (enc fileEncoding) apply(content []byte) []byte {
+	if enc.crlf {
+		content = bytes.ReplaceAll(content, []byte("\n"), []byte("\r\n"))
+	}
+	if enc.bom {
+		content = append(append([]byte(nil), utf8BOM...), content...)
+	}
+	return content
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/mod/modfile"
+	"golang.org/x/mod/module"
+)
+
+// transplantedVersions lists the module versions changes make the destination
+// require or replace modules with. Directory replacements have no version.
+func transplantedVersions(changes []change) []module.Version {
+	var mods []module.Version
+	for _, c := range changes {
+		switch {
+		case c.Kind == "require" && (c.Action == actionAdd || c.Action == actionUpdate) && c.To != c.From:
+			mods = append(mods, module.Version{Path: c.Path, Version: c.To})
+		case c.Kind == "replace" && c.Action != actionRemove:
+			path, version, ok := strings.Cut(c.To, "@")
+			if ok && !modfile.IsDirectoryPath(path) {
+				mods = append(mods, module.Version{Path: path, Version: version})
+			}
+		}
+	}
+	return mods
+}
+
+// checkVersionsExist fails if the -resolver doesn't know any of the module
+// versions the changes transplant, so that a merge fails early instead of
+// leaving the destination unbuildable.
+func checkVersionsExist(ctx context.Context, changes []change, opts *netOptions) error {
+	mods := transplantedVersions(changes)
+	if len(mods) == 0 {
+		return nil
+	}
+	p, err := newModuleFetcher(opts)
+	if err != nil {
+		return err
+	}
+	errs := make([]error, len(mods))
+	progress := startProgress(opts, "check-versions", len(mods))
+	runJobs(ctx, opts.jobs, len(mods), func(i int) {
+		_, errs[i] = p.Info(ctx, mods[i].Path, mods[i].Version)
+		progress.add(1)
+	})
+	progress.finish()
+
+	var missing []string
+	for i, err := range errs {
+		switch {
+		case errors.Is(err, errNotFound):
+			missing = append(missing, fmt.Sprintf("%s: not found", mods[i]))
+		case err != nil:
+			return err
+		}
+	}
+	return gateError("merge would transplant %d module version(s) the proxy doesn't have:", missing)
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"golang.org/x/mod/modfile"
+
+	"github.com/brettbuddin/modtransplant/transplant"
+)
+
+// inventoryEntry is a single row of a dependency inventory.
+type inventoryEntry struct {
+	Path       string `json:"path"`
+	Version    string `json:"version"`
+	Indirect   bool   `json:"indirect"`
+	ReplacedBy string `json:"replaced_by,omitempty"`
+}
+
+// runExport writes an inventory of the destination's dependency set. If a
+// source is given, the inventory describes the merged result instead.
+func runExport(ctx context.Context, args []string) error {
+	var (
+		destFile       string
+		srcFile        string
+		format         string
+		forceOverwrite bool
+		configFile     string
+		netOpts        netOptions
+	)
+	fs := flag.NewFlagSet("modtransplant export", flag.ExitOnError)
+	fs.StringVar(&destFile, "dest", "", "destination go.mod file")
+	fs.StringVar(&srcFile, "src", "", "optional source module to merge before exporting")
+	fs.StringVar(&format, "format", "csv", "output format (csv or json)")
+	fs.BoolVar(&forceOverwrite, "force-overwrite", false, "force overwrite of versions of matching module paths")
+	fs.StringVar(&configFile, "config", "", "JSON config file (e.g. with a conflict_policy)")
+	netOpts.register(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if destFile == "" {
+		return errors.New(usage)
+	}
+
+	dest, err := parseModFile(destFile)
+	if err != nil {
+		return err
+	}
+	if srcFile != "" {
+		cfg, err := loadConfig(configFile)
+		if err != nil {
+			return err
+		}
+		resolver, err := cfg.resolver()
+		if err != nil {
+			return err
+		}
+		src, err := loadSource(ctx, srcFile, &netOpts)
+		if err != nil {
+			return err
+		}
+		if err := setSourceModule(src, srcFile, ""); err != nil {
+			return err
+		}
+		if _, err := transplant.Merge(ctx, dest, src, transplant.WithForceOverwrite(forceOverwrite), transplant.WithResolver(resolver)); err != nil {
+			return err
+		}
+	}
+
+	entries := inventory(dest)
+	switch format {
+	case "csv":
+		return writeInventoryCSV(os.Stdout, entries)
+	case "json":
+		return writeInventoryJSON(os.Stdout, entries)
+	default:
+		return fmt.Errorf("unknown export format: %s", format)
+	}
+}
+
+// inventory lists every required module of f along with its replacement, if
+// any.
+func inventory(f *modfile.File) []inventoryEntry {
+	entries := make([]inventoryEntry, 0, len(f.Require))
+	for _, r := range f.Require {
+		entries = append(entries, inventoryEntry{
+			Path:       r.Mod.Path,
+			Version:    r.Mod.Version,
+			Indirect:   r.Indirect,
+			ReplacedBy: replacedModule(f, r.Mod.Path, r.Mod.Version).String(),
+		})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Path < entries[j].Path
+	})
+	return entries
+}
+
+func writeInventoryCSV(w io.Writer, entries []inventoryEntry) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write([]string{"path", "version", "type", "replaced_by"}); err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if err := cw.Write([]string{e.Path, e.Version, indirectStr(e.Indirect), e.ReplacedBy}); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func writeInventoryJSON(w io.Writer, entries []inventoryEntry) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(entries)
+}
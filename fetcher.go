@@ -0,0 +1,165 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/brettbuddin/modtransplant/transplant"
+)
+
+// Values of -resolver.
+const (
+	// resolverProxy answers module queries through the GOPROXY chain.
+	resolverProxy = "proxy"
+	// resolverGo answers them with the local go command.
+	resolverGo = "go"
+	// resolverNone answers none, failing the features that need them.
+	resolverNone = "none"
+)
+
+var errResolverNone = errors.New("module lookups are disabled by -resolver=none")
+
+// newModuleFetcher returns the fetcher of the -resolver in opts, which all
+// network features query module versions through.
+func newModuleFetcher(opts *netOptions) (transplant.ModuleFetcher, error) {
+	switch opts.resolver {
+	case "", resolverProxy:
+		p, err := newProxyClient(opts)
+		if err != nil {
+			return nil, err
+		}
+		return p, nil
+	case resolverGo:
+		return goCommandFetcher{dir: opts.resolverDir, offline: opts.offline}, nil
+	case resolverNone:
+		return noFetcher{}, nil
+	}
+	return nil, fmt.Errorf("unsupported -resolver %q", opts.resolver)
+}
+
+// goCommandFetcher answers module queries with 'go list -m' run in dir, the
+// destination module, so that its go command settings (GOPROXY, GOFLAGS,
+// GOPRIVATE, go.work...) apply exactly as they do for the toolchain. The go
+// command verifies what it downloads against the checksum database itself.
+type goCommandFetcher struct {
+	dir     string
+	offline bool
+}
+
+// goListModule is the part of the output of 'go list -m -json' used here.
+type goListModule struct {
+	Path     string
+	Version  string
+	Time     *time.Time
+	Versions []string
+	GoMod    string
+	Error    *struct{ Err string }
+}
+
+// list runs 'go list -m -json -e' with the given flags and module query.
+func (g goCommandFetcher) list(ctx context.Context, query string, flags ...string) (*goListModule, error) {
+	args := append(append([]string{"list", "-m", "-json", "-e"}, flags...), query)
+	cmd := exec.CommandContext(ctx, "go", args...)
+	cmd.Dir = g.dir
+	cmd.Env = os.Environ()
+	if g.offline {
+		cmd.Env = append(cmd.Env, "GOPROXY=off")
+	}
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("go %s: %s", strings.Join(args, " "), strings.TrimSpace(stderr.String()))
+	}
+	var m goListModule
+	if err := json.Unmarshal(stdout.Bytes(), &m); err != nil {
+		return nil, fmt.Errorf("go %s: %w", strings.Join(args, " "), err)
+	}
+	if m.Error != nil {
+		if isNotFound(m.Error.Err) {
+			return nil, fmt.Errorf("%s: %w", m.Error.Err, errNotFound)
+		}
+		return nil, errors.New(m.Error.Err)
+	}
+	return &m, nil
+}
+
+// isNotFound reports whether a go command error says that a module version
+// doesn't exist, rather than that it couldn't be looked up.
+func isNotFound(msg string) bool {
+	for _, s := range []string{"not found", "unknown revision", "no matching versions", "invalid version"} {
+		if strings.Contains(msg, s) {
+			return true
+		}
+	}
+	return false
+}
+
+func (g goCommandFetcher) Info(ctx context.Context, path, version string) (*transplant.VersionInfo, error) {
+	m, err := g.list(ctx, path+"@"+version)
+	if err != nil {
+		return nil, err
+	}
+	info := &transplant.VersionInfo{Version: m.Version}
+	if m.Time != nil {
+		info.Time = *m.Time
+	}
+	return info, nil
+}
+
+func (g goCommandFetcher) Latest(ctx context.Context, path string) (string, error) {
+	m, err := g.list(ctx, path+"@latest")
+	if err != nil {
+		return "", err
+	}
+	return m.Version, nil
+}
+
+func (g goCommandFetcher) GoMod(ctx context.Context, path, version string) ([]byte, error) {
+	m, err := g.list(ctx, path+"@"+version)
+	if err != nil {
+		return nil, err
+	}
+	if m.GoMod == "" {
+		return nil, fmt.Errorf("%s@%s: go list reported no go.mod file", path, version)
+	}
+	return ioutil.ReadFile(m.GoMod)
+}
+
+func (g goCommandFetcher) List(ctx context.Context, path string) ([]string, error) {
+	m, err := g.list(ctx, path+"@latest", "-versions")
+	if err != nil {
+		return nil, err
+	}
+	return m.Versions, nil
+}
+
+// noFetcher answers no module query.
+type noFetcher struct{}
+
+func (noFetcher) Info(context.Context, string, string) (*transplant.VersionInfo, error) {
+	return nil, errResolverNone
+}
+
+func (noFetcher) Latest(context.Context, string) (string, error) {
+	return "", errResolverNone
+}
+
+func (noFetcher) GoMod(context.Context, string, string) ([]byte, error) {
+	return nil, errResolverNone
+}
+
+func (noFetcher) List(context.Context, string) ([]string, error) {
+	return nil, errResolverNone
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"go/version"
+	"strings"
+
+	"golang.org/x/mod/modfile"
+	"golang.org/x/mod/module"
+	"golang.org/x/mod/semver"
+)
+
+// checkDowngrades fails if any of the changes lowers a required version.
+func checkDowngrades(changes []change) error {
+	var downgrades []string
+	for _, c := range changes {
+		if c.Kind == "require" && c.Action == actionUpdate && semver.Compare(c.To, c.From) < 0 {
+			downgrades = append(downgrades, fmt.Sprintf("%s %s -> %s", c.Path, c.From, c.To))
+		}
+	}
+	return gateError("merge would downgrade %d module(s):", downgrades)
+}
+
+// checkNewDeps fails if any of the changes adds a direct requirement of a
+// module matching patterns, a comma-separated list of glob path prefixes as in
+// GOPRIVATE. An empty list matches every module.
+func checkNewDeps(changes []change, patterns string) error {
+	var added []string
+	for _, c := range changes {
+		if c.Kind != "require" || c.Action != actionAdd || c.Indirect {
+			continue
+		}
+		if patterns == "" || module.MatchPrefixPatterns(patterns, c.Path) {
+			added = append(added, fmt.Sprintf("%s %s", c.Path, c.To))
+		}
+	}
+	return gateError("merge would add %d new direct module(s), which need approval:", added)
+}
+
+// checkFrozen fails if any of the changes adds, removes or changes the version
+// of a requirement, or changes a replacement, of a module matching patterns,
+// a comma-separated list of glob path prefixes as in GOPRIVATE. Whether a
+// requirement is indirect may change. An empty list matches no module.
+func checkFrozen(changes []change, patterns string) error {
+	if patterns == "" {
+		return nil
+	}
+	var violations []string
+	for _, c := range changes {
+		if !module.MatchPrefixPatterns(patterns, c.Path) {
+			continue
+		}
+		switch {
+		case c.Kind == "require" && c.Action == actionAdd:
+			violations = append(violations, fmt.Sprintf("%s: add %s", c.Path, c.To))
+		case c.Kind == "require" && c.Action == actionRemove:
+			violations = append(violations, fmt.Sprintf("%s: remove %s", c.Path, c.From))
+		case c.Kind == "require" && c.From != c.To:
+			violations = append(violations, fmt.Sprintf("%s: %s -> %s", c.Path, c.From, c.To))
+		case c.Kind == "replace":
+			old := c.Path
+			if c.Version != "" {
+				old += "@" + c.Version
+			}
+			switch c.Action {
+			case actionAdd:
+				violations = append(violations, fmt.Sprintf("%s: add replacement => %s", old, c.To))
+			case actionRemove:
+				violations = append(violations, fmt.Sprintf("%s: remove replacement => %s", old, c.From))
+			default:
+				violations = append(violations, fmt.Sprintf("%s: replacement %s -> %s", old, c.From, c.To))
+			}
+		}
+	}
+	return gateError("merge would make %d change(s) to frozen modules:", violations)
+}
+
+// frozenPatterns joins the patterns of frozen modules given by -freeze and
+// in the config file.
+func frozenPatterns(flag string, cfg []string) string {
+	var patterns []string
+	if flag != "" {
+		patterns = append(patterns, flag)
+	}
+	return strings.Join(append(patterns, cfg...), ",")
+}
+
+// checkGoDirective fails if the go directive of f is lower than min or higher
+// than max. Either bound may be empty. A go.mod without a go directive is
+// taken to be at go 1.16, as the go command does.
+func checkGoDirective(f *modfile.File, min, max string) error {
+	v := "1.16"
+	if f.Go != nil {
+		v = f.Go.Version
+	}
+	switch {
+	case min != "" && version.Compare("go"+v, "go"+min) < 0:
+		return fmt.Errorf("go directive %s is lower than the minimum %s", v, min)
+	case max != "" && version.Compare("go"+v, "go"+max) > 0:
+		return fmt.Errorf("go directive %s is higher than the maximum %s", v, max)
+	}
+	return nil
+}
+
+// gateError returns an error listing the violations of a gate, or nil if there
+// are none. format receives the number of violations.
+func gateError(format string, violations []string) error {
+	if len(violations) == 0 {
+		return nil
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, format, len(violations))
+	for _, v := range violations {
+		fmt.Fprintf(&b, "\n\t%s", v)
+	}
+	return errors.New(b.String())
+}
@@ -1,8 +1,19 @@
 module github.com/brettbuddin/modtransplant
 
-go 1.15
+go 1.26.0
 
 require (
 	github.com/Masterminds/semver v1.5.0
-	golang.org/x/mod v0.3.0
+	github.com/google/cel-go v0.26.1
+	golang.org/x/mod v0.41.0
+)
+
+require (
+	cel.dev/expr v0.24.0 // indirect
+	github.com/antlr4-go/antlr/v4 v4.13.0 // indirect
+	github.com/stoewer/go-strcase v1.2.0 // indirect
+	golang.org/x/exp v0.0.0-20230515195305-f3d0a9c9a5cc // indirect
+	google.golang.org/genproto/googleapis/api v0.0.0-20240826202546-f6391c0de4c7 // indirect
+	google.golang.org/genproto/googleapis/rpc v0.0.0-20240826202546-f6391c0de4c7 // indirect
+	google.golang.org/protobuf v1.34.2 // indirect
 )
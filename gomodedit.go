@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/mod/modfile"
+)
+
+// writeGoModEdit writes the go mod edit commands making the changes to
+// destFile, which read as before, as a shell script, one command per change.
+// For the destination on stdin, the commands edit the go.mod file of the
+// current module.
+func writeGoModEdit(w io.Writer, destFile string, before *modfile.File, changes []change) error {
+	wasIndirect := map[string]bool{}
+	for _, r := range before.Require {
+		wasIndirect[r.Mod.Path] = r.Indirect
+	}
+	var file string
+	if destFile != stdinDest {
+		file = " " + shellQuote(destFile)
+	}
+	var indirect []string
+	for _, c := range changes {
+		old := c.Path
+		if c.Version != "" {
+			old += "@" + c.Version
+		}
+		var flag string
+		switch c.Kind + " " + c.Action {
+		case "module update":
+			flag = "-module=" + c.To
+		case "require add", "require update":
+			flag = "-require=" + c.Path + "@" + c.To
+			// go mod edit leaves the // indirect comment of a requirement
+			// alone, so it can't add or remove one.
+			if c.Indirect != wasIndirect[c.Path] {
+				indirect = append(indirect, c.Path)
+			}
+		case "require remove":
+			flag = "-droprequire=" + c.Path
+		case "replace add", "replace update":
+			flag = "-replace=" + old + "=" + c.To
+		case "replace remove":
+			flag = "-dropreplace=" + old
+		case "exclude add":
+			flag = "-exclude=" + old
+		case "exclude remove":
+			flag = "-dropexclude=" + old
+		case "ignore add":
+			flag = "-ignore=" + c.Path
+		case "ignore remove":
+			flag = "-dropignore=" + c.Path
+		default:
+			return fmt.Errorf("unsupported change: %s %s", c.Action, c.Kind)
+		}
+		fmt.Fprintf(w, "go mod edit %s%s\n", shellQuote(flag), file)
+	}
+	if len(indirect) > 0 {
+		fmt.Fprintf(w, "# go mod edit doesn't update // indirect comments; go mod tidy does: %s\n", strings.Join(indirect, ", "))
+	}
+	return nil
+}
+
+// writeGoGet writes the go get commands making the requirement changes to
+// destFile as a shell script, one command per module, leaving the
+// consequences for other requirements to the go command. Replacements and
+// exclusions can't be changed with go get; they are listed at the end.
+func writeGoGet(w io.Writer, destFile string, changes []change) error {
+	if dir := filepath.Dir(destFile); destFile != stdinDest && dir != "." {
+		fmt.Fprintf(w, "cd %s\n", shellQuote(dir))
+	}
+	var skipped []string
+	for _, c := range changes {
+		switch {
+		case c.Kind != "require":
+			s := c.Path
+			if c.Version != "" {
+				s += "@" + c.Version
+			}
+			skipped = append(skipped, c.Action+" "+c.Kind+" "+s)
+		case c.Action == actionRemove:
+			fmt.Fprintf(w, "go get %s\n", shellQuote(c.Path+"@none"))
+		case c.From != c.To:
+			fmt.Fprintf(w, "go get %s\n", shellQuote(c.Path+"@"+c.To))
+		}
+	}
+	for _, s := range skipped {
+		fmt.Fprintf(w, "# go get can't %s\n", s)
+	}
+	return nil
+}
+
+// shellQuote quotes s for a POSIX shell, unless it doesn't need quoting.
+func shellQuote(s string) string {
+	safe := s != "" && strings.IndexFunc(s, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("@%+=:,./_-~", r))
+	}) < 0
+	if safe {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
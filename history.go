@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// historyFile is the file, at the root of the destination's repository, a
+// record of every run is appended to.
+const historyFile = ".modtransplant.log"
+
+// digests are the SHA-256 digests, as "sha256:<hex>", of the files a merge
+// was made from and produced.
+type digests struct {
+	Dest string `json:"dest,omitempty"`
+	// Src is empty if the source isn't a local file.
+	Src string `json:"src,omitempty"`
+	// Output is the digest of the merged go.mod, whether or not it was
+	// written. It is empty if the merge failed.
+	Output string `json:"output,omitempty"`
+}
+
+// historyRecord is a line of the history file. Each record carries the digest
+// of the line before it, chaining the records so that editing or removing one
+// is evident.
+type historyRecord struct {
+	Time    time.Time `json:"time"`
+	Dest    string    `json:"dest"`
+	Module  string    `json:"module,omitempty"`
+	Src     string    `json:"src"`
+	Digests digests   `json:"digests"`
+	Args    []string  `json:"args"`
+	Written bool      `json:"written"`
+	Changes []change  `json:"changes"`
+	Error   string    `json:"error,omitempty"`
+	// Prev is the digest of the previous line of the history file.
+	Prev string `json:"prev,omitempty"`
+}
+
+// appendHistory appends a record of the result to the history file of the
+// repository containing its destination.
+func appendHistory(r fileResult, opts *mergeOptions) error {
+	root, err := repoRoot(filepath.Dir(r.Dest))
+	if err != nil {
+		return err
+	}
+	rec := historyRecord{
+		Time:    time.Now().UTC(),
+		Dest:    r.Dest,
+		Module:  r.Module,
+		Src:     opts.srcFile,
+		Digests: r.Digests,
+		Args:    opts.args,
+		Written: r.Written,
+		Changes: r.Changes,
+	}
+	if rec.Changes == nil {
+		rec.Changes = []change{}
+	}
+	if r.Err != nil {
+		rec.Error = r.Err.Error()
+	}
+	f, err := os.OpenFile(filepath.Join(root, historyFile), os.O_RDWR|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	content, err := ioutil.ReadAll(f)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	if lines := bytes.Split(bytes.TrimSpace(content), []byte("\n")); len(lines[len(lines)-1]) > 0 {
+		rec.Prev = digest(lines[len(lines)-1])
+	}
+	line, err := json.Marshal(rec)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// digest returns the SHA-256 digest of b as "sha256:<hex>".
+func digest(b []byte) string {
+	sum := sha256.Sum256(b)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// fileDigest returns the SHA-256 digest of a file as "sha256:<hex>", or the
+// empty string if path isn't a regular file.
+func fileDigest(path string) (string, error) {
+	if info, err := os.Stat(path); err != nil || !info.Mode().IsRegular() {
+		return "", nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return "sha256:" + hex.EncodeToString(h.Sum(nil)), nil
+}
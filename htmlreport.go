@@ -0,0 +1,230 @@
+package main
+
+import (
+	"html/template"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// fileResult is the outcome of merging into one destination, as shown in a
+// report.
+type fileResult struct {
+	Dest    string
+	Module  string
+	Changes []change
+	// Before and After are the destination's go.mod before and after the
+	// merge. After is empty if the merge failed.
+	Before, After string
+	Digests       digests
+	Written       bool
+	// Deprecated and SrcDeprecated are the deprecation messages of the
+	// destination and source modules, if they are deprecated.
+	Deprecated, SrcDeprecated string
+	// Health are the deps.dev signals of the added modules, with -depsdev.
+	Health []moduleHealth
+	// Upstream are the states of the GitHub repositories of the added
+	// modules, with -check-upstream.
+	Upstream []upstreamStatus
+	// Vendor is how much the vendor directory changed, with -vendor.
+	Vendor *vendorChurn
+	Err    error
+}
+
+// diffLine is a line of a line-by-line diff. Op is ' ', '+' or '-'.
+type diffLine struct {
+	Op   string
+	Text string
+}
+
+// diffLines returns a minimal line diff turning a into b.
+func diffLines(a, b string) []diffLine {
+	return diffSlices(strings.Split(strings.TrimSuffix(a, "\n"), "\n"), strings.Split(strings.TrimSuffix(b, "\n"), "\n"))
+}
+
+// diffSlices returns a minimal diff turning the lines as into bs.
+func diffSlices(as, bs []string) []diffLine {
+	// A merge changes few lines of a go.mod file, so the common prefix and
+	// suffix are split off to keep the table below small for huge files.
+	var prefix, suffix int
+	for prefix < len(as) && prefix < len(bs) && as[prefix] == bs[prefix] {
+		prefix++
+	}
+	for suffix < len(as)-prefix && suffix < len(bs)-prefix && as[len(as)-1-suffix] == bs[len(bs)-1-suffix] {
+		suffix++
+	}
+	lines := make([]diffLine, 0, len(as)+len(bs)-prefix-suffix)
+	for _, l := range as[:prefix] {
+		lines = append(lines, diffLine{" ", l})
+	}
+	lines = append(lines, diffMiddle(as[prefix:len(as)-suffix], bs[prefix:len(bs)-suffix])...)
+	for _, l := range as[len(as)-suffix:] {
+		lines = append(lines, diffLine{" ", l})
+	}
+	return lines
+}
+
+// diffMiddle returns a minimal diff turning as into bs with a table of their
+// longest common subsequences.
+func diffMiddle(as, bs []string) []diffLine {
+	// Lines are compared by number in the table, which is much cheaper than
+	// comparing the strings.
+	ids := map[string]int{}
+	number := func(lines []string) []int {
+		ns := make([]int, len(lines))
+		for i, l := range lines {
+			n, ok := ids[l]
+			if !ok {
+				n = len(ids)
+				ids[l] = n
+			}
+			ns[i] = n
+		}
+		return ns
+	}
+	an, bn := number(as), number(bs)
+	// lcs[i][j] is the length of the longest common subsequence of as[i:]
+	// and bs[j:].
+	lcs := make([][]int, len(as)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(bs)+1)
+	}
+	for i := len(as) - 1; i >= 0; i-- {
+		for j := len(bs) - 1; j >= 0; j-- {
+			if an[i] == bn[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	var lines []diffLine
+	i, j := 0, 0
+	for i < len(as) || j < len(bs) {
+		switch {
+		case i < len(as) && j < len(bs) && as[i] == bs[j]:
+			lines = append(lines, diffLine{" ", as[i]})
+			i++
+			j++
+		case j < len(bs) && (i == len(as) || lcs[i][j+1] > lcs[i+1][j]):
+			lines = append(lines, diffLine{"+", bs[j]})
+			j++
+		default:
+			lines = append(lines, diffLine{"-", as[i]})
+			i++
+		}
+	}
+	return lines
+}
+
+// writeHTMLReportFile writes a standalone HTML report of the results to path.
+func writeHTMLReportFile(path, srcFile string, results []fileResult) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := writeHTMLReport(f, srcFile, results); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// writeHTMLReport renders the results as a self-contained HTML page: a
+// collapsible section per destination with a sortable table of its changes
+// and a diff of its go.mod.
+func writeHTMLReport(w io.Writer, srcFile string, results []fileResult) error {
+	return htmlReport.Execute(w, struct {
+		Src       string
+		Generated string
+		Results   []fileResult
+	}{srcFile, time.Now().UTC().Format(time.RFC3339), results})
+}
+
+var htmlReport = template.Must(template.New("report").Funcs(template.FuncMap{
+	"diff": func(r fileResult) []diffLine {
+		if r.After == "" {
+			return nil
+		}
+		return diffLines(r.Before, r.After)
+	},
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>modtransplant report</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+details { border: 1px solid #ccc; border-radius: 4px; margin: 1em 0; padding: 0.5em 1em; }
+summary { cursor: pointer; font-weight: bold; }
+.failed summary { color: #b00; }
+table { border-collapse: collapse; margin: 1em 0; }
+th, td { border: 1px solid #ddd; padding: 0.25em 0.75em; text-align: left; }
+th { background: #f4f4f4; cursor: pointer; user-select: none; }
+pre { background: #f8f8f8; padding: 0.5em; overflow-x: auto; }
+.add { background: #e6ffed; }
+.remove { background: #ffeef0; }
+.error { color: #b00; white-space: pre-wrap; }
+.deprecated { color: #a60; }
+.why { font-family: monospace; font-size: 0.85em; }
+</style>
+</head>
+<body>
+<h1>modtransplant report</h1>
+<p>Source: <code>{{.Src}}</code>. Generated {{.Generated}}.</p>
+{{range .Results}}
+<details{{if .Err}} class="failed"{{end}} open>
+<summary>{{.Dest}}{{if .Module}} ({{.Module}}){{end}}: {{if .Err}}failed{{else}}{{len .Changes}} change(s){{end}}</summary>
+<p>Digests: destination <code>{{.Digests.Dest}}</code>{{with .Digests.Src}}, source <code>{{.}}</code>{{end}}{{with .Digests.Output}}, output <code>{{.}}</code>{{end}}</p>
+{{with .Deprecated}}<p class="deprecated">The destination module is deprecated: {{.}}</p>{{end}}
+{{with .SrcDeprecated}}<p class="deprecated">The source module is deprecated: {{.}}</p>{{end}}
+{{if .Err}}<p class="error">{{.Err}}</p>{{end}}
+{{if .Changes}}
+<table class="sortable">
+<thead><tr><th>Kind</th><th>Action</th><th>Path</th><th>Version</th><th>From</th><th>To</th><th>Indirect</th><th>Why</th></tr></thead>
+<tbody>
+{{range .Changes}}<tr><td>{{.Kind}}</td><td>{{.Action}}</td><td>{{.Path}}</td><td>{{.Version}}</td><td>{{.From}}</td><td>{{.To}}</td><td>{{if .Indirect}}yes{{end}}</td><td class="why">{{range .Why}}{{.}}<br>{{end}}</td></tr>
+{{end}}</tbody>
+</table>
+{{end}}
+{{if .Health}}
+<table class="sortable">
+<thead><tr><th>Added module</th><th>Latest release</th><th>Released</th><th>Dependents</th><th>Scorecard</th><th>Note</th></tr></thead>
+<tbody>
+{{range .Health}}<tr><td>{{.Path}}@{{.Version}}</td><td>{{.LatestVersion}}</td><td>{{.LatestRelease}}</td><td>{{.Dependents}}</td><td>{{with .Scorecard}}{{printf "%.1f" .}}{{end}}</td><td class="error">{{.Err}}</td></tr>
+{{end}}</tbody>
+</table>
+{{end}}
+{{if .Upstream}}
+<table class="sortable">
+<thead><tr><th>Added module</th><th>Repository</th><th>Archived</th><th>Inactive</th><th>Last push</th><th>Note</th></tr></thead>
+<tbody>
+{{range .Upstream}}<tr><td>{{.Path}}</td><td>{{.Repository}}</td><td>{{if .Archived}}yes{{end}}</td><td>{{if .Inactive}}yes{{end}}</td><td>{{.LastPush}}</td><td class="error">{{.Err}}</td></tr>
+{{end}}</tbody>
+</table>
+{{end}}
+{{with .Vendor}}<p>Vendor directory: files {{.Files}}; modules {{.Modules}}.</p>{{end}}
+{{with diff .}}<pre>{{range .}}<span{{if eq .Op "+"}} class="add"{{else if eq .Op "-"}} class="remove"{{end}}>{{.Op}} {{.Text}}</span>
+{{end}}</pre>{{end}}
+</details>
+{{end}}
+<script>
+document.querySelectorAll("table.sortable th").forEach(function(th) {
+	th.addEventListener("click", function() {
+		var tbody = th.closest("table").tBodies[0];
+		var asc = th.dataset.order !== "asc";
+		th.closest("tr").querySelectorAll("th").forEach(function(h) { delete h.dataset.order; });
+		th.dataset.order = asc ? "asc" : "desc";
+		Array.from(tbody.rows).sort(function(a, b) {
+			var x = a.cells[th.cellIndex].textContent, y = b.cells[th.cellIndex].textContent;
+			return asc ? x.localeCompare(y) : y.localeCompare(x);
+		}).forEach(function(row) { tbody.appendChild(row); });
+	});
+});
+</script>
+</body>
+</html>
+`))
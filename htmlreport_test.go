@@ -0,0 +1,27 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+func BenchmarkDiffSlices(b *testing.B) {
+	for _, n := range []int{1000, 5000} {
+		b.Run(fmt.Sprintf("lines=%d", n), func(b *testing.B) {
+			// A merge changes a few scattered lines of a huge file.
+			before := make([]string, n)
+			after := make([]string, n)
+			for i := range before {
+				before[i] = fmt.Sprintf("\texample.com/dep%d v1.0.0", i)
+				after[i] = before[i]
+				if i%100 == 0 {
+					after[i] = fmt.Sprintf("\texample.com/dep%d v1.1.0", i)
+				}
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				diffSlices(before, after)
+			}
+		})
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// rewrittenImports returns the Go files of the module rooted at dir whose
+// imports of packages of the module from now import them from to instead, with
+// their new content. Only the import paths are edited; the files are then
+// formatted like gofmt does, which keeps import blocks sorted. Vendor and
+// testdata directories, those starting with . or _ and nested modules are
+// skipped.
+func rewrittenImports(dir, from, to string) ([]stagedFile, error) {
+	var files []stagedFile
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if path == dir {
+				return nil
+			}
+			name := d.Name()
+			if name == "vendor" || name == "testdata" || strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_") {
+				return filepath.SkipDir
+			}
+			if _, err := os.Stat(filepath.Join(path, "go.mod")); err == nil {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !strings.HasSuffix(path, ".go") {
+			return nil
+		}
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		out, n, err := rewriteFileImports(path, content, from, to)
+		if err != nil {
+			return err
+		}
+		if n > 0 {
+			rel, _ := filepath.Rel(dir, path)
+			fmt.Fprintf(actionLog(), "(imports) rewrite: %s (%d import%s)\n", rel, n, plural(n))
+			files = append(files, stagedFile{path, out})
+		}
+		return nil
+	})
+	return files, err
+}
+
+// rewriteFileImports rewrites the imports of packages of the module from in
+// the Go file content to the module to, and returns the new content and the
+// number of imports rewritten.
+func rewriteFileImports(name string, content []byte, from, to string) ([]byte, int, error) {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, name, content, parser.ImportsOnly|parser.ParseComments)
+	if err != nil {
+		return nil, 0, err
+	}
+	var (
+		out  []byte
+		last int
+		n    int
+	)
+	for _, spec := range f.Imports {
+		path, err := strconv.Unquote(spec.Path.Value)
+		if err != nil {
+			return nil, 0, fmt.Errorf("%s: %w", fset.Position(spec.Path.Pos()), err)
+		}
+		if path != from && !strings.HasPrefix(path, from+"/") {
+			continue
+		}
+		start := fset.Position(spec.Path.Pos()).Offset
+		end := fset.Position(spec.Path.End()).Offset
+		out = append(out, content[last:start]...)
+		out = append(out, strconv.Quote(to+strings.TrimPrefix(path, from))...)
+		last = end
+		n++
+	}
+	if n == 0 {
+		return content, 0, nil
+	}
+	out = append(out, content[last:]...)
+	formatted, err := format.Source(out)
+	if err != nil {
+		return nil, 0, fmt.Errorf("%s: %w", name, err)
+	}
+	return formatted, n, nil
+}
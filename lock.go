@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// lockPollInterval is how often a busy lock is retried.
+const lockPollInterval = 100 * time.Millisecond
+
+// lockFile takes an advisory lock for path, exclusive for writers and shared
+// for readers, so concurrent runs can't interleave their reads and writes of
+// a go.mod file. The lock is taken on the directory of path rather than the
+// file itself, which the go command locks on its own while reading it (for
+// -vendor or in hooks). It waits for the lock as long as ctx allows. The
+// returned function releases the lock.
+func lockFile(ctx context.Context, path string, exclusive bool) (func(), error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, err
+	}
+	f, err := os.Open(filepath.Dir(path))
+	if err != nil {
+		return nil, err
+	}
+	for waiting := false; ; waiting = true {
+		ok, err := tryLock(f, exclusive)
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("lock %s: %w", path, err)
+		}
+		if ok {
+			return func() {
+				unlock(f)
+				f.Close()
+			}, nil
+		}
+		if !waiting {
+			fmt.Fprintf(os.Stderr, "(lock) waiting for another run to finish with %s\n", path)
+		}
+		select {
+		case <-ctx.Done():
+			f.Close()
+			return nil, ctx.Err()
+		case <-time.After(lockPollInterval):
+		}
+	}
+}
@@ -0,0 +1,12 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+// tryLock doesn't lock anything: advisory locks are only supported on Unix.
+func tryLock(f *os.File, exclusive bool) (bool, error) {
+	return true, nil
+}
+
+func unlock(f *os.File) {}
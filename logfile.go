@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/brettbuddin/modtransplant/transplant"
+)
+
+// Values of -log-format.
+const (
+	logFormatText = "text"
+	logFormatJSON = "json"
+)
+
+// console is the stderr of the process, which stays the terminal that
+// progress is reported on while -log-file or -summary capture os.Stderr.
+var console = os.Stderr
+
+// logOptions select the file the log of a run is kept in, for pipelines that
+// retain it as an artifact, and how much of it the console gets.
+type logOptions struct {
+	file   string
+	format string
+	// summary keeps the per-module action lines off the console.
+	summary bool
+}
+
+// actionMark starts the lines logged for the action taken on each module
+// while -summary keeps them off the console, so that they still reach the
+// -log-file in order with the rest.
+const actionMark = "\x1e"
+
+// summaryConsole is set by -summary.
+var summaryConsole bool
+
+// actionLog returns the writer the lines logged for the action taken on each
+// module go to. Warnings, conflicts and errors go to os.Stderr directly.
+func actionLog() io.Writer {
+	if summaryConsole {
+		return markWriter{os.Stderr}
+	}
+	return os.Stderr
+}
+
+// markWriter starts every line written to w with actionMark.
+type markWriter struct {
+	w io.Writer
+}
+
+func (m markWriter) Write(p []byte) (int, error) {
+	lines := strings.SplitAfter(string(p), "\n")
+	var b strings.Builder
+	for _, l := range lines {
+		if l != "" {
+			b.WriteString(actionMark + l)
+		}
+	}
+	if _, err := io.WriteString(m.w, b.String()); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// printConflicts prints the conflicts of r on the console with -summary, which
+// logged them with the actions taken on each module.
+func printConflicts(r *transplant.Report) {
+	if !summaryConsole || r == nil {
+		return
+	}
+	for _, e := range r.Entries {
+		switch e.(type) {
+		case transplant.ConflictResolved, transplant.ConflictUnresolved:
+			fmt.Fprintln(console, e)
+		}
+	}
+}
+
+// register adds the log file flags to fs.
+func (o *logOptions) register(fs *flag.FlagSet) {
+	fs.StringVar(&o.file, "log-file", "", "also write every line logged on stderr, and the error the run fails with, to this file")
+	fs.StringVar(&o.format, "log-format", logFormatText, "format of the -log-file: the lines as logged, or one JSON object per line (text or json)")
+	fs.BoolVar(&o.summary, "summary", false, "only print the final counts, conflicts and warnings on the console, not the action taken on each module (the -log-file still gets everything)")
+}
+
+// logLine is a line of the log in -log-format=json. Source is the
+// parenthesized prefix of the line, such as "require" or "batch", and Message
+// the rest of it.
+type logLine struct {
+	Time    string `json:"time"`
+	Level   string `json:"level"`
+	Source  string `json:"source,omitempty"`
+	Message string `json:"message"`
+}
+
+// newLogLine splits a logged line into its source and message.
+func newLogLine(level, line string) logLine {
+	l := logLine{Time: time.Now().UTC().Format(time.RFC3339Nano), Level: level, Message: line}
+	if rest, ok := strings.CutPrefix(line, "("); ok {
+		if source, message, ok := strings.Cut(rest, ") "); ok && !strings.ContainsAny(source, " ()") {
+			l.Source, l.Message = source, message
+		}
+	}
+	return l
+}
+
+// start copies everything written to os.Stderr from now on to the -log-file,
+// if any, as well as to the console, less the action lines with -summary. The
+// returned function restores os.Stderr and closes the file, after recording
+// runErr, the error the run fails with.
+func (o *logOptions) start() (stop func(runErr error) error, err error) {
+	switch o.format {
+	case logFormatText, logFormatJSON:
+	default:
+		return nil, fmt.Errorf("unsupported -log-format %q", o.format)
+	}
+	if o.file == "" && !o.summary {
+		return func(error) error { return nil }, nil
+	}
+	summaryConsole = o.summary
+	var f *os.File
+	if o.file != "" {
+		if f, err = os.Create(o.file); err != nil {
+			return nil, err
+		}
+	}
+	r, w, err := os.Pipe()
+	if err != nil {
+		if f != nil {
+			f.Close()
+		}
+		return nil, err
+	}
+	write := func(level, line string) error {
+		switch {
+		case f == nil:
+			return nil
+		case o.format == logFormatJSON:
+			return json.NewEncoder(f).Encode(newLogLine(level, line))
+		}
+		_, err := fmt.Fprintln(f, line)
+		return err
+	}
+	done := make(chan error, 1)
+	go func() {
+		var werr error
+		br := bufio.NewReader(r)
+		for {
+			line, err := br.ReadString('\n')
+			if line != "" {
+				action := strings.HasPrefix(line, actionMark)
+				line = strings.TrimPrefix(line, actionMark)
+				if !action {
+					fmt.Fprint(console, line)
+				}
+				if werr == nil {
+					werr = write("info", strings.TrimSuffix(line, "\n"))
+				}
+			}
+			if err != nil {
+				if !errors.Is(err, io.EOF) && werr == nil {
+					werr = err
+				}
+				done <- werr
+				return
+			}
+		}
+	}()
+	os.Stderr = w
+	return func(runErr error) error {
+		os.Stderr = console
+		summaryConsole = false
+		w.Close()
+		err := <-done
+		r.Close()
+		if runErr != nil && err == nil {
+			err = write("error", runErr.Error())
+		}
+		if f == nil {
+			return err
+		}
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		return err
+	}, nil
+}
@@ -1,211 +1,1035 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"log"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strings"
+	"text/template"
+	"time"
 
-	"github.com/Masterminds/semver"
 	"golang.org/x/mod/modfile"
 	"golang.org/x/mod/module"
+
+	"github.com/brettbuddin/modtransplant/transplant"
 )
 
-const usage = "modtransplant -dest=<destination-file> -src=<source-file> [-force-overwrite]"
+const usage = `modtransplant -dest=<destination-file> -src=<source-file> [-force-overwrite | -add-only] [-org-prefix=<patterns>] [-src-modules=<patterns>] [-set-module=<path>] [-prune-replaces] [-prune-excludes] [-fail-on-downgrade] [-fail-on-new-dep [-new-dep-paths=<patterns>]] [-fail-on-typosquat] [-popular-modules=<file>] [-check-versions] [-freeze=<patterns>] [-forbid-external-replaces] [-config=<file>] [-src-module=<path>] [-src-packages=<patterns>] [-skip-test-deps] [-latest [-same-major]] [-deep] [-rewrite=<rule> ...] [-auto-patch] [-resolve-excluded] [-security-only] [-depsdev] [-check-upstream [-inactive-after=<duration>]] [-fail-on-abandoned] [-rego-bundle=<bundle>] [-confirm] [-bzl-macro=<file>] [-report=html|buildkite|teamcity [-report-file=<file>]] [-summary-file=<file>] [-validate=build,vet,test [-test-args=<args>]] [-no-history] [-debug-trace=<file>] [-manifest=<file> [-sign [-sign-key=<key>]]] [-cpuprofile=<file>] [-memprofile=<file>] [-trace=<file>] [-summary] [-log-file=<file> [-log-format=text|json]] [-w [-vendor] [-rewrite-imports=<path>] [-changelog=<file> [-changelog-template=<file>]] | -emit=file|edits|gomodedit|goget|patch] [-emit=commit-msg]
+modtransplant (-recursive -dest=<directory> | -dest=<pattern>) -src=<source-file> -w [-continue-on-error] [merge flags]
+modtransplant export -dest=<destination-file> [-src=<source-file> [-config=<file>]] [-format=csv|json]
+modtransplant bazel-sync -dest=<destination-file> -bzl=<bazel-file> [-from-bazel] [-gosum=<go.sum>]
+modtransplant pin-replaces -dest=<destination-file> [-unpin] [-w]
+modtransplant overlap -dest=<destination-file> -src=<source-file> [-format=dot|mermaid]
+modtransplant verify-manifest -manifest=<file> [-bundle=<file>] (-key=<key> | -certificate-identity=<identity> -certificate-oidc-issuer=<issuer>) [go.mod ...]
+modtransplant serve
+modtransplant diff [-format=text|json] [-exit-code] <go.mod> <go.mod>
+modtransplant verify -dest=<destination-file> -src=<source-file>
+modtransplant why -dest=<destination-file> [-src=<source-file> [merge flags]] <module-path>
+modtransplant align-go [-work=<go.work>] [-go=max|<version>] [-toolchain=max|none|<toolchain>] [-w]
+modtransplant self-update [-url=<release-url>] [-check] [-force] [-key=<key> | -certificate-identity=<identity> -certificate-oidc-issuer=<issuer>]
+modtransplant manifest -dest=<destination-file> [-src=<source-file> [-config=<file>]] [-format=text|yaml] [-module=<path>]
+modtransplant convert [-format=yaml|json] <go.mod | document.yaml | document.json>`
 
 func main() {
-	if err := run(); err != nil {
+	// An interrupt cancels in-flight network requests and git commands. The
+	// destination is only written once everything has been fetched, so it is
+	// never left half-updated.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	err := run(ctx, os.Args[1:])
+	stop()
+	if errors.Is(err, context.Canceled) {
+		fmt.Fprintln(os.Stderr, "interrupted")
+		os.Exit(130)
+	}
+	if err != nil {
 		fmt.Fprintln(os.Stderr, err)
 		os.Exit(1)
 	}
 }
 
-func run() error {
+func run(ctx context.Context, args []string) error {
+	if len(args) > 0 {
+		switch args[0] {
+		case "export":
+			return runExport(ctx, args[1:])
+		case "bazel-sync":
+			return runBazelSync(args[1:])
+		case "pin-replaces":
+			return runPinReplaces(ctx, args[1:])
+		case "overlap":
+			return runOverlap(ctx, args[1:])
+		case "verify-manifest":
+			return runVerifyManifest(ctx, args[1:])
+		case "serve":
+			return runServe(ctx, args[1:])
+		case "why":
+			return runWhy(ctx, args[1:])
+		case "align-go":
+			return runAlignGo(ctx, args[1:])
+		case "diff":
+			return runDiff(ctx, args[1:])
+		case "verify":
+			return runVerify(ctx, args[1:])
+		case "self-update":
+			return runSelfUpdate(ctx, args[1:])
+		case "manifest":
+			return runRequirements(ctx, args[1:])
+		case "convert":
+			return runConvert(ctx, args[1:])
+		}
+	}
+	return runMerge(ctx, args)
+}
+
+// Values of -emit.
+const (
+	emitFile  = "file"
+	emitEdits = "edits"
+	// emitGoModEdit prints the go mod edit commands making the changes.
+	emitGoModEdit = "gomodedit"
+	// emitGoGet prints the go get commands making the version changes.
+	emitGoGet = "goget"
+	// emitPatch prints a unified diff of go.mod and go.sum for git apply.
+	emitPatch = "patch"
+	// emitCommitMsg prints a commit message describing the changes. Unlike
+	// the other values, it may be combined with -w.
+	emitCommitMsg = "commit-msg"
+)
+
+// Values of -report.
+const (
+	reportHTML      = "html"
+	reportBuildkite = "buildkite"
+	reportTeamCity  = "teamcity"
+)
+
+// stdinDest is the -dest value reading the destination from stdin, and
+// writing nothing but the result to stdout.
+const stdinDest = "-"
+
+func runMerge(ctx context.Context, args []string) (err error) {
 	var (
-		destFile       string
-		srcFile        string
-		forceOverwrite bool
+		destFile          string
+		srcFile           string
+		configFile        string
+		recursive         bool
+		continueOnError   bool
+		srcOpts           sourceOptions
+		reportFormat      string
+		reportFile        string
+		summaryFile       string
+		srcPackages       string
+		validate          string
+		testArgs          string
+		changelogTemplate string
+		manifestFile      string
+		debugTraceFile    string
+		popularFile       string
+		sign              signOptions
+		netOpts           netOptions
+		profile           profileOptions
+		logs              logOptions
+		opts              mergeOptions
 	)
 	fs := flag.NewFlagSet("modtransplant", flag.ExitOnError)
-	fs.StringVar(&destFile, "dest", "", "destination go.mod file")
-	fs.StringVar(&srcFile, "src", "", "source go.mod file")
-	fs.BoolVar(&forceOverwrite, "force-overwrite", false, "force overwrite of versions of matching module paths")
-	if err := fs.Parse(os.Args[1:]); err != nil {
+	fs.StringVar(&destFile, "dest", "", "destination go.mod file, glob pattern of several, - for stdin (or directory, with -recursive)")
+	fs.StringVar(&srcFile, "src", "", "source module (go.mod file, directory of modules, Go binary, vendor/modules.txt, requirements manifest, archive or oci:// image)")
+	fs.BoolVar(&recursive, "recursive", false, "merge into every go.mod file below the -dest directory (requires -w)")
+	fs.BoolVar(&continueOnError, "continue-on-error", false, "with several destinations, keep going when one fails and report all failures at the end")
+	fs.BoolVar(&opts.forceOverwrite, "force-overwrite", false, "force overwrite of versions of matching module paths")
+	fs.BoolVar(&opts.addOnly, "add-only", false, "only add missing entries; fail if an existing destination entry would change")
+	fs.StringVar(&opts.setModule, "set-module", "", "rename the destination's module (with -recursive, the one at the root of the tree) and update the references to its old path")
+	fs.StringVar(&opts.srcModules, "src-modules", "", "comma-separated glob path prefixes (as in GOPRIVATE) of modules that are part of the source, such as its nested modules, whose requirements and replacements the destination drops like the source module's")
+	fs.StringVar(&opts.orgPrefix, "org-prefix", "", "comma-separated glob path prefixes (as in GOPRIVATE) of the only modules to transplant, with their replacements, at the source's versions")
+	fs.BoolVar(&opts.failOnDowngrade, "fail-on-downgrade", false, "fail if the merge would lower any destination version, even with -force-overwrite")
+	fs.BoolVar(&opts.failOnNewDep, "fail-on-new-dep", false, "fail if the merge would add a new direct dependency")
+	fs.StringVar(&opts.newDepPaths, "new-dep-paths", "", "comma-separated glob path prefixes restricting -fail-on-new-dep (as in GOPRIVATE)")
+	fs.StringVar(&opts.freeze, "freeze", "", "comma-separated glob path prefixes (as in GOPRIVATE) of modules the merge must not add, remove, reversion or replace differently")
+	fs.BoolVar(&opts.pruneReplaces, "prune-replaces", false, "drop replacements of modules no longer required by the result")
+	fs.BoolVar(&opts.pruneExcludes, "prune-excludes", false, "drop exclusions of versions the result can no longer select")
+	fs.BoolVar(&opts.checkVersions, "check-versions", false, "fail before writing if the GOPROXY doesn't have every module version the merge transplants")
+	fs.BoolVar(&opts.checkUpstream, "check-upstream", false, "look up the GitHub repositories of added modules and flag archived or inactive ones in the report")
+	fs.DurationVar(&opts.inactiveAfter, "inactive-after", defaultInactiveAfter, "time without a push after which -check-upstream considers a repository inactive")
+	fs.BoolVar(&opts.failOnAbandoned, "fail-on-abandoned", false, "fail if the merge would add a module whose GitHub repository is archived or inactive (implies -check-upstream)")
+	fs.StringVar(&opts.githubAPIURL, "github-api-url", defaultGitHubAPIURL, "base URL of the GitHub API used by -check-upstream")
+	fs.BoolVar(&opts.failOnTyposquat, "fail-on-typosquat", false, "fail if the merge would add a module whose path is a near miss of a popular module's")
+	fs.StringVar(&popularFile, "popular-modules", "", "file of further module paths, one per line, that added modules are checked against for typosquats")
+	fs.BoolVar(&opts.forbidExternalReplaces, "forbid-external-replaces", false, "fail if a replacement points to a directory outside the destination's repository")
+	fs.StringVar(&opts.bzlMacroFile, "bzl-macro", "", "write a deps.bzl-style macro file for the merged module set")
+	fs.StringVar(&opts.bzlMacroName, "bzl-macro-name", "go_dependencies", "name of the macro defined in the -bzl-macro file")
+	fs.BoolVar(&opts.write, "w", false, "write the result to the destination file instead of stdout")
+	fs.BoolVar(&opts.confirm, "confirm", false, "show each change and ask whether to apply it")
+	fs.StringVar(&opts.changelog, "changelog", "", "write a changelog fragment of the dependency changes to this file, relative to the destination's directory, or - for stdout (requires -w)")
+	fs.StringVar(&changelogTemplate, "changelog-template", "", "text/template file the -changelog fragment is rendered with instead of the default Markdown list")
+	fs.StringVar(&opts.emit, "emit", emitFile, "what to print instead of writing the result: the merged file, or the edits turning the destination into it as JSON, the go mod edit commands making the changes, the go get commands making the version changes, a patch of go.mod and go.sum for git apply, or a commit message describing the changes, which -w may be combined with (file, edits, gomodedit, goget, patch or commit-msg)")
+	fs.StringVar(&validate, "validate", "", "comma-separated checks the result must pass in a copy of the destination module before anything is written (build, vet, test)")
+	fs.StringVar(&testArgs, "test-args", "", "space-separated packages and flags passed to go test by -validate=test (default \""+defaultTestArgs+"\")")
+	fs.StringVar(&opts.rewriteImports, "rewrite-imports", "", "rewrite the imports of the source module's packages in the destination module's Go files to this path, where the source now lives (requires -w)")
+	fs.BoolVar(&opts.vendor, "vendor", false, "run 'go mod vendor' in the destination module after writing (requires -w)")
+	fs.StringVar(&srcOpts.module, "src-module", "", "module path of the source, for a source go.mod file without a module directive (replaces the one it has)")
+	fs.StringVar(&srcPackages, "src-packages", "", "space-separated package patterns of the source module (e.g. ./cmd/server/...) to only transplant the modules they need")
+	fs.BoolVar(&srcOpts.skipTestDeps, "skip-test-deps", false, "leave out the source's requirements only its tests need")
+	fs.BoolVar(&srcOpts.latest, "latest", false, "upgrade transplanted modules to their latest release")
+	fs.BoolVar(&srcOpts.sameMajor, "same-major", false, "restrict -latest to releases of the same major version")
+	fs.BoolVar(&srcOpts.deep, "deep", false, "also transplant the source's transitive requirements, at the versions minimal version selection picks")
+	fs.Var(&srcOpts.rewrites, "rewrite", "sed-style rule rewriting the source's module paths and replacement targets, e.g. 's#^github.com/old-org/#github.com/new-org/#' (repeatable)")
+	fs.BoolVar(&opts.resolveExcluded, "resolve-excluded", false, "when the version selected for a module is excluded, look up the next higher version through the GOPROXY instead of failing")
+	fs.BoolVar(&opts.autoPatch, "auto-patch", false, "resolve versions differing only in patch to the highest patch release")
+	fs.BoolVar(&opts.securityOnly, "security-only", false, "only raise destination versions to fix known OSV advisories")
+	fs.StringVar(&opts.osvURL, "osv-url", defaultOSVURL, "base URL of the OSV API used by -security-only")
+	fs.BoolVar(&opts.depsDev, "depsdev", false, "look up the maintenance signals of added modules on deps.dev (latest release, dependents, OpenSSF scorecard) and include them in the report")
+	fs.StringVar(&opts.depsDevURL, "depsdev-url", defaultDepsDevURL, "base URL of the deps.dev API used by -depsdev")
+	fs.StringVar(&opts.regoBundle, "rego-bundle", "", "Rego policy bundle (directory or tarball) evaluated with 'opa' against the change set")
+	fs.StringVar(&configFile, "config", "", "JSON config file (e.g. with a conflict_policy)")
+	fs.StringVar(&manifestFile, "manifest", "", "write a JSON manifest of the run to the given file")
+	fs.BoolVar(&sign.sign, "sign", false, "sign the -manifest with cosign (keyless unless -sign-key is given)")
+	fs.StringVar(&sign.key, "sign-key", "", "cosign key reference used by -sign")
+	fs.StringVar(&debugTraceFile, "debug-trace", "", "write every comparison and rule evaluation of the merge to this file as JSON")
+	fs.BoolVar(&opts.noHistory, "no-history", false, "don't append a record of the run to "+historyFile+" at the root of the destination's repository")
+	fs.StringVar(&reportFormat, "report", "", "write a report of the run in the given format: an HTML page, Markdown added to the build as a Buildkite annotation, or TeamCity service messages on stdout (html, buildkite or teamcity)")
+	fs.StringVar(&reportFile, "report-file", "", "file the html or buildkite -report is written to (default modtransplant-report.html, or .md for buildkite)")
+	fs.StringVar(&summaryFile, "summary-file", os.Getenv(stepSummaryEnv), "file a Markdown report of the run is appended to (defaults to $"+stepSummaryEnv+" in GitHub Actions)")
+	netOpts.register(fs)
+	profile.register(fs)
+	logs.register(fs)
+	if err := fs.Parse(args); err != nil {
 		return err
 	}
 
 	if destFile == "" || srcFile == "" {
 		return errors.New(usage)
 	}
+	if sign.sign && manifestFile == "" {
+		return errors.New("-sign requires -manifest")
+	}
+	if sign.key != "" && !sign.sign {
+		return errors.New("-sign-key requires -sign")
+	}
+	switch reportFormat {
+	case "", reportHTML, reportTeamCity:
+	case reportBuildkite:
+		if reportFile == "" {
+			reportFile = "modtransplant-report.md"
+		}
+	default:
+		return fmt.Errorf("unsupported report format %q", reportFormat)
+	}
+	if reportFile == "" {
+		reportFile = "modtransplant-report.html"
+	}
+	if reportFormat == reportTeamCity && destFile == stdinDest {
+		return errors.New("-report=teamcity can't be combined with -dest=-, whose stdout only has the merged file")
+	}
+	if err := opts.check(srcOpts); err != nil {
+		return err
+	}
+	if changelogTemplate != "" && opts.changelog == "" {
+		return errors.New("-changelog-template requires -changelog")
+	}
+	if opts.changelog != "" {
+		var err error
+		if opts.changelogTemplate, err = parseChangelogTemplate(changelogTemplate); err != nil {
+			return err
+		}
+	}
+	if destFile == stdinDest && (opts.write || recursive) {
+		return errors.New("-dest=- can't be combined with -w or -recursive")
+	}
+	if opts.validate, err = parseValidate(validate); err != nil {
+		return err
+	}
+	if destFile == stdinDest && len(opts.validate) > 0 {
+		return errors.New("-validate can't be combined with -dest=-")
+	}
+	if destFile == stdinDest && opts.confirm {
+		return errors.New("-confirm can't be combined with -dest=-, whose stdin it reads the answers from")
+	}
+	if testArgs != "" && !slices.Contains(opts.validate, "test") {
+		return errors.New("-test-args requires -validate=test")
+	}
+	if testArgs == "" {
+		testArgs = defaultTestArgs
+	}
+	opts.testArgs = strings.Fields(testArgs)
+	// Several destinations are selected by a directory or a glob pattern.
+	batch := recursive || isGlobPattern(destFile)
+	if batch && opts.emit == emitCommitMsg {
+		return errors.New("-emit=commit-msg can't be combined with -recursive or -dest patterns")
+	}
+	if batch && !opts.write {
+		return errors.New("-recursive and -dest patterns require -w")
+	}
+	if batch && opts.bzlMacroFile != "" {
+		return errors.New("-bzl-macro can't be combined with -recursive or -dest patterns")
+	}
+	if opts.setModule != "" && batch && !recursive {
+		return errors.New("-set-module can't be combined with -dest patterns")
+	}
+	if continueOnError && !batch {
+		return errors.New("-continue-on-error requires -recursive or a -dest pattern")
+	}
+	switch netOpts.resolver {
+	case resolverProxy, resolverGo, resolverNone:
+	default:
+		return fmt.Errorf("unsupported -resolver %q", netOpts.resolver)
+	}
+	// Module queries about the source are answered in the destination's
+	// directory, or the root of the destination tree.
+	switch {
+	case recursive:
+		netOpts.resolverDir = destFile
+	case !batch && destFile != stdinDest:
+		netOpts.resolverDir = filepath.Dir(destFile)
+	}
 
-	destContent, err := ioutil.ReadFile(destFile)
+	srcOpts.packages = strings.Fields(srcPackages)
+	opts.popularModules = popularModules
+	if popularFile != "" {
+		paths, err := readPopularModules(popularFile)
+		if err != nil {
+			return err
+		}
+		opts.popularModules = append(paths, popularModules...)
+	}
+	opts.args = args
+	cfg, err := loadConfig(configFile)
 	if err != nil {
 		return err
 	}
-	dest, err := modfile.Parse(destFile, destContent, nil)
+	if len(cfg.Mapping) > 0 {
+		if err := checkMappingFlags(srcFile, destFile, batch, &opts); err != nil {
+			return err
+		}
+	}
+
+	if reportFormat != "" || manifestFile != "" || summaryFile != "" {
+		opts.results = new([]fileResult)
+	}
+	if manifestFile != "" {
+		netOpts.metrics = newRunMetrics()
+	}
+	if debugTraceFile != "" {
+		opts.debugTraces = new([]debugTrace)
+	}
+	stopLog, err := logs.start()
 	if err != nil {
 		return err
 	}
+	defer func() {
+		if lerr := stopLog(err); lerr != nil && err == nil {
+			err = lerr
+		}
+	}()
+	stopProfiles, err := profile.start()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if perr := stopProfiles(); perr != nil && err == nil {
+			err = perr
+		}
+	}()
+	switch {
+	case len(cfg.Mapping) > 0:
+		err = mergeMapping(ctx, configFile, srcFile, destFile, &netOpts, srcOpts, &opts, cfg.Mapping)
+	case !batch:
+		var src *modfile.File
+		if src, err = opts.prepare(ctx, configFile, srcFile, &netOpts, srcOpts); err != nil {
+			return err
+		}
+		err = mergeInto(ctx, destFile, src, &opts)
+	default:
+		var src *modfile.File
+		if src, err = opts.prepare(ctx, configFile, srcFile, &netOpts, srcOpts); err != nil {
+			return err
+		}
+		var destFiles []string
+		if recursive {
+			destFiles, err = findModFiles(destFile)
+			if err == nil && opts.setModule != "" {
+				err = opts.setRenamedModule(filepath.Join(destFile, "go.mod"))
+			}
+		} else {
+			destFiles, err = globModFiles(destFile)
+		}
+		if err == nil {
+			err = mergeBatch(ctx, destFiles, src, &opts, continueOnError)
+		}
+	}
+	if reportFormat != "" && !errors.Is(err, context.Canceled) {
+		var rerr error
+		switch reportFormat {
+		case reportHTML:
+			rerr = writeHTMLReportFile(reportFile, srcFile, *opts.results)
+		case reportBuildkite:
+			rerr = writeBuildkiteReport(ctx, reportFile, srcFile, *opts.results)
+		case reportTeamCity:
+			rerr = writeTeamCityReport(os.Stdout, *opts.results)
+		}
+		if rerr != nil && err == nil {
+			err = rerr
+		}
+	}
+	if summaryFile != "" && !errors.Is(err, context.Canceled) {
+		if serr := appendMarkdownReport(summaryFile, srcFile, *opts.results); serr != nil && err == nil {
+			err = serr
+		}
+	}
+	// A trace is most useful when the run failed.
+	if debugTraceFile != "" {
+		if derr := writeDebugTrace(debugTraceFile, *opts.debugTraces); derr != nil && err == nil {
+			err = derr
+		}
+	}
+	// Only a successful run yields a manifest vouching for its result.
+	if manifestFile != "" && err == nil {
+		err = writeManifest(ctx, manifestFile, srcFile, opts.rewrites, *opts.results, netOpts.metrics, sign)
+	}
+	return err
+}
+
+// mergeOptions are the settings a source is merged into each destination with.
+type mergeOptions struct {
+	srcFile        string
+	cfg            *config
+	resolver       transplant.Resolver
+	net            *netOptions
+	forceOverwrite bool
+	addOnly        bool
+	// orgPrefix restricts the merge to the modules matching its patterns.
+	orgPrefix string
+	// absorbed are the paths of the nested modules of a source tree, and
+	// srcModules the -src-modules patterns of further modules that are part
+	// of the source, all dropped from the destination like the source module.
+	absorbed   []string
+	srcModules string
+	// setModule is the new path of the destination's module or, with
+	// -recursive, of the module in renamedFile, whose old path, renamedModule,
+	// is replaced in the go.mod files of the nested modules as well.
+	setModule     string
+	renamedFile   string
+	renamedModule string
+	pruneReplaces bool
+	pruneExcludes bool
+	autoPatch     bool
+	securityOnly  bool
+	// resolveExcluded looks up the version replacing an excluded one.
+	resolveExcluded bool
+	osvURL          string
+	// depsDev looks up the health of added modules on deps.dev.
+	depsDev      bool
+	depsDevURL   string
+	regoBundle   string
+	bzlMacroFile string
+	bzlMacroName string
+	write        bool
+	emit         string
+	vendor       bool
+	noHistory    bool
+	// confirm asks on stdin whether to apply each change.
+	confirm bool
+	// changelog is where the changelog fragment of the changes is written,
+	// rendered with changelogTemplate.
+	changelog         string
+	changelogTemplate *template.Template
+	// rewriteImports is the path the imports of the source's packages are
+	// rewritten to.
+	rewriteImports string
+	// validate are the -validate checks, and testArgs the arguments of
+	// their go test.
+	validate []string
+	testArgs []string
+
+	// args are the command line arguments and srcDigest the digest of the
+	// source file, both recorded in the history.
+	args      []string
+	srcDigest string
+	// trace records what was done to the source, to explain changes with.
+	trace trace
+	// rewrites are the module paths of the source changed by -rewrite, for
+	// the manifest.
+	rewrites []rewrite
+	// results collects the outcome of every destination for a report, if
+	// not nil.
+	results *[]fileResult
+	// debugTraces collects the steps of the merge into every destination
+	// for -debug-trace, if not nil.
+	debugTraces *[]debugTrace
+	// output is where results that aren't written back go instead of
+	// stdout, if not nil.
+	output io.Writer
+
+	// Gates checked against the changes of a merge before anything is
+	// written.
+	failOnDowngrade        bool
+	failOnNewDep           bool
+	newDepPaths            string
+	forbidExternalReplaces bool
+	// checkUpstream flags added modules whose GitHub repositories are
+	// archived, or have had no push for inactiveAfter, which fail the merge
+	// with failOnAbandoned.
+	checkUpstream   bool
+	inactiveAfter   time.Duration
+	failOnAbandoned bool
+	githubAPIURL    string
+	// checkVersions confirms through the proxy that every transplanted
+	// version exists.
+	checkVersions bool
+	// popularModules are the paths added modules are checked against for
+	// typosquats, which fail the merge with failOnTyposquat.
+	popularModules  []string
+	failOnTyposquat bool
+	// freeze are the -freeze patterns of frozen modules, to which the
+	// config file's are added.
+	freeze string
+}
+
+// sourceOptions select how the source is reduced or expanded before it is
+// merged.
+type sourceOptions struct {
+	// packages are the -src-packages patterns, if any.
+	packages     []string
+	skipTestDeps bool
+	latest       bool
+	sameMajor    bool
+	deep         bool
+	// rewrites are the -rewrite rules, applied to the source last.
+	rewrites rewriteRules
+	// modules are the go.mod files combined from a source directory, if not
+	// all of them; see loadSourceTree.
+	modules []string
+	// module is the -src-module path of a source without a module
+	// directive.
+	module string
+}
+
+// check reports options of a merge that can't be combined, whether they come
+// from flags or from the parameters of an RPC request.
+func (opts *mergeOptions) check(srcOpts sourceOptions) error {
+	if opts.vendor && !opts.write {
+		return errors.New("-vendor requires -w")
+	}
+	if opts.rewriteImports != "" {
+		if !opts.write {
+			return errors.New("-rewrite-imports requires -w")
+		}
+		if err := module.CheckImportPath(opts.rewriteImports); err != nil {
+			return fmt.Errorf("-rewrite-imports: %w", err)
+		}
+	}
+	if opts.changelog != "" && !opts.write {
+		return errors.New("-changelog requires -w")
+	}
+	if srcOpts.sameMajor && !srcOpts.latest {
+		return errors.New("-same-major requires -latest")
+	}
+	switch opts.emit {
+	case emitFile, emitCommitMsg:
+	case emitEdits, emitGoModEdit, emitGoGet, emitPatch:
+		if opts.write {
+			return fmt.Errorf("-emit=%s can't be combined with -w", opts.emit)
+		}
+	default:
+		return fmt.Errorf("unsupported -emit value %q", opts.emit)
+	}
+	if opts.addOnly && opts.forceOverwrite {
+		return errors.New("-add-only can't be combined with -force-overwrite")
+	}
+	if opts.addOnly && opts.orgPrefix != "" {
+		return errors.New("-add-only can't be combined with -org-prefix")
+	}
+	if opts.newDepPaths != "" && !opts.failOnNewDep {
+		return errors.New("-new-dep-paths requires -fail-on-new-dep")
+	}
+	if opts.setModule != "" {
+		if err := checkModulePath(opts.setModule); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// prepare completes opts with the config file and the source, and loads the
+// source, upgrading it to the latest releases and adding its transitive
+// requirements if requested.
+func (opts *mergeOptions) prepare(ctx context.Context, configFile, srcFile string, netOpts *netOptions, srcOpts sourceOptions) (*modfile.File, error) {
+	cfg, err := loadConfig(configFile)
+	if err != nil {
+		return nil, err
+	}
+	opts.cfg = cfg
+	if opts.resolver, err = cfg.resolver(); err != nil {
+		return nil, err
+	}
+	opts.srcFile = srcFile
+	opts.net = netOpts
+	opts.trace = trace{}
+	opts.absorbed = nil
+	if opts.srcDigest, err = fileDigest(srcFile); err != nil {
+		return nil, err
+	}
+
+	stopParse := netOpts.metrics.time(phaseParse)
+	var src *modfile.File
+	if info, serr := os.Stat(srcFile); serr == nil && info.IsDir() {
+		// The modules of a source tree that lead to a change are part of
+		// its explanation.
+		// The nested modules of the tree are absorbed along with it.
+		src, opts.absorbed, err = loadSourceTree(srcFile, srcOpts.modules, opts.trace)
+	} else {
+		src, err = loadSource(ctx, srcFile, netOpts)
+	}
+	stopParse()
+	if err != nil {
+		return nil, err
+	}
+	if err := setSourceModule(src, srcFile, srcOpts.module); err != nil {
+		return nil, err
+	}
+	if src.Module.Deprecated != "" {
+		fmt.Fprintf(os.Stderr, "(deprecated) source module %s: %s\n", src.Module.Mod.Path, src.Module.Deprecated)
+	}
+	// Package patterns never include tests, so -skip-test-deps only needs
+	// patterns of its own if none are given.
+	packages := srcOpts.packages
+	if len(packages) == 0 && srcOpts.skipTestDeps {
+		packages = []string{"./..."}
+	}
+	if len(packages) > 0 {
+		if err := restrictToPackages(ctx, src, srcFile, packages); err != nil {
+			return nil, err
+		}
+	}
+	if srcOpts.latest {
+		if err := upgradeToLatest(ctx, src, netOpts, srcOpts.sameMajor, opts.trace); err != nil {
+			return nil, err
+		}
+	}
+	if srcOpts.deep {
+		if err := deepenSource(ctx, src, srcFile, netOpts, opts.trace); err != nil {
+			return nil, err
+		}
+	}
+	if len(srcOpts.rewrites) > 0 {
+		if opts.rewrites, err = rewritePaths(src, srcOpts.rewrites, opts.trace); err != nil {
+			return nil, err
+		}
+	}
+	return src, nil
+}
+
+// setRenamedModule records the module of the go.mod file at path as the one
+// renamed by -set-module in a recursive run.
+func (opts *mergeOptions) setRenamedModule(path string) error {
+	f, err := parseModFile(path)
+	if err != nil {
+		return fmt.Errorf("-set-module with -recursive requires a module at the root of -dest: %w", err)
+	}
+	opts.renamedFile = filepath.Clean(path)
+	opts.renamedModule = f.Module.Mod.Path
+	return nil
+}
+
+func (opts *mergeOptions) stdout() io.Writer {
+	if opts.output != nil {
+		return opts.output
+	}
+	return os.Stdout
+}
+
+// mergeInto merges src into the go.mod file at destFile. src is left
+// untouched, so it can be merged into several destinations.
+func mergeInto(ctx context.Context, destFile string, src *modfile.File, opts *mergeOptions) (err error) {
+	result := fileResult{Dest: destFile}
+	debug := debugTrace{Dest: destFile}
+	defer func() {
+		result.Err = err
+		if opts.results != nil {
+			*opts.results = append(*opts.results, result)
+		}
+		if opts.debugTraces != nil {
+			if err != nil {
+				debug.Err = err.Error()
+			}
+			*opts.debugTraces = append(*opts.debugTraces, debug)
+		}
+		// A -dest=- run is a pure filter, which leaves no trace on disk.
+		if !opts.noHistory && destFile != stdinDest && !errors.Is(err, context.Canceled) {
+			if herr := appendHistory(result, opts); herr != nil && err == nil {
+				err = herr
+			}
+		}
+	}()
 
-	sourceContent, err := ioutil.ReadFile(srcFile)
+	result.Digests.Src = opts.srcDigest
+	stopParse := opts.net.metrics.time(phaseParse)
+	name, content := destFile, []byte(nil)
+	if destFile == stdinDest {
+		name = "<stdin>"
+		content, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		// The destination stays locked until the result is written, so
+		// concurrent runs can't lose each other's changes.
+		var unlock func()
+		if unlock, err = lockFile(ctx, destFile, opts.write); err != nil {
+			return err
+		}
+		defer unlock()
+		content, err = ioutil.ReadFile(destFile)
+	}
 	if err != nil {
 		return err
 	}
-	src, err := modfile.Parse(srcFile, sourceContent, nil)
+	result.Digests.Dest = digest(content)
+	enc, normalized := detectEncoding(content)
+	dest, err := modfile.Parse(name, normalized, nil)
 	if err != nil {
 		return err
 	}
+	if dest.Module == nil {
+		return fmt.Errorf("%s has no module directive", name)
+	}
+	result.Module = dest.Module.Mod.Path
+	result.Deprecated = dest.Module.Deprecated
+	src, err = cloneModFile(src)
+	if err != nil {
+		return err
+	}
+	if src.Module != nil {
+		result.SrcDeprecated = src.Module.Deprecated
+	}
+	stopParse()
+	newReport := func(stage string, changes []change) report {
+		return report{Stage: stage, Module: dest.Module.Mod.Path, Dest: destFile, Src: opts.srcFile, Changes: changes, Digests: result.Digests, Deprecated: result.Deprecated, SrcDeprecated: result.SrcDeprecated}
+	}
+	resolver := opts.resolver
+	if opts.cfg.Hooks.OnConflict != "" {
+		resolver = hookResolver{
+			hook: func(ctx context.Context, c transplant.Conflict) error {
+				r := newReport("on-conflict", nil)
+				r.Conflict = &c
+				return runHook(ctx, opts.cfg.Hooks.OnConflict, r)
+			},
+			next: resolver,
+		}
+	}
+	stopResolve := opts.net.metrics.time(phaseResolve)
+	// Module queries about the destination are answered in its directory.
+	net := opts.net
+	if destFile != stdinDest {
+		net = net.in(filepath.Dir(destFile))
+	}
+	t := opts.trace.clone()
+	if err := holdControlled(dest, src, t); err != nil {
+		return err
+	}
+	if opts.autoPatch {
+		if err := upgradePatches(ctx, dest, src, net, t); err != nil {
+			return err
+		}
+	}
+	if opts.securityOnly {
+		if err := filterSecurityFixes(ctx, dest, src, opts.net, opts.osvURL, t); err != nil {
+			return err
+		}
+	}
 
-	if err := mergeRequires(dest, src, forceOverwrite); err != nil {
+	before, err := cloneModFile(dest)
+	if err != nil {
 		return err
 	}
-	if err := mergeReplacements(dest, src); err != nil {
+	mergeOpts := []transplant.Option{transplant.WithForceOverwrite(opts.forceOverwrite), transplant.WithAddOnly(opts.addOnly), transplant.WithResolver(resolver), transplant.WithLogger(log.New(actionLog(), "", 0))}
+	if len(opts.absorbed) > 0 || opts.srcModules != "" {
+		mergeOpts = append(mergeOpts, transplant.WithAbsorbed(func(path string) bool {
+			return slices.Contains(opts.absorbed, path) || (opts.srcModules != "" && module.MatchPrefixPatterns(opts.srcModules, path))
+		}))
+	}
+	if opts.resolveExcluded {
+		p, err := newModuleFetcher(net)
+		if err != nil {
+			return err
+		}
+		mergeOpts = append(mergeOpts, transplant.WithFetcher(p))
+	}
+	if opts.debugTraces != nil {
+		mergeOpts = append(mergeOpts, transplant.WithDebug(func(s transplant.Step) {
+			debug.Steps = append(debug.Steps, s)
+		}))
+	}
+	if opts.orgPrefix != "" {
+		// Only the organization's modules are transplanted, at the source's
+		// versions, and the replacements of the other modules are left alone.
+		mergeOpts = append(mergeOpts, transplant.WithForceOverwrite(true), transplant.WithFilter(func(m module.Version) bool {
+			return module.MatchPrefixPatterns(opts.orgPrefix, m.Path)
+		}))
+	}
+	mergeReport, err := transplant.Merge(ctx, dest, src, mergeOpts...)
+	t.noteEntries(mergeReport)
+	printConflicts(mergeReport)
+	if err != nil {
 		return err
 	}
-	if err := mergeExcludes(dest, src); err != nil {
+	if opts.setModule != "" {
+		from, rename := opts.renamedModule, filepath.Clean(destFile) == opts.renamedFile
+		if opts.renamedFile == "" {
+			from, rename = dest.Module.Mod.Path, true
+		}
+		if err := renameModule(dest, from, opts.setModule, rename, t); err != nil {
+			return err
+		}
+	}
+	if err := pruneReplaces(dest, opts.pruneReplaces, t); err != nil {
+		return err
+	}
+	if err := pruneExcludes(dest, opts.pruneExcludes, t); err != nil {
 		return err
 	}
+	if opts.regoBundle != "" {
+		if err := applyRegoPolicy(ctx, opts.regoBundle, before, dest, destFile, opts.srcFile); err != nil {
+			return err
+		}
+	}
+	changes := diffModFiles(before, dest)
+	explain(changes, t)
+	if opts.confirm && len(changes) > 0 {
+		fmt.Fprintf(os.Stderr, "(confirm) %s: %d change%s\n", name, len(changes), plural(len(changes)))
+		if err := confirmChanges(os.Stdin, os.Stderr, before, dest, changes); err != nil {
+			return err
+		}
+		changes = diffModFiles(before, dest)
+		explain(changes, t)
+	}
+	result.Changes = changes
+	if opts.depsDev {
+		if result.Health, err = addedModulesHealth(ctx, changes, opts.net, opts.depsDevURL); err != nil {
+			return err
+		}
+	}
+	if opts.checkUpstream || opts.failOnAbandoned {
+		if result.Upstream, err = upstreamStatuses(ctx, changes, opts.net, opts.githubAPIURL, opts.inactiveAfter); err != nil {
+			return err
+		}
+	}
+	if opts.failOnDowngrade {
+		if err := checkDowngrades(changes); err != nil {
+			return err
+		}
+	}
+	if opts.failOnNewDep {
+		if err := checkNewDeps(changes, opts.newDepPaths); err != nil {
+			return err
+		}
+	}
+	if opts.failOnAbandoned {
+		if err := checkAbandoned(result.Upstream); err != nil {
+			return err
+		}
+	}
+	if err := checkTyposquats(changes, opts.popularModules, opts.failOnTyposquat); err != nil {
+		return err
+	}
+	if err := checkFrozen(changes, frozenPatterns(opts.freeze, opts.cfg.Freeze)); err != nil {
+		return err
+	}
+	if err := checkLocalReplaces(dest, destFile, opts.forbidExternalReplaces); err != nil {
+		return err
+	}
+	if err := checkGoDirective(dest, opts.cfg.GoDirective.Min, opts.cfg.GoDirective.Max); err != nil {
+		return err
+	}
+	if opts.checkVersions {
+		if err := checkVersionsExist(ctx, changes, net); err != nil {
+			return err
+		}
+	}
+	stopResolve()
 
-	dest.Cleanup()
 	out, err := dest.Format()
 	if err != nil {
 		return err
 	}
-	fmt.Println(string(out))
-
-	return nil
-}
+	beforeOut, err := before.Format()
+	if err != nil {
+		return err
+	}
+	// The output keeps the encoding of the destination; reports and the
+	// summary compare the normalized files.
+	encoded := enc.apply(out)
+	result.Digests.Output = digest(encoded)
+	if opts.results != nil {
+		result.Before, result.After = string(beforeOut), string(out)
+	}
+	if err := runHook(ctx, opts.cfg.Hooks.PreMerge, newReport("pre-merge", changes)); err != nil {
+		return err
+	}
 
-// mergeRequires merges "require" statements into the destination.
-//
-// Mutation Rules:
-// - Module paths missing from the destination entirely will be added.
-// - Module paths in the destination that have mismatched versions will be
-// overwritten by what's in the source.
-// - Module paths that are indirect in the destination, but direct in the source
-// will be made direct.
-// - Any dependency that the destination has on the source will be removed.
-func mergeRequires(dest, src *modfile.File, forceOverwrite bool) error {
-	if err := dest.DropRequire(src.Module.Mod.Path); err != nil {
-		return err
-	}
-
-	for _, srcR := range src.Require {
-		var found bool
-		for _, destR := range dest.Require {
-			if srcR.Mod.String() == destR.Mod.String() {
-				fmt.Fprintf(os.Stderr, "(require) match: %s\n", srcR.Mod)
-				found = true
-				break
-			}
-			if srcR.Mod.Path == destR.Mod.Path {
-				if srcR.Mod.Version != destR.Mod.Version {
-					destVersion, err := semver.NewVersion(destR.Mod.Version)
-					if err != nil {
-						return err
-					}
-					srcVersion, err := semver.NewVersion(srcR.Mod.Version)
-					if err != nil {
-						return err
-					}
-
-					replace := func() {
-						fmt.Fprintf(os.Stderr, "(require) replace version: %s %s -> %s\n", destR.Mod.Path, destR.Mod.Version, srcR.Mod.Version)
-						destR.Mod.Version = srcR.Mod.Version
-					}
-					if forceOverwrite {
-						replace()
-					} else {
-						if !canCompare(destVersion, srcVersion) {
-							return fmt.Errorf("cannot reconcile difference between versions: dest=%s src=%s", destR.Mod, srcR.Mod)
-						}
-						if srcVersion.LessThan(destVersion) {
-							replace()
-						}
-					}
-				}
-				if destR.Indirect != !srcR.Indirect {
-					fmt.Fprintf(os.Stderr, "(require) make direct: %s\n", srcR.Mod)
-					destR.Indirect = srcR.Indirect
-				}
-			}
+	var goFiles []stagedFile
+	if opts.rewriteImports != "" {
+		if src.Module == nil || src.Module.Mod.Path == "" {
+			return errors.New("-rewrite-imports requires a source with a module path")
 		}
-
-		if !found {
-			fmt.Fprintf(os.Stderr, "(require) add new: %s (%s)\n", srcR.Mod.String(), indirectStr(srcR.Indirect))
-			dest.AddNewRequire(srcR.Mod.Path, srcR.Mod.Version, srcR.Indirect)
+		if goFiles, err = rewrittenImports(filepath.Dir(destFile), src.Module.Mod.Path, opts.rewriteImports); err != nil {
+			return err
+		}
+	}
+	if len(opts.validate) > 0 {
+		if err := validateModule(ctx, destFile, out, goFiles, opts.validate, opts.testArgs); err != nil {
+			return err
 		}
 	}
 
-	return nil
-}
+	var fragment []byte
+	if opts.changelog != "" && len(changes) > 0 {
+		if fragment, err = renderChangelog(opts.changelogTemplate, dest.Module.Mod.Path, destFile, opts.srcFile, changes); err != nil {
+			return err
+		}
+	}
 
-// mergeReplacements merges "replace" statements into the destination.
-//
-// Mutation rules:
-// - Module paths missing from the destination entirely will be added.
-// - Replacements for the source module in the destination will be removed.
-//
-// This function will error if matching module paths are found in both the
-// source and destination, but the versions mismatch. This is considered a
-// condition that will need human intervention.
-func mergeReplacements(dest, src *modfile.File) error {
-	var dropVersions []module.Version
-	for _, r := range dest.Replace {
-		if r.Old.Path == src.Module.Mod.Path {
-			dropVersions = append(dropVersions, r.Old)
-		}
-	}
-	for _, v := range dropVersions {
-		fmt.Fprintf(os.Stderr, "drop replacement: %s\n", v.String())
-		dest.DropReplace(v.Path, v.Version)
-	}
-
-	for _, srcR := range src.Replace {
-		var found bool
-		for _, destR := range dest.Replace {
-			if srcR.Old.String() == destR.Old.String() {
-				fmt.Fprintf(os.Stderr, "(replace) match: %s\n", srcR.Old)
-				found = true
-				break
+	// Everything written to the working tree is applied as one transaction.
+	var tx transaction
+	if opts.bzlMacroFile != "" {
+		macro, err := bazelMacroFile(opts.bzlMacroName, dest, destFile, opts.srcFile)
+		if err != nil {
+			return err
+		}
+		tx.stage(opts.bzlMacroFile, macro)
+	}
+	switch {
+	case opts.emit == emitEdits:
+		if err := writeEdits(opts.stdout(), content, encoded); err != nil {
+			return err
+		}
+	case opts.emit == emitGoModEdit:
+		if err := writeGoModEdit(opts.stdout(), destFile, before, changes); err != nil {
+			return err
+		}
+	case opts.emit == emitGoGet:
+		if err := writeGoGet(opts.stdout(), destFile, changes); err != nil {
+			return err
+		}
+	case opts.emit == emitPatch:
+		files, err := mergePatch(ctx, destFile, content, encoded, opts.net.offline)
+		if err != nil {
+			return err
+		}
+		if err := writePatch(opts.stdout(), files); err != nil {
+			return err
+		}
+	case opts.emit == emitCommitMsg && !opts.write:
+		// The message is written once the merge is through.
+	case destFile == stdinDest || !opts.write:
+		// The merged file goes to stdout exactly as it would be written,
+		// line endings included, so the output can be piped straight into
+		// other tools.
+		if _, err := opts.stdout().Write(encoded); err != nil {
+			return err
+		}
+	default:
+		tx.stage(destFile, encoded)
+		for _, f := range goFiles {
+			tx.stage(f.path, f.content)
+		}
+		if opts.vendor {
+			dir := filepath.Dir(destFile)
+			tx.stageStep(filepath.Join(dir, "vendor"), func(ctx context.Context) (err error) {
+				result.Vendor, err = vendorModule(ctx, dir)
+				return err
+			})
+		}
+		workFile, err := destWorkspace(ctx, destFile)
+		if err != nil {
+			return err
+		}
+		if workFile != "" {
+			tx.stageStep(workFile+".sum", func(ctx context.Context) error {
+				return syncWorkSum(ctx, workFile, opts.net.offline)
+			})
+		}
+		if fragment != nil && opts.changelog != "-" {
+			tx.stage(changelogFile(opts.changelog, destFile), fragment)
+		}
+	}
+	stopWrite := opts.net.metrics.time(phaseWrite)
+	err = tx.commit(ctx)
+	stopWrite()
+	if err != nil {
+		return err
+	}
+	if opts.emit == emitCommitMsg {
+		if len(changes) == 0 {
+			fmt.Fprintln(os.Stderr, "(commit-msg) no changes to describe")
+		} else {
+			var srcModule string
+			if src.Module != nil {
+				srcModule = src.Module.Mod.Path
 			}
-			if srcR.Old.Path == destR.Old.Path && srcR.Old.Version == destR.Old.Version {
-				if srcR.New.Path != destR.New.Path || srcR.New.Version != destR.New.Version {
-					return errors.New("(replace) source and destination old path/version match, but new path/version do not")
-				}
+			if err := writeCommitMsg(opts.stdout(), destFile, dest.Module.Mod.Path, opts.srcFile, srcModule, changes); err != nil {
+				return err
 			}
 		}
-
-		if !found {
-			fmt.Fprintf(os.Stderr, "(replace) add new: %s -> %s\n", srcR.Old, srcR.New)
-			dest.AddReplace(srcR.Old.Path, srcR.Old.Version, srcR.New.Path, srcR.New.Version)
+	}
+	if fragment != nil && opts.changelog == "-" {
+		if _, err := opts.stdout().Write(fragment); err != nil {
+			return err
 		}
 	}
+	result.Written = opts.write
+	if err := runHook(ctx, opts.cfg.Hooks.PostMerge, newReport("post-merge", changes)); err != nil {
+		return err
+	}
+	return writeSummary(os.Stderr, destFile, changes, mergeReport, string(beforeOut), string(out))
+}
 
-	return nil
+// writeFile replaces the content of an existing file, keeping its permissions.
+func writeFile(path string, content []byte) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, content, info.Mode().Perm())
 }
 
-// mergeExcludes merges "exclude" statements into the destination. Only
-// exclusions missing from the destination will be added.
-func mergeExcludes(dest, src *modfile.File) error {
-	for _, srcE := range src.Exclude {
-		var found bool
-		for _, destE := range dest.Exclude {
-			if srcE.Mod.String() == destE.Mod.String() {
-				fmt.Fprintf(os.Stderr, "(replace) match: %s\n", srcE.Mod)
-				found = true
-				break
-			}
-		}
+func parseModFile(path string) (*modfile.File, error) {
+	f, _, err := readModFile(path)
+	return f, err
+}
 
-		if !found {
-			fmt.Fprintf(os.Stderr, "(exclude) add new: %s -> %s\n", srcE.Mod)
-			dest.AddExclude(srcE.Mod.Path, srcE.Mod.Version)
-		}
+// readModFile parses the go.mod file at path, also returning its encoding.
+func readModFile(path string) (*modfile.File, fileEncoding, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fileEncoding{}, err
 	}
+	enc, content := detectEncoding(content)
+	f, err := modfile.Parse(path, content, nil)
+	return f, enc, err
+}
 
-	return nil
+// replacedModule returns the module that path@version is replaced with in f,
+// or the zero Version if it isn't replaced. Version-specific replacements take
+// precedence over path-wide ones.
+func replacedModule(f *modfile.File, path, version string) module.Version {
+	var replacement module.Version
+	for _, r := range f.Replace {
+		if r.Old.Path != path {
+			continue
+		}
+		if r.Old.Version == version {
+			return r.New
+		}
+		if r.Old.Version == "" {
+			replacement = r.New
+		}
+	}
+	return replacement
 }
 
 func indirectStr(indirect bool) string {
@@ -214,11 +1038,3 @@ func indirectStr(indirect bool) string {
 	}
 	return "direct"
 }
-
-func canCompare(a, b *semver.Version) bool {
-	return (isZero(a) && isZero(b)) || (!isZero(a) && !isZero(b))
-}
-
-func isZero(v *semver.Version) bool {
-	return v.Major() == 0 && v.Minor() == 0 && v.Patch() == 0
-}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// manifest describes the outcome of a run, for consumers to verify where a
+// go.mod change came from. Rewrites are the module paths of the source changed
+// by -rewrite, and Metrics the performance of the run.
+type manifest struct {
+	Src         string          `json:"src"`
+	Rewrites    []rewrite       `json:"rewrites,omitempty"`
+	Transplants []manifestEntry `json:"transplants"`
+	Metrics     *metricsReport  `json:"metrics,omitempty"`
+}
+
+// manifestEntry describes the merge into one destination.
+type manifestEntry struct {
+	Dest    string   `json:"dest"`
+	Module  string   `json:"module"`
+	Digests digests  `json:"digests"`
+	Written bool     `json:"written"`
+	Changes []change `json:"changes"`
+	// Health are the deps.dev signals of the added modules, with -depsdev.
+	Health []moduleHealth `json:"health,omitempty"`
+	// Upstream are the states of the GitHub repositories of the added
+	// modules, with -check-upstream.
+	Upstream []upstreamStatus `json:"upstream,omitempty"`
+	// Vendor is how much the vendor directory changed, with -vendor.
+	Vendor *vendorChurn `json:"vendor,omitempty"`
+}
+
+// signOptions select how a manifest is signed with cosign: with the key
+// reference in key, or keyless (through an OIDC identity) if it is empty.
+type signOptions struct {
+	sign bool
+	key  string
+}
+
+// bundleFile returns the file the Sigstore bundle of a manifest is kept in.
+func bundleFile(manifestFile string) string {
+	return manifestFile + ".sigstore.json"
+}
+
+// writeManifest writes a manifest of the results of merging the source,
+// rewritten as listed in rewrites, with the metrics of the run to path and, if
+// requested, signs it with cosign.
+func writeManifest(ctx context.Context, path, srcFile string, rewrites []rewrite, results []fileResult, metrics *runMetrics, sign signOptions) error {
+	m := manifest{Src: srcFile, Rewrites: rewrites, Transplants: []manifestEntry{}, Metrics: metrics.report(results)}
+	for _, r := range results {
+		changes := r.Changes
+		if changes == nil {
+			changes = []change{}
+		}
+		m.Transplants = append(m.Transplants, manifestEntry{
+			Dest:     r.Dest,
+			Module:   r.Module,
+			Digests:  r.Digests,
+			Written:  r.Written,
+			Changes:  changes,
+			Health:   r.Health,
+			Upstream: r.Upstream,
+			Vendor:   r.Vendor,
+		})
+	}
+	out, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(path, append(out, '\n'), 0o644); err != nil {
+		return err
+	}
+	if !sign.sign {
+		return nil
+	}
+	args := []string{"sign-blob", "--yes", "--bundle", bundleFile(path)}
+	if sign.key != "" {
+		args = append(args, "--key", sign.key)
+	}
+	fmt.Fprintf(os.Stderr, "(manifest) sign: %s\n", path)
+	return cosign(ctx, append(args, path)...)
+}
+
+// cosign runs the cosign command, which must be on PATH.
+func cosign(ctx context.Context, args ...string) error {
+	cmd := exec.CommandContext(ctx, "cosign", args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return fmt.Errorf("cosign %s: %s", args[0], strings.TrimSpace(stderr.String()))
+		}
+		return fmt.Errorf("cosign: %w", err)
+	}
+	return nil
+}
+
+// runVerifyManifest verifies the signature of a manifest with cosign and, if
+// go.mod files are given, that they are what the manifest says was produced.
+func runVerifyManifest(ctx context.Context, args []string) error {
+	var (
+		manifestFile string
+		bundle       string
+		key          string
+		identity     string
+		issuer       string
+	)
+	fs := flag.NewFlagSet("modtransplant verify-manifest", flag.ExitOnError)
+	fs.StringVar(&manifestFile, "manifest", "", "manifest file written with -manifest")
+	fs.StringVar(&bundle, "bundle", "", "Sigstore bundle of the manifest (defaults to <manifest>.sigstore.json)")
+	fs.StringVar(&key, "key", "", "public key the manifest was signed with (key-based signing)")
+	fs.StringVar(&identity, "certificate-identity", "", "identity of the signer (keyless signing)")
+	fs.StringVar(&issuer, "certificate-oidc-issuer", "", "OIDC issuer of the signer's identity (keyless signing)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if manifestFile == "" {
+		return errors.New(usage)
+	}
+	if (key == "") == (identity == "" || issuer == "") {
+		return errors.New("either -key, or -certificate-identity and -certificate-oidc-issuer are required")
+	}
+	if bundle == "" {
+		bundle = bundleFile(manifestFile)
+	}
+
+	cosignArgs := []string{"verify-blob", "--bundle", bundle}
+	if key != "" {
+		cosignArgs = append(cosignArgs, "--key", key)
+	} else {
+		cosignArgs = append(cosignArgs, "--certificate-identity", identity, "--certificate-oidc-issuer", issuer)
+	}
+	if err := cosign(ctx, append(cosignArgs, manifestFile)...); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "(manifest) signature verified: %s\n", manifestFile)
+
+	content, err := ioutil.ReadFile(manifestFile)
+	if err != nil {
+		return err
+	}
+	var m manifest
+	if err := json.Unmarshal(content, &m); err != nil {
+		return fmt.Errorf("%s: %w", manifestFile, err)
+	}
+	byDest := map[string]manifestEntry{}
+	for _, t := range m.Transplants {
+		byDest[t.Dest] = t
+	}
+	var mismatches []string
+	for _, destFile := range fs.Args() {
+		t, ok := byDest[destFile]
+		if !ok {
+			mismatches = append(mismatches, destFile+": not in the manifest")
+			continue
+		}
+		d, err := fileDigest(destFile)
+		if err != nil {
+			return err
+		}
+		if d != t.Digests.Output {
+			mismatches = append(mismatches, fmt.Sprintf("%s: digest %s, manifest says %s", destFile, d, t.Digests.Output))
+			continue
+		}
+		fmt.Fprintf(os.Stderr, "(manifest) verified: %s\n", destFile)
+	}
+	return gateError("%d file(s) don't match the manifest:", mismatches)
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"golang.org/x/mod/modfile"
+)
+
+// mappingGroup is a destination of a mapping with the go.mod files of the
+// source modules merged into it.
+type mappingGroup struct {
+	dest string
+	srcs []string
+}
+
+// mappingModFile returns the go.mod file named by rel, a module directory or
+// go.mod file relative to base.
+func mappingModFile(base, rel string) string {
+	path := filepath.Join(base, filepath.FromSlash(rel))
+	if filepath.Base(path) != "go.mod" {
+		path = filepath.Join(path, "go.mod")
+	}
+	return path
+}
+
+// checkMappingFlags checks that the flags of a run fit the mapping of its
+// config file.
+func checkMappingFlags(srcFile, destFile string, batch bool, opts *mergeOptions) error {
+	for _, dir := range []string{srcFile, destFile} {
+		if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+			return errors.New("a mapping requires -src and -dest to be directories")
+		}
+	}
+	switch {
+	case !opts.write:
+		return errors.New("a mapping requires -w")
+	case batch:
+		return errors.New("a mapping can't be combined with -recursive or -dest patterns")
+	case opts.bzlMacroFile != "" || opts.setModule != "":
+		return errors.New("a mapping can't be combined with -bzl-macro or -set-module")
+	case opts.emit == emitCommitMsg:
+		return errors.New("a mapping can't be combined with -emit=commit-msg")
+	}
+	return nil
+}
+
+// mergeMapping merges the modules of the source directory srcDir into the
+// destinations below destDir they are paired with by mapping. Modules sharing
+// a destination are combined as for a source directory. All merges are
+// planned before anything is written, and cross-checked: a module the merges
+// change must end up at the same version in every destination requiring it.
+// Modules of the source directory the mapping leaves out are reported.
+func mergeMapping(ctx context.Context, configFile, srcDir, destDir string, netOpts *netOptions, srcOpts sourceOptions, opts *mergeOptions, mapping []mappingEntry) error {
+	var groups []*mappingGroup
+	byDest := map[string]*mappingGroup{}
+	mapped := map[string]string{}
+	for _, m := range mapping {
+		src, dest := mappingModFile(srcDir, m.Src), mappingModFile(destDir, m.Dest)
+		if prev, ok := mapped[src]; ok {
+			return fmt.Errorf("mapping: %s is mapped to both %s and %s", src, prev, dest)
+		}
+		mapped[src] = dest
+		g := byDest[dest]
+		if g == nil {
+			g = &mappingGroup{dest: dest}
+			byDest[dest] = g
+			groups = append(groups, g)
+		}
+		g.srcs = append(g.srcs, src)
+	}
+	modFiles, err := findModFiles(srcDir)
+	if err != nil {
+		return err
+	}
+	for _, f := range modFiles {
+		if _, ok := mapped[filepath.Clean(f)]; !ok {
+			fmt.Fprintf(os.Stderr, "(mapping) unmapped: %s\n", f)
+		}
+	}
+
+	type plan struct {
+		group  *mappingGroup
+		src    *modfile.File
+		opts   mergeOptions
+		result fileResult
+	}
+	var plans []plan
+	for _, g := range groups {
+		fmt.Fprintf(os.Stderr, "(mapping) plan: %s -> %s\n", strings.Join(g.srcs, ", "), g.dest)
+		gopts := *opts
+		gsrcOpts := srcOpts
+		gsrcOpts.modules = g.srcs
+		src, err := gopts.prepare(ctx, configFile, srcDir, netOpts, gsrcOpts)
+		if err != nil {
+			return fmt.Errorf("%s: %w", g.dest, err)
+		}
+		// The plan neither writes nor records anything, and leaves hooks,
+		// validation and vendoring to the actual merge.
+		popts := gopts
+		cfg := *gopts.cfg
+		cfg.Hooks.PreMerge, cfg.Hooks.PostMerge, cfg.Hooks.OnConflict = "", "", ""
+		popts.cfg = &cfg
+		popts.write = false
+		popts.vendor = false
+		popts.validate = nil
+		popts.bzlMacroFile = ""
+		popts.noHistory = true
+		popts.confirm = false
+		popts.output = ioutil.Discard
+		popts.results = new([]fileResult)
+		popts.debugTraces = nil
+		if err := mergeInto(ctx, g.dest, src, &popts); err != nil {
+			return fmt.Errorf("%s: %w", g.dest, err)
+		}
+		plans = append(plans, plan{g, src, gopts, (*popts.results)[0]})
+	}
+
+	results := make([]fileResult, len(plans))
+	for i, p := range plans {
+		results[i] = p.result
+	}
+	if err := checkMappingConsistency(results); err != nil {
+		return err
+	}
+
+	for _, p := range plans {
+		fmt.Fprintf(os.Stderr, "(mapping) merge: %s\n", p.group.dest)
+		if err := mergeInto(ctx, p.group.dest, p.src, &p.opts); err != nil {
+			return fmt.Errorf("%s: %w", p.group.dest, err)
+		}
+		opts.rewrites = append(opts.rewrites, p.opts.rewrites...)
+	}
+	fmt.Fprintf(os.Stderr, "(mapping) merged %d destinations\n", len(plans))
+	return nil
+}
+
+// checkMappingConsistency fails if a module added or updated in one of the
+// results is required at different versions by the merged destinations.
+func checkMappingConsistency(results []fileResult) error {
+	changed := map[string]bool{}
+	versions := map[string]map[string][]string{}
+	for _, r := range results {
+		for _, c := range r.Changes {
+			if c.Kind == "require" && c.Action != actionRemove {
+				changed[c.Path] = true
+			}
+		}
+		f, err := modfile.ParseLax(r.Dest, []byte(r.After), nil)
+		if err != nil {
+			return err
+		}
+		for _, req := range f.Require {
+			if versions[req.Mod.Path] == nil {
+				versions[req.Mod.Path] = map[string][]string{}
+			}
+			versions[req.Mod.Path][req.Mod.Version] = append(versions[req.Mod.Path][req.Mod.Version], r.Dest)
+		}
+	}
+
+	var paths []string
+	for path := range changed {
+		if len(versions[path]) > 1 {
+			paths = append(paths, path)
+		}
+	}
+	if len(paths) == 0 {
+		return nil
+	}
+	sort.Strings(paths)
+	var b strings.Builder
+	b.WriteString("mapping: destinations would require different versions:")
+	for _, path := range paths {
+		var vs []string
+		for v, dests := range versions[path] {
+			vs = append(vs, fmt.Sprintf("%s (%s)", v, strings.Join(dests, ", ")))
+		}
+		sort.Strings(vs)
+		fmt.Fprintf(&b, "\n\t%s: %s", path, strings.Join(vs, ", "))
+	}
+	return errors.New(b.String())
+}
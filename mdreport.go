@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// stepSummaryEnv names the file GitHub Actions renders as the summary of a
+// job step.
+const stepSummaryEnv = "GITHUB_STEP_SUMMARY"
+
+// appendMarkdownReport appends a Markdown report of the results to the file
+// at path, creating it if needed.
+func appendMarkdownReport(path, srcFile string, results []fileResult) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return err
+	}
+	if err := writeMarkdownReport(f, srcFile, results); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// writeMarkdownReport renders the results as GitHub-flavored Markdown: a
+// section per destination with a table of its changes and a collapsed diff of
+// its go.mod.
+func writeMarkdownReport(w io.Writer, srcFile string, results []fileResult) error {
+	var failed int
+	for _, r := range results {
+		if r.Err != nil {
+			failed++
+		}
+	}
+	fmt.Fprintf(w, "## modtransplant\n\nSource: `%s`. %d destination%s", srcFile, len(results), plural(len(results)))
+	if failed > 0 {
+		fmt.Fprintf(w, ", %d failed", failed)
+	}
+	fmt.Fprint(w, ".\n\n")
+
+	for _, r := range results {
+		fmt.Fprintf(w, "### `%s`", r.Dest)
+		if r.Module != "" {
+			fmt.Fprintf(w, " (`%s`)", r.Module)
+		}
+		switch {
+		case r.Err != nil:
+			fmt.Fprintf(w, ": failed\n\n```\n%s\n```\n\n", r.Err)
+			continue
+		case len(r.Changes) == 0:
+			fmt.Fprint(w, ": no changes\n\n")
+			continue
+		case r.Written:
+			fmt.Fprintf(w, ": %d change%s written\n\n", len(r.Changes), plural(len(r.Changes)))
+		default:
+			fmt.Fprintf(w, ": %d change%s\n\n", len(r.Changes), plural(len(r.Changes)))
+		}
+
+		fmt.Fprint(w, "| Kind | Action | Path | Version | From | To | Indirect |\n|---|---|---|---|---|---|---|\n")
+		for _, c := range r.Changes {
+			fmt.Fprintf(w, "| %s | %s | %s | %s | %s | %s | %s |\n", c.Kind, c.Action, markdownCell(c.Path), markdownCell(c.Version), markdownCell(c.From), markdownCell(c.To), yesIf(c.Indirect))
+		}
+		if len(r.Health) > 0 {
+			fmt.Fprint(w, "\n| Added module | Latest release | Released | Dependents | Scorecard | Note |\n|---|---|---|---|---|---|\n")
+			for _, h := range r.Health {
+				var score string
+				if h.Scorecard != nil {
+					score = fmt.Sprintf("%.1f", *h.Scorecard)
+				}
+				fmt.Fprintf(w, "| %s | %s | %s | %d | %s | %s |\n", markdownCell(h.Path+"@"+h.Version), markdownCell(h.LatestVersion), h.LatestRelease, h.Dependents, score, h.Err)
+			}
+		}
+		if len(r.Upstream) > 0 {
+			fmt.Fprint(w, "\n| Added module | Repository | Archived | Inactive | Last push | Note |\n|---|---|---|---|---|---|\n")
+			for _, s := range r.Upstream {
+				fmt.Fprintf(w, "| %s | %s | %s | %s | %s | %s |\n", markdownCell(s.Path), markdownCell(s.Repository), yesIf(s.Archived), yesIf(s.Inactive), s.LastPush, s.Err)
+			}
+		}
+		if r.Vendor != nil {
+			fmt.Fprintf(w, "\nVendor directory: files %s; modules %s.\n", r.Vendor.Files, r.Vendor.Modules)
+		}
+		if r.After != "" {
+			fmt.Fprint(w, "\n<details><summary>Diff</summary>\n\n```diff\n")
+			for _, l := range diffLines(r.Before, r.After) {
+				fmt.Fprintf(w, "%s%s\n", l.Op, l.Text)
+			}
+			fmt.Fprint(w, "```\n\n</details>\n")
+		}
+		fmt.Fprintln(w)
+	}
+	return nil
+}
+
+// markdownCell formats s as the code span of a table cell, or leaves the cell
+// empty.
+func markdownCell(s string) string {
+	if s == "" {
+		return ""
+	}
+	return "`" + strings.ReplaceAll(s, "|", `\|`) + "`"
+}
+
+// yesIf returns the cell of a table column flagging rows: "yes", or nothing.
+func yesIf(b bool) string {
+	if b {
+		return "yes"
+	}
+	return ""
+}
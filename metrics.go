@@ -0,0 +1,106 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// Phases of a run timed by runMetrics. Network time is also counted in the
+// phase the request was made in.
+const (
+	phaseParse   = "parse"
+	phaseResolve = "resolve"
+	phaseNetwork = "network"
+	phaseWrite   = "write"
+)
+
+// runMetrics measures where the time of a run goes and how well the metadata
+// cache serves it. A nil *runMetrics records nothing. Network requests may be
+// made concurrently, so it is safe for concurrent use.
+type runMetrics struct {
+	mu        sync.Mutex
+	durations map[string]time.Duration
+	hits      int
+	misses    int
+}
+
+func newRunMetrics() *runMetrics {
+	return &runMetrics{durations: map[string]time.Duration{}}
+}
+
+// time starts timing phase, returning the function that stops it.
+func (m *runMetrics) time(phase string) func() {
+	if m == nil {
+		return func() {}
+	}
+	start := time.Now()
+	return func() {
+		m.mu.Lock()
+		m.durations[phase] += time.Since(start)
+		m.mu.Unlock()
+	}
+}
+
+// cacheLookup records whether a metadata lookup was served from the cache.
+func (m *runMetrics) cacheLookup(hit bool) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	if hit {
+		m.hits++
+	} else {
+		m.misses++
+	}
+	m.mu.Unlock()
+}
+
+// metricsReport is the form of runMetrics in the manifest. Durations are in
+// milliseconds, and Actions counts the changes of all destinations by
+// "<kind>.<action>", e.g. "require.update".
+type metricsReport struct {
+	ParseMS   float64        `json:"parse_ms"`
+	ResolveMS float64        `json:"resolve_ms"`
+	NetworkMS float64        `json:"network_ms"`
+	WriteMS   float64        `json:"write_ms"`
+	Actions   map[string]int `json:"actions"`
+	Cache     cacheReport    `json:"cache"`
+}
+
+// cacheReport counts the metadata lookups served from the cache and those
+// that went to the network. HitRate is 0 if nothing was looked up.
+type cacheReport struct {
+	Hits    int     `json:"hits"`
+	Misses  int     `json:"misses"`
+	HitRate float64 `json:"hit_rate"`
+}
+
+// report summarizes the metrics of a run with the given results.
+func (m *runMetrics) report(results []fileResult) *metricsReport {
+	if m == nil {
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	r := &metricsReport{
+		ParseMS:   milliseconds(m.durations[phaseParse]),
+		ResolveMS: milliseconds(m.durations[phaseResolve]),
+		NetworkMS: milliseconds(m.durations[phaseNetwork]),
+		WriteMS:   milliseconds(m.durations[phaseWrite]),
+		Actions:   map[string]int{},
+		Cache:     cacheReport{Hits: m.hits, Misses: m.misses},
+	}
+	if lookups := m.hits + m.misses; lookups > 0 {
+		r.Cache.HitRate = float64(m.hits) / float64(lookups)
+	}
+	for _, res := range results {
+		for _, c := range res.Changes {
+			r.Actions[c.Kind+"."+c.Action]++
+		}
+	}
+	return r
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
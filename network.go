@@ -0,0 +1,507 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/mod/module"
+)
+
+var errOffline = errors.New("network access disabled by -offline")
+
+// netOptions configures the network access of commands that fetch modules.
+type netOptions struct {
+	offline  bool
+	cacheDir string
+	cacheTTL time.Duration
+	noCache  bool
+	timeout  time.Duration
+	retries  int
+	jobs     int
+	hostRate float64
+	// resolver is the -resolver answering module queries, and resolverDir
+	// the directory its go command runs in.
+	resolver    string
+	resolverDir string
+	// noProgress turns off the progress reports of long operations.
+	noProgress bool
+	// metrics, if not nil, records the time spent on the network and the
+	// cache hits of the run.
+	metrics *runMetrics
+}
+
+// register adds the network flags to fs.
+func (o *netOptions) register(fs *flag.FlagSet) {
+	fs.BoolVar(&o.offline, "offline", false, "forbid all network access and only use cached module metadata")
+	fs.StringVar(&o.cacheDir, "cache-dir", defaultCacheDir(), "directory for cached module metadata")
+	fs.DurationVar(&o.cacheTTL, "cache-ttl", 0, "how long cached version lists and latest versions are used before they are refetched (0 to always refetch)")
+	fs.BoolVar(&o.noCache, "no-cache", false, "neither read nor write cached module metadata")
+	fs.DurationVar(&o.timeout, "timeout", time.Minute, "time limit for each remote operation to respond, and for each wait for more of a download (0 for none)")
+	fs.IntVar(&o.retries, "retries", 3, "number of times transient network failures are retried")
+	fs.IntVar(&o.jobs, "network-jobs", 8, "maximum number of concurrent network requests")
+	fs.Float64Var(&o.hostRate, "host-rate", 0, "maximum requests per second sent to any single host (0 for no limit)")
+	fs.BoolVar(&o.noProgress, "no-progress", false, "don't report the progress of long network operations on stderr")
+	fs.StringVar(&o.resolver, "resolver", resolverProxy, "what answers module version and go.mod queries: the GOPROXY chain, the go command run in the destination module, or nothing (proxy, go or none)")
+}
+
+// in returns the options with the go command of -resolver=go run in dir.
+func (o *netOptions) in(dir string) *netOptions {
+	n := *o
+	n.resolverDir = dir
+	return &n
+}
+
+func defaultCacheDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "modtransplant")
+}
+
+// newHTTPClient returns the client used for all network access.
+func newHTTPClient(opts *netOptions) *http.Client {
+	if opts.offline {
+		return &http.Client{Transport: offlineTransport{}}
+	}
+	return &http.Client{
+		Transport: &retryTransport{
+			base: &limitTransport{
+				base: &authTransport{
+					base:   http.DefaultTransport,
+					tokens: parseHostTokens(os.Getenv(tokensEnv)),
+					netrc:  readNetrc(),
+				},
+				jobs: newSemaphore(opts.jobs),
+				rate: newHostLimiter(opts.hostRate),
+			},
+			timeout: opts.timeout,
+			retries: opts.retries,
+		},
+	}
+}
+
+// httpGet performs a GET request bound to ctx.
+func httpGet(ctx context.Context, client *http.Client, rawurl string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawurl, nil)
+	if err != nil {
+		return nil, err
+	}
+	return client.Do(req)
+}
+
+// retryTransport gives each request attempt timeout to get a response, and
+// then timeout for every read of the response body, so that large downloads,
+// such as image layers, are only cut short when they stall. Attempts that fail
+// transiently (network errors, timeouts, 429 and 5xx responses) are retried
+// with exponential backoff. Requests with a body are only retried if it can be
+// replayed.
+type retryTransport struct {
+	base    http.RoundTripper
+	timeout time.Duration
+	retries int
+}
+
+const initialBackoff = 500 * time.Millisecond
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	backoff := initialBackoff
+	for attempt := 0; ; attempt++ {
+		ctx, cancel := context.WithCancel(req.Context())
+		idle := newIdleTimer(t.timeout, cancel)
+		attemptReq := req.WithContext(ctx)
+		if attempt > 0 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				idle.stop()
+				cancel()
+				return nil, err
+			}
+			attemptReq.Body = body
+		}
+		resp, err := t.base.RoundTrip(attemptReq)
+		err = idle.check(err)
+
+		replayable := req.Body == nil || req.Body == http.NoBody || req.GetBody != nil
+		retry := attempt < t.retries && replayable && req.Context().Err() == nil
+		switch {
+		case err != nil:
+			retry = retry && isTransient(err)
+		case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+			if retry {
+				if after := retryAfter(resp); after > backoff {
+					backoff = after
+				}
+				resp.Body.Close()
+			}
+		default:
+			retry = false
+		}
+		if !retry {
+			if err != nil {
+				idle.stop()
+				cancel()
+				return nil, err
+			}
+			idle.reset()
+			resp.Body = &idleBody{ReadCloser: resp.Body, idle: idle, cancel: cancel}
+			return resp, nil
+		}
+		idle.stop()
+		cancel()
+
+		fmt.Fprintf(os.Stderr, "(network) retrying %s in %s\n", req.URL.Redacted(), backoff)
+		select {
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// isTransient reports whether a request error is worth retrying. Anything but
+// an invalid request or cancellation by the caller qualifies.
+func isTransient(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, errOffline) {
+		return false
+	}
+	var netErr net.Error
+	return errors.Is(err, context.DeadlineExceeded) || errors.As(err, &netErr) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// retryAfter returns the delay requested by a Retry-After header given in
+// seconds.
+func retryAfter(resp *http.Response) time.Duration {
+	secs, err := strconv.Atoi(resp.Header.Get("Retry-After"))
+	if err != nil || secs < 0 {
+		return 0
+	}
+	return time.Duration(secs) * time.Second
+}
+
+// idleTimer cancels a request attempt once timeout passes without progress. A
+// nil idleTimer never does.
+type idleTimer struct {
+	timeout time.Duration
+	timer   *time.Timer
+	expired atomic.Bool
+}
+
+func newIdleTimer(timeout time.Duration, cancel context.CancelFunc) *idleTimer {
+	if timeout <= 0 {
+		return nil
+	}
+	t := &idleTimer{timeout: timeout}
+	t.timer = time.AfterFunc(timeout, func() {
+		t.expired.Store(true)
+		cancel()
+	})
+	return t
+}
+
+// reset gives the attempt another timeout from now.
+func (t *idleTimer) reset() {
+	if t != nil && !t.expired.Load() {
+		t.timer.Reset(t.timeout)
+	}
+}
+
+func (t *idleTimer) stop() {
+	if t != nil {
+		t.timer.Stop()
+	}
+}
+
+// check turns the error of an attempt the timer cancelled into a timeout,
+// which is retried, rather than a cancellation.
+func (t *idleTimer) check(err error) error {
+	if err == nil || t == nil || !t.expired.Load() {
+		return err
+	}
+	return fmt.Errorf("no progress for %s: %w", t.timeout, context.DeadlineExceeded)
+}
+
+// idleBody restarts the idle timer of its request on every read, and releases
+// the request once the body is closed.
+type idleBody struct {
+	io.ReadCloser
+	idle   *idleTimer
+	cancel context.CancelFunc
+}
+
+func (b *idleBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.idle.reset()
+	return n, b.idle.check(err)
+}
+
+func (b *idleBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.idle.stop()
+	b.cancel()
+	return err
+}
+
+// limitTransport bounds the number of requests in flight, counting each until
+// its response body is closed, and spaces out the requests sent to each host.
+type limitTransport struct {
+	base http.RoundTripper
+	jobs semaphore
+	rate *hostLimiter
+}
+
+func (t *limitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := t.rate.wait(req.Context(), req.URL.Host); err != nil {
+		return nil, err
+	}
+	if err := t.jobs.acquire(req.Context()); err != nil {
+		return nil, err
+	}
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		t.jobs.release()
+		return nil, err
+	}
+	var once sync.Once
+	resp.Body = &releaseBody{ReadCloser: resp.Body, release: func() { once.Do(t.jobs.release) }}
+	return resp, nil
+}
+
+// releaseBody runs release once the body is closed.
+type releaseBody struct {
+	io.ReadCloser
+	release func()
+}
+
+func (b *releaseBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.release()
+	return err
+}
+
+// semaphore bounds concurrency; a nil semaphore doesn't.
+type semaphore chan struct{}
+
+func newSemaphore(n int) semaphore {
+	if n <= 0 {
+		return nil
+	}
+	return make(semaphore, n)
+}
+
+func (s semaphore) acquire(ctx context.Context) error {
+	if s == nil {
+		return nil
+	}
+	select {
+	case s <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s semaphore) release() {
+	if s != nil {
+		<-s
+	}
+}
+
+// runJobs runs f for every index below n concurrently, at most jobs at a time
+// (no limit if jobs isn't positive), so that the lookups made for each module
+// stay within -network-jobs. Once ctx is done, the jobs left run without
+// waiting, failing right away.
+func runJobs(ctx context.Context, jobs, n int, f func(i int)) {
+	sem := newSemaphore(jobs)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		if sem.acquire(ctx) != nil {
+			f(i)
+			continue
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			defer sem.release()
+			f(i)
+		}(i)
+	}
+	wg.Wait()
+}
+
+// hostLimiter spaces out the requests to each host so that no host receives
+// more than a fixed number of requests per second. A nil hostLimiter doesn't
+// limit anything.
+type hostLimiter struct {
+	interval time.Duration
+
+	mu   sync.Mutex
+	next map[string]time.Time
+}
+
+func newHostLimiter(perSecond float64) *hostLimiter {
+	if perSecond <= 0 {
+		return nil
+	}
+	return &hostLimiter{
+		interval: time.Duration(float64(time.Second) / perSecond),
+		next:     map[string]time.Time{},
+	}
+}
+
+// wait blocks until the next request to host may be sent.
+func (l *hostLimiter) wait(ctx context.Context, host string) error {
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	now := time.Now()
+	slot := l.next[host]
+	if slot.Before(now) {
+		slot = now
+	}
+	l.next[host] = slot.Add(l.interval)
+	l.mu.Unlock()
+
+	timer := time.NewTimer(slot.Sub(now))
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// offlineTransport fails every request.
+type offlineTransport struct{}
+
+func (offlineTransport) RoundTrip(*http.Request) (*http.Response, error) {
+	return nil, errOffline
+}
+
+// goModCache returns the go command's module cache directory.
+func goModCache() string {
+	if dir := goEnv("GOMODCACHE"); dir != "" {
+		return dir
+	}
+	gopath := goEnv("GOPATH")
+	if gopath == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		gopath = filepath.Join(home, "go")
+	}
+	return filepath.Join(filepath.SplitList(gopath)[0], "pkg", "mod")
+}
+
+// metadataCache stores proxy responses on disk using the proxy's own file
+// layout. Lookups fall back to the go command's download cache, which uses the
+// same layout, so anything the go command has already fetched is available
+// offline too. Files that can change are only used while younger than ttl.
+type metadataCache struct {
+	dir      string
+	ttl      time.Duration
+	disabled bool
+}
+
+// isImmutable reports whether a proxy file for a module never changes once
+// published, which holds for everything except version listings.
+func isImmutable(rel string) bool {
+	return rel != "@latest" && rel != "@v/list"
+}
+
+func (c metadataCache) dirs() []string {
+	var dirs []string
+	if c.dir != "" {
+		dirs = append(dirs, filepath.Join(c.dir, "download"))
+	}
+	if modCache := goModCache(); modCache != "" {
+		dirs = append(dirs, filepath.Join(modCache, "cache", "download"))
+	}
+	return dirs
+}
+
+// get returns the cached proxy file rel of module path. fresh reports
+// whether it can be used without asking the proxy again: immutable files
+// always can, others while they are younger than the TTL.
+func (c metadataCache) get(path, rel string) (data []byte, fresh, ok bool) {
+	if c.disabled {
+		return nil, false, false
+	}
+	escaped, err := module.EscapePath(path)
+	if err != nil {
+		return nil, false, false
+	}
+	for _, dir := range c.dirs() {
+		file := filepath.Join(dir, escaped, filepath.FromSlash(rel))
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			continue
+		}
+		fresh := isImmutable(rel)
+		if info, err := os.Stat(file); err == nil && !fresh {
+			fresh = time.Since(info.ModTime()) < c.ttl
+		}
+		return data, fresh, true
+	}
+	return nil, false, false
+}
+
+// put stores the proxy file rel of module path. Failures only cost a refetch,
+// so they are reported but not returned.
+func (c metadataCache) put(path, rel string, data []byte) {
+	if c.dir == "" || c.disabled {
+		return
+	}
+	escaped, err := module.EscapePath(path)
+	if err != nil {
+		return
+	}
+	if err := writeCacheFile(filepath.Join(c.dir, "download", escaped, filepath.FromSlash(rel)), data); err != nil {
+		fmt.Fprintf(os.Stderr, "(cache) write failed: %v\n", err)
+	}
+}
+
+// writeCacheFile writes data to path atomically, so concurrent runs never see
+// partial files.
+func writeCacheFile(path string, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// sumDBCacheFile maps a sumdb client cache or config file name to a path in
+// dir.
+func sumDBCacheFile(dir, file string) string {
+	return filepath.Join(dir, "sumdb", filepath.FromSlash(strings.TrimPrefix(file, "/")))
+}
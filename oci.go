@@ -0,0 +1,313 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"debug/buildinfo"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"strings"
+
+	"golang.org/x/mod/modfile"
+	"golang.org/x/mod/semver"
+)
+
+// maxImageBinarySize bounds the size of files read from image layers when
+// looking for Go binaries.
+const maxImageBinarySize = 1 << 30
+
+// loadImageSource pulls the container image named by ref, finds the Go binaries
+// in its layers and unions their dependency sets into a single module file.
+// The first binary found provides the module path.
+//
+// This is experimental: registry credentials only come from .netrc and
+// MODTRANSPLANT_AUTH_TOKENS, and whiteouts in later layers are not taken into
+// account.
+func loadImageSource(ctx context.Context, ref string, opts *netOptions) (*modfile.File, error) {
+	r, err := parseImageRef(ref)
+	if err != nil {
+		return nil, err
+	}
+	c := &registryClient{client: newHTTPClient(opts), ref: r}
+
+	manifest, err := c.manifest(ctx, r.reference)
+	if err != nil {
+		return nil, err
+	}
+
+	var infos []*debug.BuildInfo
+	for _, layer := range manifest.Layers {
+		found, err := c.layerBuildInfo(ctx, layer.MediaType, layer.Digest)
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, found...)
+	}
+	if len(infos) == 0 {
+		return nil, fmt.Errorf("oci://%s: no Go binaries built from modules found in image", ref)
+	}
+	return buildInfoModFile(unionBuildInfo(infos))
+}
+
+// unionBuildInfo combines the dependency sets of several binaries, keeping the
+// highest version of each module.
+func unionBuildInfo(infos []*debug.BuildInfo) *debug.BuildInfo {
+	union := &debug.BuildInfo{Main: infos[0].Main, GoVersion: infos[0].GoVersion}
+	deps := map[string]*debug.Module{}
+	for _, bi := range infos {
+		if semver.Compare("v"+strings.TrimPrefix(bi.GoVersion, "go"), "v"+strings.TrimPrefix(union.GoVersion, "go")) > 0 {
+			union.GoVersion = bi.GoVersion
+		}
+		for _, dep := range bi.Deps {
+			if have, ok := deps[dep.Path]; !ok || semver.Compare(dep.Version, have.Version) > 0 {
+				deps[dep.Path] = dep
+			}
+		}
+	}
+	for _, dep := range deps {
+		union.Deps = append(union.Deps, dep)
+	}
+	sort.Slice(union.Deps, func(i, j int) bool { return union.Deps[i].Path < union.Deps[j].Path })
+	return union
+}
+
+// imageRef is a parsed container image reference.
+type imageRef struct {
+	registry   string
+	repository string
+	reference  string
+}
+
+// parseImageRef parses an image reference the way docker does, defaulting to
+// Docker Hub and the "latest" tag.
+func parseImageRef(s string) (imageRef, error) {
+	r := imageRef{registry: "registry-1.docker.io", reference: "latest"}
+	name := s
+	if i := strings.Index(name, "@"); i >= 0 {
+		name, r.reference = name[:i], name[i+1:]
+	} else if i := strings.LastIndex(name, ":"); i > strings.LastIndex(name, "/") {
+		name, r.reference = name[:i], name[i+1:]
+	}
+	if i := strings.Index(name, "/"); i >= 0 {
+		host := name[:i]
+		if strings.ContainsAny(host, ".:") || host == "localhost" {
+			r.registry, name = host, name[i+1:]
+		}
+	}
+	if r.registry == "docker.io" {
+		r.registry = "registry-1.docker.io"
+	}
+	if r.registry == "registry-1.docker.io" && !strings.Contains(name, "/") {
+		name = "library/" + name
+	}
+	if name == "" || r.reference == "" {
+		return imageRef{}, fmt.Errorf("invalid image reference: %s", s)
+	}
+	r.repository = name
+	return r, nil
+}
+
+// registryClient talks to an OCI distribution (Docker registry v2) API.
+type registryClient struct {
+	client *http.Client
+	ref    imageRef
+	token  string
+}
+
+// imageManifest covers both image indexes (manifest lists) and image
+// manifests.
+type imageManifest struct {
+	Manifests []struct {
+		Digest   string `json:"digest"`
+		Platform struct {
+			OS           string `json:"os"`
+			Architecture string `json:"architecture"`
+		} `json:"platform"`
+	} `json:"manifests"`
+	Layers []struct {
+		MediaType string `json:"mediaType"`
+		Digest    string `json:"digest"`
+	} `json:"layers"`
+}
+
+var manifestMediaTypes = []string{
+	"application/vnd.oci.image.index.v1+json",
+	"application/vnd.oci.image.manifest.v1+json",
+	"application/vnd.docker.distribution.manifest.list.v2+json",
+	"application/vnd.docker.distribution.manifest.v2+json",
+}
+
+// manifest fetches the image manifest for reference, resolving an image index
+// to the linux manifest for the current architecture.
+func (c *registryClient) manifest(ctx context.Context, reference string) (*imageManifest, error) {
+	resp, err := c.get(ctx, "manifests/"+reference, strings.Join(manifestMediaTypes, ","))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var m imageManifest
+	if err := json.NewDecoder(resp.Body).Decode(&m); err != nil {
+		return nil, err
+	}
+	if len(m.Manifests) == 0 {
+		return &m, nil
+	}
+
+	digest := m.Manifests[0].Digest
+	for _, desc := range m.Manifests {
+		if desc.Platform.OS == "linux" && desc.Platform.Architecture == runtime.GOARCH {
+			digest = desc.Digest
+			break
+		}
+	}
+	return c.manifest(ctx, digest)
+}
+
+// layerBuildInfo scans a layer for executables with embedded Go build
+// information.
+func (c *registryClient) layerBuildInfo(ctx context.Context, mediaType, digest string) ([]*debug.BuildInfo, error) {
+	if strings.Contains(mediaType, "zstd") {
+		fmt.Fprintf(os.Stderr, "(oci) skip unsupported layer: %s (%s)\n", digest, mediaType)
+		return nil, nil
+	}
+	resp, err := c.get(ctx, "blobs/"+digest, "")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var r io.Reader = resp.Body
+	if strings.Contains(mediaType, "gzip") {
+		zr, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, fmt.Errorf("layer %s: %w", digest, err)
+		}
+		r = zr
+	}
+
+	var infos []*debug.BuildInfo
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("layer %s: %w", digest, err)
+		}
+		if hdr.Typeflag != tar.TypeReg || hdr.Mode&0111 == 0 || hdr.Size < 4 || hdr.Size > maxImageBinarySize {
+			continue
+		}
+		magic := make([]byte, 4)
+		if _, err := io.ReadFull(tr, magic); err != nil {
+			return nil, err
+		}
+		if !isExecutable(magic) {
+			continue
+		}
+		rest, err := ioutil.ReadAll(tr)
+		if err != nil {
+			return nil, err
+		}
+		bi, err := buildinfo.Read(bytes.NewReader(append(magic, rest...)))
+		if err != nil || bi.Main.Path == "" {
+			continue
+		}
+		fmt.Fprintf(os.Stderr, "(oci) found binary: /%s (%s)\n", hdr.Name, bi.Main.Path)
+		infos = append(infos, bi)
+	}
+	return infos, nil
+}
+
+// get performs a GET request against the repository's API, authenticating
+// with a bearer token when the registry asks for one.
+func (c *registryClient) get(ctx context.Context, path, accept string) (*http.Response, error) {
+	scheme := "https"
+	if strings.HasPrefix(c.ref.registry, "localhost") || strings.HasPrefix(c.ref.registry, "127.0.0.1") {
+		scheme = "http"
+	}
+	u := fmt.Sprintf("%s://%s/v2/%s/%s", scheme, c.ref.registry, c.ref.repository, path)
+
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+		if err != nil {
+			return nil, err
+		}
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		if c.token != "" {
+			req.Header.Set("Authorization", "Bearer "+c.token)
+		}
+		resp, err := c.client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode == http.StatusUnauthorized && attempt == 0 {
+			challenge := resp.Header.Get("Www-Authenticate")
+			resp.Body.Close()
+			if c.token, err = c.fetchToken(ctx, challenge); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, fmt.Errorf("GET %s: %s", u, resp.Status)
+		}
+		return resp, nil
+	}
+}
+
+// fetchToken obtains a token for the bearer challenge.
+func (c *registryClient) fetchToken(ctx context.Context, challenge string) (string, error) {
+	if !strings.HasPrefix(challenge, "Bearer ") {
+		return "", fmt.Errorf("unsupported registry authentication: %q", challenge)
+	}
+	params := map[string]string{}
+	for _, part := range strings.Split(strings.TrimPrefix(challenge, "Bearer "), ",") {
+		if kv := strings.SplitN(part, "=", 2); len(kv) == 2 {
+			params[strings.TrimSpace(kv[0])] = strings.Trim(kv[1], `"`)
+		}
+	}
+	if params["realm"] == "" {
+		return "", errors.New("registry authentication challenge has no realm")
+	}
+	q := url.Values{}
+	if params["service"] != "" {
+		q.Set("service", params["service"])
+	}
+	if params["scope"] != "" {
+		q.Set("scope", params["scope"])
+	}
+	resp, err := httpGet(ctx, c.client, params["realm"]+"?"+q.Encode())
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("registry token request: %s", resp.Status)
+	}
+	var tok struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&tok); err != nil {
+		return "", err
+	}
+	if tok.Token != "" {
+		return tok.Token, nil
+	}
+	return tok.AccessToken, nil
+}
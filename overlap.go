@@ -0,0 +1,213 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+
+	"golang.org/x/mod/modfile"
+	"golang.org/x/mod/module"
+)
+
+// overlap relates the requirements of a source and a destination.
+type overlap struct {
+	Dest, Src string
+	// Shared modules are required at the same version by both.
+	Shared   []module.Version
+	DestOnly []module.Version
+	SrcOnly  []module.Version
+	// Conflicts are modules required at different versions.
+	Conflicts []versionConflict
+}
+
+// versionConflict is a module the source and destination require at
+// different versions.
+type versionConflict struct {
+	Path      string
+	Dest, Src string
+}
+
+// runOverlap renders how the dependency sets of the source and destination
+// relate, to visualize a consolidation before executing it.
+func runOverlap(ctx context.Context, args []string) error {
+	var (
+		destFile string
+		srcFile  string
+		format   string
+		netOpts  netOptions
+	)
+	fs := flag.NewFlagSet("modtransplant overlap", flag.ExitOnError)
+	fs.StringVar(&destFile, "dest", "", "destination go.mod file")
+	fs.StringVar(&srcFile, "src", "", "source module (go.mod file, directory of modules, Go binary, vendor/modules.txt, requirements manifest, archive or oci:// image)")
+	fs.StringVar(&format, "format", "dot", "output format (dot or mermaid)")
+	netOpts.register(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if destFile == "" || srcFile == "" {
+		return errors.New(usage)
+	}
+	var write func(io.Writer, *overlap) error
+	switch format {
+	case "dot":
+		write = writeOverlapDOT
+	case "mermaid":
+		write = writeOverlapMermaid
+	default:
+		return fmt.Errorf("unsupported format %q", format)
+	}
+
+	dest, err := parseModFile(destFile)
+	if err != nil {
+		return err
+	}
+	src, err := loadSource(ctx, srcFile, &netOpts)
+	if err != nil {
+		return err
+	}
+	if err := setSourceModule(src, srcFile, ""); err != nil {
+		return err
+	}
+	return write(os.Stdout, computeOverlap(dest, src))
+}
+
+// computeOverlap sorts the requirements of dest and src by whether they are
+// shared, conflicting or only required by one of them, each ordered by path.
+func computeOverlap(dest, src *modfile.File) *overlap {
+	o := &overlap{Dest: dest.Module.Mod.Path, Src: src.Module.Mod.Path}
+	srcReqs := map[string]string{}
+	for _, r := range src.Require {
+		srcReqs[r.Mod.Path] = r.Mod.Version
+	}
+	destReqs := map[string]bool{}
+	for _, r := range dest.Require {
+		destReqs[r.Mod.Path] = true
+		v, ok := srcReqs[r.Mod.Path]
+		switch {
+		case !ok:
+			o.DestOnly = append(o.DestOnly, r.Mod)
+		case v == r.Mod.Version:
+			o.Shared = append(o.Shared, r.Mod)
+		default:
+			o.Conflicts = append(o.Conflicts, versionConflict{Path: r.Mod.Path, Dest: r.Mod.Version, Src: v})
+		}
+	}
+	for _, r := range src.Require {
+		if !destReqs[r.Mod.Path] {
+			o.SrcOnly = append(o.SrcOnly, r.Mod)
+		}
+	}
+
+	for _, mods := range [][]module.Version{o.Shared, o.DestOnly, o.SrcOnly} {
+		module.Sort(mods)
+	}
+	sort.Slice(o.Conflicts, func(i, j int) bool { return o.Conflicts[i].Path < o.Conflicts[j].Path })
+	return o
+}
+
+// writeOverlapDOT renders o as a Graphviz graph: the destination and source
+// modules point to the modules they require, grouped in a cluster per kind of
+// overlap. Conflicting modules are highlighted, with the versions on the
+// edges.
+func writeOverlapDOT(w io.Writer, o *overlap) error {
+	q := strconv.Quote
+	fmt.Fprintln(w, "digraph overlap {")
+	fmt.Fprintln(w, "\trankdir=LR;")
+	fmt.Fprintln(w, "\tnode [shape=box];")
+	fmt.Fprintf(w, "\t%s [label=%s, shape=ellipse, style=filled, fillcolor=lightblue];\n", q("dest"), q(o.Dest+"\n(dest)"))
+	fmt.Fprintf(w, "\t%s [label=%s, shape=ellipse, style=filled, fillcolor=lightyellow];\n", q("src"), q(o.Src+"\n(src)"))
+
+	cluster := func(name, label string, mods []module.Version, from ...string) {
+		if len(mods) == 0 {
+			return
+		}
+		fmt.Fprintf(w, "\tsubgraph %s {\n", q("cluster_"+name))
+		fmt.Fprintf(w, "\t\tlabel=%s;\n", q(fmt.Sprintf("%s (%d)", label, len(mods))))
+		for _, m := range mods {
+			fmt.Fprintf(w, "\t\t%s [label=%s];\n", q(m.Path), q(m.String()))
+		}
+		fmt.Fprintln(w, "\t}")
+		for _, m := range mods {
+			for _, f := range from {
+				fmt.Fprintf(w, "\t%s -> %s;\n", q(f), q(m.Path))
+			}
+		}
+	}
+	cluster("shared", "shared", o.Shared, "dest", "src")
+	cluster("dest_only", "dest only", o.DestOnly, "dest")
+	cluster("src_only", "src only", o.SrcOnly, "src")
+
+	if len(o.Conflicts) > 0 {
+		fmt.Fprintf(w, "\tsubgraph %s {\n", q("cluster_conflicts"))
+		fmt.Fprintf(w, "\t\tlabel=%s;\n", q(fmt.Sprintf("version conflicts (%d)", len(o.Conflicts))))
+		for _, c := range o.Conflicts {
+			fmt.Fprintf(w, "\t\t%s [label=%s, color=red, fontcolor=red];\n", q(c.Path), q(c.Path))
+		}
+		fmt.Fprintln(w, "\t}")
+		for _, c := range o.Conflicts {
+			fmt.Fprintf(w, "\t%s -> %s [label=%s, color=red];\n", q("dest"), q(c.Path), q(c.Dest))
+			fmt.Fprintf(w, "\t%s -> %s [label=%s, color=red];\n", q("src"), q(c.Path), q(c.Src))
+		}
+	}
+	_, err := fmt.Fprintln(w, "}")
+	return err
+}
+
+// writeOverlapMermaid renders o like writeOverlapDOT, as a Mermaid flowchart
+// in a fenced code block that renders in GitHub and GitLab markdown.
+func writeOverlapMermaid(w io.Writer, o *overlap) error {
+	fmt.Fprintln(w, "```mermaid")
+	fmt.Fprintln(w, "flowchart LR")
+	fmt.Fprintf(w, "\tdest([\"%s<br>(dest)\"])\n", o.Dest)
+	fmt.Fprintf(w, "\tsrc([\"%s<br>(src)\"])\n", o.Src)
+
+	// Module paths aren't valid node IDs, so nodes are numbered.
+	var n int
+	node := func() string {
+		n++
+		return fmt.Sprintf("m%d", n)
+	}
+	subgraph := func(name, label string, mods []module.Version, from ...string) {
+		if len(mods) == 0 {
+			return
+		}
+		ids := make([]string, len(mods))
+		fmt.Fprintf(w, "\tsubgraph %s [\"%s (%d)\"]\n", name, label, len(mods))
+		for i, m := range mods {
+			ids[i] = node()
+			fmt.Fprintf(w, "\t\t%s[\"%s\"]\n", ids[i], m)
+		}
+		fmt.Fprintln(w, "\tend")
+		for _, id := range ids {
+			for _, f := range from {
+				fmt.Fprintf(w, "\t%s --> %s\n", f, id)
+			}
+		}
+	}
+	subgraph("shared", "shared", o.Shared, "dest", "src")
+	subgraph("dest_only", "dest only", o.DestOnly, "dest")
+	subgraph("src_only", "src only", o.SrcOnly, "src")
+
+	if len(o.Conflicts) > 0 {
+		ids := make([]string, len(o.Conflicts))
+		fmt.Fprintf(w, "\tsubgraph conflicts [\"version conflicts (%d)\"]\n", len(o.Conflicts))
+		for i, c := range o.Conflicts {
+			ids[i] = node()
+			fmt.Fprintf(w, "\t\t%s[\"%s\"]:::conflict\n", ids[i], c.Path)
+		}
+		fmt.Fprintln(w, "\tend")
+		for i, c := range o.Conflicts {
+			fmt.Fprintf(w, "\tdest -->|%s| %s\n", c.Dest, ids[i])
+			fmt.Fprintf(w, "\tsrc -->|%s| %s\n", c.Src, ids[i])
+		}
+		fmt.Fprintln(w, "\tclassDef conflict stroke:#d00,color:#d00")
+	}
+	_, err := fmt.Fprintln(w, "```")
+	return err
+}
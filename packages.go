@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/mod/modfile"
+)
+
+// restrictToPackages reduces src to the modules providing the packages
+// matched by patterns and their dependencies, as listed by go list in the
+// source module at srcFile, so only what those packages need is transplanted.
+// Tests aren't part of the build list, so modules only the tests need are
+// left out too. Replacements and exclusions of other modules are dropped as
+// well.
+func restrictToPackages(ctx context.Context, src *modfile.File, srcFile string, patterns []string) error {
+	if filepath.Base(srcFile) != "go.mod" {
+		return fmt.Errorf("-src-packages and -skip-test-deps require -src to be the go.mod file of a module, not %s", srcFile)
+	}
+	args := append([]string{"list", "-deps", "-f", "{{with .Module}}{{.Path}}{{end}}"}, patterns...)
+	cmd := exec.CommandContext(ctx, "go", args...)
+	cmd.Dir = filepath.Dir(srcFile)
+	cmd.Env = append(os.Environ(), "GOWORK=off")
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("go list %s: %s", strings.Join(patterns, " "), strings.TrimSpace(stderr.String()))
+	}
+	needed := map[string]bool{}
+	for _, path := range strings.Fields(stdout.String()) {
+		needed[path] = true
+	}
+
+	for _, r := range src.Require {
+		if !needed[r.Mod.Path] {
+			fmt.Fprintf(actionLog(), "(packages) skip: %s (not needed by %s)\n", r.Mod, strings.Join(patterns, " "))
+			if err := src.DropRequire(r.Mod.Path); err != nil {
+				return err
+			}
+		}
+	}
+	for _, r := range src.Replace {
+		if !needed[r.Old.Path] {
+			if err := src.DropReplace(r.Old.Path, r.Old.Version); err != nil {
+				return err
+			}
+		}
+	}
+	for _, e := range src.Exclude {
+		if !needed[e.Mod.Path] {
+			if err := src.DropExclude(e.Mod.Path, e.Mod.Version); err != nil {
+				return err
+			}
+		}
+	}
+	src.Cleanup()
+	return nil
+}
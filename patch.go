@@ -0,0 +1,205 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// patchContext is the number of unchanged lines around the changes of a hunk.
+const patchContext = 3
+
+// patchFile is a file of a patch, with its path relative to the root the
+// patch applies to, in slash form. A nil before is a new file.
+type patchFile struct {
+	path          string
+	before, after []byte
+}
+
+// mergePatch returns the files of the patch turning the go.mod file at
+// destFile, read as before, into after: the go.mod file itself and, if the go
+// command is installed, its go.sum file completed with the checksums the
+// merged build list needs. Paths are relative to the root of the repository
+// containing destFile.
+func mergePatch(ctx context.Context, destFile string, before, after []byte, offline bool) ([]patchFile, error) {
+	dir := filepath.Dir(destFile)
+	root, err := repoRoot(dir)
+	if err != nil {
+		return nil, err
+	}
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	rel, err := filepath.Rel(root, absDir)
+	if err != nil {
+		return nil, err
+	}
+	name := filepath.Base(destFile)
+	if destFile == stdinDest {
+		name = "go.mod"
+	}
+	files := []patchFile{{path: filepath.ToSlash(filepath.Join(rel, name)), before: before, after: after}}
+
+	if _, err := exec.LookPath("go"); err != nil {
+		fmt.Fprintln(os.Stderr, "(patch) go.sum left out: the go command isn't installed")
+		return files, nil
+	}
+	sumBefore, err := ioutil.ReadFile(filepath.Join(dir, "go.sum"))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	sumAfter, err := mergedGoSum(ctx, dir, after, offline)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(sumBefore, sumAfter) {
+		files = append(files, patchFile{path: filepath.ToSlash(filepath.Join(rel, "go.sum")), before: sumBefore, after: sumAfter})
+	}
+	return files, nil
+}
+
+// mergedGoSum returns the go.sum file of the module in dir completed with the
+// checksums its packages and their tests need with the merged go.mod file,
+// content, as the go command records them when listing the packages in a copy
+// of the module. Offline, only the module cache is used.
+func mergedGoSum(ctx context.Context, dir string, content []byte, offline bool) ([]byte, error) {
+	tmp, err := ioutil.TempDir("", "modtransplant-patch-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmp)
+	if err := copyTree(dir, tmp); err != nil {
+		return nil, fmt.Errorf("copy module for go.sum: %w", err)
+	}
+	if content, err = absLocalReplaces(content, dir); err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(filepath.Join(tmp, "go.mod"), content, 0o644); err != nil {
+		return nil, err
+	}
+	cmd := exec.CommandContext(ctx, "go", "list", "-e", "-deps", "-test", "./...")
+	cmd.Dir = tmp
+	cmd.Env = append(os.Environ(), "GOFLAGS=-mod=mod", "GOWORK=off")
+	if offline {
+		cmd.Env = append(cmd.Env, "GOPROXY=off")
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("go list: %s", strings.TrimSpace(stderr.String()))
+	}
+	sum, err := ioutil.ReadFile(filepath.Join(tmp, "go.sum"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return sum, err
+}
+
+// writePatch writes the files as a unified diff in the format of git diff,
+// which git apply and patch -p1 accept. Unchanged files are left out.
+func writePatch(w io.Writer, files []patchFile) error {
+	for _, f := range files {
+		if f.before != nil && bytes.Equal(f.before, f.after) {
+			continue
+		}
+		fmt.Fprintf(w, "diff --git a/%s b/%s\n", f.path, f.path)
+		if f.before == nil {
+			fmt.Fprintf(w, "new file mode 100644\n--- /dev/null\n")
+		} else {
+			fmt.Fprintf(w, "--- a/%s\n", f.path)
+		}
+		fmt.Fprintf(w, "+++ b/%s\n", f.path)
+		if err := writeHunks(w, patchLines(f.before), patchLines(f.after)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// patchLines splits content into lines, each with its line ending. The last
+// line lacks one if content doesn't end with a newline.
+func patchLines(content []byte) []string {
+	lines := strings.SplitAfter(string(content), "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// writeHunks writes the hunks turning the lines as into bs.
+func writeHunks(w io.Writer, as, bs []string) error {
+	lines := diffSlices(as, bs)
+	// aLine and bLine are the line numbers, from 0, of lines[i] in as and bs.
+	aLine, bLine := make([]int, len(lines)+1), make([]int, len(lines)+1)
+	for i, l := range lines {
+		aLine[i+1], bLine[i+1] = aLine[i], bLine[i]
+		if l.Op != "+" {
+			aLine[i+1]++
+		}
+		if l.Op != "-" {
+			bLine[i+1]++
+		}
+	}
+
+	for i := 0; i < len(lines); {
+		if lines[i].Op == " " {
+			i++
+			continue
+		}
+		// A hunk runs from the context before this change to the context
+		// after the last change whose context touches the previous one's.
+		start := i - patchContext
+		if start < 0 {
+			start = 0
+		}
+		end := i
+		for j := i; j < len(lines) && j-end <= 2*patchContext+1; j++ {
+			if lines[j].Op != " " {
+				end = j
+			}
+		}
+		end += patchContext + 1
+		if end > len(lines) {
+			end = len(lines)
+		}
+
+		aStart, aCount := aLine[start], aLine[end]-aLine[start]
+		bStart, bCount := bLine[start], bLine[end]-bLine[start]
+		if aCount > 0 {
+			aStart++
+		}
+		if bCount > 0 {
+			bStart++
+		}
+		if _, err := fmt.Fprintf(w, "@@ -%s +%s @@\n", hunkRange(aStart, aCount), hunkRange(bStart, bCount)); err != nil {
+			return err
+		}
+		for _, l := range lines[start:end] {
+			text := l.Text
+			if !strings.HasSuffix(text, "\n") {
+				text += "\n\\ No newline at end of file\n"
+			}
+			if _, err := fmt.Fprintf(w, "%s%s", l.Op, text); err != nil {
+				return err
+			}
+		}
+		i = end
+	}
+	return nil
+}
+
+// hunkRange formats the start and length of the lines of a hunk in one of the
+// files.
+func hunkRange(start, count int) string {
+	if count == 1 {
+		return fmt.Sprint(start)
+	}
+	return fmt.Sprintf("%d,%d", start, count)
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/mod/modfile"
+	"golang.org/x/mod/module"
+	"golang.org/x/mod/semver"
+)
+
+// localMarker prefixes the directory a pinned replacement was pinned from in
+// the comment at the end of its line, so it can be unpinned again.
+const localMarker = "modtransplant:local "
+
+// runPinReplaces rewrites the local-path replacements of the destination as
+// replacements pinned to the version checked out at that path, or, with
+// -unpin, the other way around.
+func runPinReplaces(ctx context.Context, args []string) error {
+	var (
+		destFile string
+		unpin    bool
+		write    bool
+	)
+	fs := flag.NewFlagSet("modtransplant pin-replaces", flag.ExitOnError)
+	fs.StringVar(&destFile, "dest", "", "destination go.mod file")
+	fs.BoolVar(&unpin, "unpin", false, "turn pinned replacements back into the local-path replacements they were pinned from")
+	fs.BoolVar(&write, "w", false, "write the result to the destination file instead of stdout")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if destFile == "" {
+		return errors.New(usage)
+	}
+
+	dest, enc, err := readModFile(destFile)
+	if err != nil {
+		return err
+	}
+	if unpin {
+		unpinReplaces(dest)
+	} else if err := pinReplaces(ctx, dest, filepath.Dir(destFile)); err != nil {
+		return err
+	}
+
+	out, err := dest.Format()
+	if err != nil {
+		return err
+	}
+	out = enc.apply(out)
+	if !write {
+		fmt.Println(string(out))
+		return nil
+	}
+	return writeFile(destFile, out)
+}
+
+// pinReplaces rewrites each local-path replacement of f as a replacement with
+// the module and version checked out at that path. Relative paths are
+// relative to dir.
+func pinReplaces(ctx context.Context, f *modfile.File, dir string) error {
+	for _, r := range f.Replace {
+		if r.New.Version != "" || !modfile.IsDirectoryPath(r.New.Path) {
+			continue
+		}
+		local := r.New.Path
+		if !filepath.IsAbs(local) {
+			local = filepath.Join(dir, local)
+		}
+		mod, err := localModule(ctx, local)
+		if err != nil {
+			return fmt.Errorf("%s => %s: %w", r.Old, r.New.Path, err)
+		}
+		fmt.Fprintf(actionLog(), "(pin) %s: %s -> %s\n", r.Old, r.New.Path, mod)
+		setReplacement(r, mod, r.New.Path)
+	}
+	return nil
+}
+
+// unpinReplaces turns the replacements pinned by pinReplaces back into
+// local-path replacements.
+func unpinReplaces(f *modfile.File) {
+	for _, r := range f.Replace {
+		local := pinnedFrom(r)
+		if local == "" {
+			continue
+		}
+		fmt.Fprintf(actionLog(), "(unpin) %s: %s -> %s\n", r.Old, r.New, local)
+		setReplacement(r, module.Version{Path: local}, "")
+	}
+}
+
+// pinnedFrom returns the directory r was pinned from, if any.
+func pinnedFrom(r *modfile.Replace) string {
+	if r.Syntax == nil {
+		return ""
+	}
+	for _, c := range r.Syntax.Comments.Suffix {
+		if i := strings.Index(c.Token, localMarker); i >= 0 {
+			return strings.TrimSpace(c.Token[i+len(localMarker):])
+		}
+	}
+	return ""
+}
+
+// setReplacement points r at mod in place, leaving other replacements of the
+// same module alone, and records local as the directory it was pinned from.
+// Other comments on the line are kept.
+func setReplacement(r *modfile.Replace, mod module.Version, local string) {
+	r.New = mod
+	var tokens []string
+	if !r.Syntax.InBlock {
+		tokens = append(tokens, "replace")
+	}
+	tokens = append(tokens, modfile.AutoQuote(r.Old.Path))
+	if r.Old.Version != "" {
+		tokens = append(tokens, r.Old.Version)
+	}
+	tokens = append(tokens, "=>", modfile.AutoQuote(mod.Path))
+	if mod.Version != "" {
+		tokens = append(tokens, mod.Version)
+	}
+	r.Syntax.Token = tokens
+
+	// A line can only carry one suffix comment, so the marker shares it.
+	var comment string
+	for _, c := range r.Syntax.Comments.Suffix {
+		comment = c.Token
+	}
+	if i := strings.Index(comment, localMarker); i >= 0 {
+		comment = strings.TrimSuffix(strings.TrimSpace(comment[:i]), ";")
+	}
+	if comment == "//" {
+		comment = ""
+	}
+	switch {
+	case local == "":
+	case comment == "":
+		comment = "// " + localMarker + local
+	default:
+		comment += "; " + localMarker + local
+	}
+	r.Syntax.Comments.Suffix = nil
+	if comment != "" {
+		r.Syntax.Comments.Suffix = []modfile.Comment{{Token: comment, Suffix: true}}
+	}
+}
+
+// localModule returns the module checked out in dir: the release tagged at
+// its HEAD commit, or a pseudo-version of that commit.
+func localModule(ctx context.Context, dir string) (module.Version, error) {
+	data, err := ioutil.ReadFile(filepath.Join(dir, "go.mod"))
+	if err != nil {
+		return module.Version{}, err
+	}
+	modPath := modfile.ModulePath(data)
+	if modPath == "" {
+		return module.Version{}, fmt.Errorf("%s: no module path in go.mod", dir)
+	}
+
+	out, err := git(ctx, dir, "rev-parse", "--show-toplevel", "HEAD")
+	if err != nil {
+		return module.Version{}, err
+	}
+	lines := strings.Fields(string(out))
+	if len(lines) != 2 {
+		return module.Version{}, fmt.Errorf("%s: unexpected output of git rev-parse", dir)
+	}
+	top, commit := lines[0], lines[1]
+	if out, err := git(ctx, dir, "status", "--porcelain", "--", "."); err == nil && len(out) > 0 {
+		fmt.Fprintf(os.Stderr, "(pin) warning: %s has uncommitted changes that won't be part of the pinned version\n", dir)
+	}
+
+	// Tags of a module in a subdirectory are prefixed with the directory,
+	// without any major version suffix.
+	absDir, err := filepath.Abs(dir)
+	if err == nil {
+		absDir, err = filepath.EvalSymlinks(absDir)
+	}
+	if err != nil {
+		return module.Version{}, err
+	}
+	rel, err := filepath.Rel(top, absDir)
+	if err != nil {
+		return module.Version{}, err
+	}
+	_, pathMajor, _ := module.SplitPathVersion(modPath)
+	major := module.PathMajorPrefix(pathMajor)
+	baseDir := filepath.ToSlash(rel)
+	if major != "" && path.Base(baseDir) == major {
+		baseDir = path.Dir(baseDir)
+	}
+	if baseDir == "." {
+		baseDir = ""
+	}
+
+	tagged := func(args ...string) (string, error) {
+		out, err := git(ctx, dir, append([]string{"tag", "--list", tagPrefix(baseDir) + "v*"}, args...)...)
+		if err != nil {
+			return "", err
+		}
+		var best string
+		for _, tag := range strings.Fields(string(out)) {
+			v := strings.TrimPrefix(tag, tagPrefix(baseDir))
+			if !semver.IsValid(v) || v != semver.Canonical(v) || module.CheckPathMajor(v, pathMajor) != nil {
+				continue
+			}
+			if best == "" || semver.Compare(v, best) > 0 {
+				best = v
+			}
+		}
+		return best, nil
+	}
+	if v, err := tagged("--points-at", commit); err != nil {
+		return module.Version{}, err
+	} else if v != "" {
+		return module.Version{Path: modPath, Version: v}, nil
+	}
+
+	older, err := tagged("--merged", commit)
+	if err != nil {
+		return module.Version{}, err
+	}
+	out, err = git(ctx, dir, "log", "-1", "--format=%cI", commit)
+	if err != nil {
+		return module.Version{}, err
+	}
+	t, err := time.Parse(time.RFC3339, strings.TrimSpace(string(out)))
+	if err != nil {
+		return module.Version{}, err
+	}
+	return module.Version{Path: modPath, Version: module.PseudoVersion(major, older, t, commit[:12])}, nil
+}
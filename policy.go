@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/cel-go/cel"
+
+	"github.com/brettbuddin/modtransplant/transplant"
+)
+
+// celResolver resolves conflicts with a CEL expression.
+type celResolver struct {
+	prg cel.Program
+}
+
+// newCELResolver compiles a CEL expression into a conflict resolver. The
+// expression sees the conflict as the map "module", with the keys kind, path,
+// dest_version, src_version, dest_indirect, src_indirect, dest_replacement and
+// src_replacement, and must evaluate to one of "highest", "keep-dest",
+// "take-src" or "fail". For example:
+//
+//	module.path.startsWith("k8s.io/") ? "keep-dest" : "highest"
+func newCELResolver(expr string) (*celResolver, error) {
+	env, err := cel.NewEnv(cel.Variable("module", cel.MapType(cel.StringType, cel.DynType)))
+	if err != nil {
+		return nil, err
+	}
+	ast, iss := env.Compile(expr)
+	if iss.Err() != nil {
+		return nil, fmt.Errorf("conflict policy: %w", iss.Err())
+	}
+	if !ast.OutputType().IsExactType(cel.StringType) && !ast.OutputType().IsExactType(cel.DynType) {
+		return nil, fmt.Errorf("conflict policy: must evaluate to a string, not %s", ast.OutputType())
+	}
+	prg, err := env.Program(ast)
+	if err != nil {
+		return nil, fmt.Errorf("conflict policy: %w", err)
+	}
+	return &celResolver{prg: prg}, nil
+}
+
+func (r *celResolver) Resolve(ctx context.Context, c transplant.Conflict) (transplant.Resolution, error) {
+	out, _, err := r.prg.ContextEval(ctx, map[string]interface{}{
+		"module": map[string]interface{}{
+			"kind":             string(c.Kind),
+			"path":             c.Path,
+			"dest_version":     c.DestVersion,
+			"src_version":      c.SrcVersion,
+			"dest_indirect":    c.DestIndirect,
+			"src_indirect":     c.SrcIndirect,
+			"dest_replacement": c.DestReplacement,
+			"src_replacement":  c.SrcReplacement,
+		},
+	})
+	if err != nil {
+		return "", fmt.Errorf("conflict policy: %s: %w", c, err)
+	}
+	decision, ok := out.Value().(string)
+	if !ok {
+		return "", fmt.Errorf("conflict policy: %s: result is %s, not a string", c, out.Type().TypeName())
+	}
+	return transplant.Resolution(decision), nil
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"runtime"
+	"runtime/pprof"
+	runtimetrace "runtime/trace"
+)
+
+// profileOptions select the profiles written of a run, to find out where the
+// time of merges into huge go.mod files goes.
+type profileOptions struct {
+	cpu   string
+	mem   string
+	trace string
+}
+
+// register adds the profiling flags to fs.
+func (o *profileOptions) register(fs *flag.FlagSet) {
+	fs.StringVar(&o.cpu, "cpuprofile", "", "write a CPU profile of the run to this file")
+	fs.StringVar(&o.mem, "memprofile", "", "write a heap profile to this file at the end of the run")
+	fs.StringVar(&o.trace, "trace", "", "write an execution trace of the run to this file")
+}
+
+// start starts the CPU profile and execution trace, if requested. The
+// returned function stops them and writes the heap profile.
+func (o *profileOptions) start() (stop func() error, err error) {
+	var cpuFile, traceFile *os.File
+	stop = func() error {
+		var err error
+		if cpuFile != nil {
+			pprof.StopCPUProfile()
+			err = cpuFile.Close()
+		}
+		if traceFile != nil {
+			runtimetrace.Stop()
+			if cerr := traceFile.Close(); cerr != nil && err == nil {
+				err = cerr
+			}
+		}
+		if o.mem != "" {
+			if merr := writeHeapProfile(o.mem); merr != nil && err == nil {
+				err = merr
+			}
+		}
+		return err
+	}
+	if o.cpu != "" {
+		f, err := os.Create(o.cpu)
+		if err != nil {
+			return nil, err
+		}
+		if err := pprof.StartCPUProfile(f); err != nil {
+			f.Close()
+			return nil, err
+		}
+		cpuFile = f
+	}
+	if o.trace != "" {
+		f, err := os.Create(o.trace)
+		if err == nil {
+			if err = runtimetrace.Start(f); err != nil {
+				f.Close()
+			}
+		}
+		if err != nil {
+			if cpuFile != nil {
+				pprof.StopCPUProfile()
+				cpuFile.Close()
+			}
+			return nil, err
+		}
+		traceFile = f
+	}
+	return stop, nil
+}
+
+func writeHeapProfile(path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	// Collect garbage first, so the profile shows live memory only.
+	runtime.GC()
+	if err := pprof.WriteHeapProfile(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
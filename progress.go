@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// progressInterval is how often a progress line is printed when stderr
+	// isn't a terminal.
+	progressInterval = 10 * time.Second
+	// progressRedraw is how often the progress bar is redrawn on a terminal.
+	progressRedraw = 200 * time.Millisecond
+	progressWidth  = 30
+)
+
+// progress reports how many of the modules of a long operation, such as
+// loading the module graph, have been processed: as a bar redrawn in place when
+// stderr is a terminal, and as a "(progress)" line every progressInterval
+// otherwise, so quick operations print nothing. A nil *progress reports
+// nothing. Modules may be processed concurrently, so it is safe for concurrent
+// use.
+type progress struct {
+	label   string
+	tty     bool
+	stop    chan struct{}
+	stopped chan struct{}
+	once    sync.Once
+
+	mu    sync.Mutex
+	done  int
+	total int
+	drawn bool
+}
+
+// startProgress starts reporting the progress of the operation label over
+// total modules, unless -no-progress is set. It must be stopped with finish.
+func startProgress(opts *netOptions, label string, total int) *progress {
+	if opts.noProgress {
+		return nil
+	}
+	p := &progress{
+		label:   label,
+		tty:     isTerminal(console),
+		stop:    make(chan struct{}),
+		stopped: make(chan struct{}),
+		total:   total,
+	}
+	interval := progressInterval
+	if p.tty {
+		interval = progressRedraw
+	}
+	go p.run(interval)
+	return p
+}
+
+// isTerminal reports whether f is a terminal that can redraw a line.
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0 && os.Getenv("TERM") != "dumb"
+}
+
+func (p *progress) run(interval time.Duration) {
+	defer close(p.stopped)
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-p.stop:
+			if p.drawn {
+				fmt.Fprint(console, "\r\033[K")
+			}
+			return
+		case <-t.C:
+			p.report()
+		}
+	}
+}
+
+func (p *progress) report() {
+	p.mu.Lock()
+	done, total := p.done, p.total
+	p.mu.Unlock()
+	if !p.tty {
+		fmt.Fprintf(console, "(progress) %s: %d of %d modules, %d remaining\n", p.label, done, total, total-done)
+		return
+	}
+	filled := 0
+	if total > 0 {
+		filled = min(progressWidth*done/total, progressWidth)
+	}
+	bar := strings.Repeat("=", filled) + strings.Repeat(" ", progressWidth-filled)
+	fmt.Fprintf(console, "\r\033[K%s [%s] %d/%d modules", p.label, bar, done, total)
+	p.drawn = true
+}
+
+// grow adds n modules to the operation, for operations that discover their
+// modules as they go.
+func (p *progress) grow(n int) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	p.total += n
+	p.mu.Unlock()
+}
+
+// add records that n modules have been processed.
+func (p *progress) add(n int) {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	p.done += n
+	p.mu.Unlock()
+}
+
+// finish stops reporting, clearing the bar. Calls after the first do nothing.
+func (p *progress) finish() {
+	if p == nil {
+		return
+	}
+	p.once.Do(func() { close(p.stop) })
+	<-p.stopped
+}
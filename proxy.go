@@ -0,0 +1,344 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"golang.org/x/mod/modfile"
+	"golang.org/x/mod/module"
+
+	"github.com/brettbuddin/modtransplant/transplant"
+)
+
+const defaultGoProxy = "https://proxy.golang.org,direct"
+
+var (
+	errProxyOff  = errors.New("module lookup disabled by GOPROXY=off")
+	errNotFound  = transplant.ErrNotFound
+	errNoProxies = errors.New("GOPROXY list is empty")
+)
+
+// goEnv returns the value of a go command setting, looking first at the
+// environment and then at the file written by 'go env -w', the same way the go
+// command does.
+func goEnv(key string) string {
+	if v, ok := os.LookupEnv(key); ok {
+		return v
+	}
+	envFile := os.Getenv("GOENV")
+	if envFile == "" {
+		dir, err := os.UserConfigDir()
+		if err != nil {
+			return ""
+		}
+		envFile = filepath.Join(dir, "go", "env")
+	}
+	if envFile == "off" {
+		return ""
+	}
+	content, err := ioutil.ReadFile(envFile)
+	if err != nil {
+		return ""
+	}
+	for _, line := range strings.Split(string(content), "\n") {
+		if kv := strings.SplitN(line, "=", 2); len(kv) == 2 && strings.TrimSpace(kv[0]) == key {
+			return strings.TrimSpace(kv[1])
+		}
+	}
+	return ""
+}
+
+// proxyEntry is one element of a GOPROXY list.
+type proxyEntry struct {
+	// url is the proxy's base URL, or "direct" or "off".
+	url string
+	// fallBackOnError is set when the entry was followed by a pipe, meaning
+	// any error (not just "not found") moves on to the next entry.
+	fallBackOnError bool
+}
+
+// parseGoProxy parses a GOPROXY value. Entries separated by commas fall back
+// to the next entry only on 404 and 410 responses; entries separated by pipes
+// fall back on any error.
+func parseGoProxy(value string) ([]proxyEntry, error) {
+	if value == "" {
+		value = defaultGoProxy
+	}
+	var entries []proxyEntry
+	for value != "" {
+		var (
+			entry   string
+			sepPipe bool
+			i       = strings.IndexAny(value, ",|")
+		)
+		if i >= 0 {
+			entry, sepPipe, value = value[:i], value[i] == '|', value[i+1:]
+		} else {
+			entry, value = value, ""
+		}
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		switch entry {
+		case "direct", "off":
+		default:
+			if !strings.Contains(entry, "://") {
+				entry = "https://" + entry
+			}
+			if _, err := url.Parse(entry); err != nil {
+				return nil, fmt.Errorf("invalid GOPROXY entry %q: %w", entry, err)
+			}
+			entry = strings.TrimSuffix(entry, "/")
+		}
+		entries = append(entries, proxyEntry{url: entry, fallBackOnError: sepPipe})
+	}
+	if len(entries) == 0 {
+		return nil, errNoProxies
+	}
+	return entries, nil
+}
+
+// proxyClient fetches module metadata through a GOPROXY chain.
+type proxyClient struct {
+	client  *http.Client
+	proxies []proxyEntry
+	cache   metadataCache
+	offline bool
+	timeout time.Duration
+	metrics *runMetrics
+}
+
+// newProxyClient returns a proxyClient configured from GOPROXY.
+func newProxyClient(opts *netOptions) (*proxyClient, error) {
+	if opts.offline && opts.noCache {
+		return nil, errors.New("-offline can't be combined with -no-cache")
+	}
+	proxies, err := parseGoProxy(goEnv("GOPROXY"))
+	if err != nil {
+		return nil, err
+	}
+	return &proxyClient{
+		client:  newHTTPClient(opts),
+		proxies: proxies,
+		cache:   metadataCache{dir: opts.cacheDir, ttl: opts.cacheTTL, disabled: opts.noCache},
+		offline: opts.offline,
+		timeout: opts.timeout,
+		metrics: opts.metrics,
+	}, nil
+}
+
+// GoMod fetches the go.mod file of path@version.
+func (p *proxyClient) GoMod(ctx context.Context, path, version string) ([]byte, error) {
+	v, err := module.EscapeVersion(version)
+	if err != nil {
+		return nil, err
+	}
+	return p.fetch(ctx, path, "@v/"+v+".mod")
+}
+
+// Info fetches the .info file of path@version, which the proxy only serves
+// for versions that exist.
+func (p *proxyClient) Info(ctx context.Context, path, version string) (*transplant.VersionInfo, error) {
+	v, err := module.EscapeVersion(version)
+	if err != nil {
+		return nil, err
+	}
+	data, err := p.fetch(ctx, path, "@v/"+v+".info")
+	if err != nil {
+		return nil, err
+	}
+	var info transplant.VersionInfo
+	if err := json.Unmarshal(data, &info); err != nil {
+		return nil, fmt.Errorf("%s@%s: %w", path, version, err)
+	}
+	return &info, nil
+}
+
+// List lists the versions of path known to the proxy.
+func (p *proxyClient) List(ctx context.Context, path string) ([]string, error) {
+	data, err := p.fetch(ctx, path, "@v/list")
+	if err != nil {
+		return nil, err
+	}
+	return strings.Fields(string(data)), nil
+}
+
+// Latest resolves the latest version of path.
+func (p *proxyClient) Latest(ctx context.Context, path string) (string, error) {
+	data, err := p.fetch(ctx, path, "@latest")
+	if err != nil {
+		return "", err
+	}
+	var info struct{ Version string }
+	if err := json.Unmarshal(data, &info); err != nil {
+		return "", fmt.Errorf("%s@latest: %w", path, err)
+	}
+	return info.Version, nil
+}
+
+// fetch retrieves the file at rel (e.g. "@v/list") for module path. Immutable
+// files, and others younger than the cache TTL, are served from the cache
+// when possible, as is everything when offline. Otherwise the proxy chain is
+// walked with the go command's fallback rules, and paths matching GONOPROXY
+// (or GOPRIVATE) bypass the proxies and are fetched directly.
+func (p *proxyClient) fetch(ctx context.Context, path, rel string) ([]byte, error) {
+	if data, fresh, ok := p.cache.get(path, rel); ok && (p.offline || fresh) {
+		p.metrics.cacheLookup(true)
+		return data, nil
+	}
+	p.metrics.cacheLookup(false)
+	stop := p.metrics.time(phaseNetwork)
+	data, err := p.fetchRemote(ctx, path, rel)
+	stop()
+	if err != nil {
+		return nil, err
+	}
+	p.cache.put(path, rel, data)
+	return data, nil
+}
+
+func (p *proxyClient) fetchRemote(ctx context.Context, path, rel string) ([]byte, error) {
+	escaped, err := module.EscapePath(path)
+	if err != nil {
+		return nil, err
+	}
+	proxies := p.proxies
+	if isPrivate("GONOPROXY", path) {
+		proxies = []proxyEntry{{url: "direct"}}
+	}
+	var lastErr error
+	for _, proxy := range proxies {
+		var data []byte
+		switch proxy.url {
+		case "off":
+			return nil, errProxyOff
+		case "direct":
+			if p.offline {
+				err = errOffline
+			} else {
+				data, err = p.directFetch(ctx, path, rel)
+			}
+		default:
+			data, err = p.get(ctx, proxy.url+"/"+escaped+"/"+rel)
+		}
+		if err == nil {
+			return data, nil
+		}
+		lastErr = fmt.Errorf("%s/%s: %w", path, rel, err)
+		if !proxy.fallBackOnError && !errors.Is(err, errNotFound) {
+			return nil, lastErr
+		}
+	}
+	return nil, lastErr
+}
+
+// directFetch fetches rel from the module's repository, bounding the whole
+// operation, which may take several git commands, by the -timeout.
+func (p *proxyClient) directFetch(ctx context.Context, path, rel string) ([]byte, error) {
+	if p.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.timeout)
+		defer cancel()
+	}
+	return directFetch(ctx, p.client, path, rel)
+}
+
+// get retrieves a URL, mapping 404 and 410 responses (and missing files for
+// file:// proxies) to errNotFound.
+func (p *proxyClient) get(ctx context.Context, rawurl string) ([]byte, error) {
+	if strings.HasPrefix(rawurl, "file://") {
+		u, err := url.Parse(rawurl)
+		if err != nil {
+			return nil, err
+		}
+		data, err := ioutil.ReadFile(filepath.FromSlash(u.Path))
+		if os.IsNotExist(err) {
+			return nil, errNotFound
+		}
+		return data, err
+	}
+
+	resp, err := httpGet(ctx, p.client, rawurl)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone:
+		return nil, fmt.Errorf("%s: %w", resp.Status, errNotFound)
+	case resp.StatusCode != http.StatusOK:
+		return nil, fmt.Errorf("%s: %s", rawurl, resp.Status)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+// loadRemoteSource fetches the go.mod file of a module@version query (where
+// version may be "latest") through the GOPROXY chain and verifies it against
+// the checksum database.
+func loadRemoteSource(ctx context.Context, query string, opts *netOptions) (*modfile.File, error) {
+	i := strings.LastIndex(query, "@")
+	path, version := query[:i], query[i+1:]
+	if opts.resolver == resolverGo || opts.resolver == resolverNone {
+		// The go command verifies the go.mod file itself.
+		f, err := newModuleFetcher(opts)
+		if err != nil {
+			return nil, err
+		}
+		if version == "latest" {
+			if version, err = f.Latest(ctx, path); err != nil {
+				return nil, err
+			}
+		}
+		data, err := f.GoMod(ctx, path, version)
+		if err != nil {
+			return nil, err
+		}
+		return modfile.Parse(path+"@"+version+"/go.mod", data, nil)
+	}
+	p, err := newProxyClient(opts)
+	if err != nil {
+		return nil, err
+	}
+	if version == "latest" {
+		if version, err = p.Latest(ctx, path); err != nil {
+			return nil, err
+		}
+	}
+	data, err := p.GoMod(ctx, path, version)
+	if err != nil {
+		return nil, err
+	}
+	sumdb, err := newSumDBClient(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifyGoMod(sumdb, path, version, data); err != nil {
+		return nil, err
+	}
+	return modfile.Parse(path+"@"+version+"/go.mod", data, nil)
+}
+
+// privatePatterns returns the module path patterns of a GONOPROXY-style
+// variable, which defaults to GOPRIVATE.
+func privatePatterns(key string) string {
+	if v := goEnv(key); v != "" {
+		return v
+	}
+	return goEnv("GOPRIVATE")
+}
+
+// isPrivate reports whether path matches the patterns of a GONOPROXY-style
+// variable.
+func isPrivate(key, path string) bool {
+	return module.MatchPrefixPatterns(privatePatterns(key), path)
+}
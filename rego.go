@@ -0,0 +1,214 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"golang.org/x/mod/modfile"
+	"golang.org/x/mod/module"
+	"golang.org/x/mod/semver"
+)
+
+// regoQuery is the document a policy bundle defines its decisions in.
+const regoQuery = "data.modtransplant"
+
+// regoDecision is the data.modtransplant document of a policy bundle:
+//
+//   - allow, if defined and false, rejects the change set;
+//   - deny is a set of messages, each rejecting the change set;
+//   - modify lists amendments to the change set.
+type regoDecision struct {
+	Allow  *bool              `json:"allow"`
+	Deny   []string           `json:"deny"`
+	Modify []regoModification `json:"modify"`
+}
+
+// regoModification amends one entry of the change set. For requirements, To is
+// the version to use instead; for replacements it is the replacement, as
+// "path" or "path@version". An empty To reverts the entry to its state before
+// the merge.
+type regoModification struct {
+	Kind    string `json:"kind"`
+	Path    string `json:"path"`
+	Version string `json:"version"`
+	To      string `json:"to"`
+}
+
+// applyRegoPolicy evaluates the change set turning before into after against
+// the Rego policy bundle (a directory or tarball) with the opa command, and
+// applies the modifications it decides on to after. Central platform policy can
+// thereby govern transplants across an organization, except for the modules
+// whose lines carry a control comment.
+func applyRegoPolicy(ctx context.Context, bundle string, before, after *modfile.File, destFile, srcFile string) error {
+	input, err := json.Marshal(report{
+		Stage:   "policy",
+		Module:  after.Module.Mod.Path,
+		Dest:    destFile,
+		Src:     srcFile,
+		Changes: diffModFiles(before, after),
+	})
+	if err != nil {
+		return err
+	}
+
+	cmd := exec.CommandContext(ctx, "opa", "eval", "--format=json", "--bundle", bundle, "--stdin-input", regoQuery)
+	cmd.Stdin = bytes.NewReader(input)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return fmt.Errorf("opa eval: %s", strings.TrimSpace(stderr.String()))
+		}
+		return fmt.Errorf("opa eval: %w", err)
+	}
+
+	var result struct {
+		Result []struct {
+			Expressions []struct {
+				Value regoDecision `json:"value"`
+			} `json:"expressions"`
+		} `json:"result"`
+	}
+	if err := json.Unmarshal(out, &result); err != nil {
+		return fmt.Errorf("opa eval: %w", err)
+	}
+	if len(result.Result) == 0 || len(result.Result[0].Expressions) == 0 {
+		return fmt.Errorf("rego policy: %s is undefined in %s", regoQuery, bundle)
+	}
+	decision := result.Result[0].Expressions[0].Value
+
+	if decision.Allow != nil && !*decision.Allow {
+		decision.Deny = append(decision.Deny, "change set not allowed")
+	}
+	if len(decision.Deny) > 0 {
+		return fmt.Errorf("rego policy denied the transplant:\n\t%s", strings.Join(decision.Deny, "\n\t"))
+	}
+	for _, m := range decision.Modify {
+		if err := checkRegoModification(m); err != nil {
+			return fmt.Errorf("rego policy %s: invalid modification of %s %s: %w", bundle, m.Kind, m.Path, err)
+		}
+	}
+	controls := destControls(before)
+	for _, m := range decision.Modify {
+		if c := controls[m.Path]; c != "" {
+			fmt.Fprintf(os.Stderr, "(rego) %s %s: left alone by %s\n", m.Kind, m.Path, c)
+			continue
+		}
+		if err := applyRegoModification(before, after, m); err != nil {
+			return err
+		}
+	}
+	after.Cleanup()
+	after.SetRequire(after.Require)
+	return nil
+}
+
+// checkRegoModification checks the module paths and versions a policy decided
+// on before they are written to the destination.
+func checkRegoModification(m regoModification) error {
+	if err := module.CheckPath(m.Path); err != nil {
+		return err
+	}
+	switch m.Kind {
+	case "require":
+		if m.To != "" {
+			if !semver.IsValid(m.To) {
+				return fmt.Errorf("invalid version %q", m.To)
+			}
+			return module.Check(m.Path, m.To)
+		}
+	case "replace":
+		if m.Version != "" && !semver.IsValid(m.Version) {
+			return fmt.Errorf("invalid version %q", m.Version)
+		}
+		if m.To == "" {
+			return nil
+		}
+		newPath, newVersion := m.To, ""
+		if i := strings.LastIndex(m.To, "@"); i >= 0 {
+			newPath, newVersion = m.To[:i], m.To[i+1:]
+		}
+		switch {
+		case newVersion == "" && modfile.IsDirectoryPath(newPath):
+			return nil
+		case newVersion == "":
+			return fmt.Errorf("replacement %q is neither a local directory nor path@version", m.To)
+		case !semver.IsValid(newVersion):
+			return fmt.Errorf("invalid version %q", newVersion)
+		}
+		return module.Check(newPath, newVersion)
+	}
+	return nil
+}
+
+func applyRegoModification(before, after *modfile.File, m regoModification) error {
+	switch m.Kind {
+	case "require":
+		var prev *modfile.Require
+		for _, r := range before.Require {
+			if r.Mod.Path == m.Path {
+				prev = r
+			}
+		}
+		var cur *modfile.Require
+		for _, r := range after.Require {
+			if r.Mod.Path == m.Path {
+				cur = r
+			}
+		}
+		switch {
+		case m.To != "" && cur != nil:
+			fmt.Fprintf(os.Stderr, "(rego) require version: %s %s -> %s\n", m.Path, cur.Mod.Version, m.To)
+			cur.Mod.Version = m.To
+		case m.To != "":
+			fmt.Fprintf(os.Stderr, "(rego) require add: %s@%s\n", m.Path, m.To)
+			after.AddNewRequire(m.Path, m.To, false)
+		case prev != nil && cur != nil:
+			fmt.Fprintf(os.Stderr, "(rego) require revert: %s\n", prev.Mod)
+			cur.Mod.Version, cur.Indirect = prev.Mod.Version, prev.Indirect
+		case prev != nil:
+			fmt.Fprintf(os.Stderr, "(rego) require revert: %s\n", prev.Mod)
+			after.AddNewRequire(prev.Mod.Path, prev.Mod.Version, prev.Indirect)
+		case cur != nil:
+			fmt.Fprintf(os.Stderr, "(rego) require revert: drop %s\n", cur.Mod)
+			return after.DropRequire(m.Path)
+		}
+	case "replace":
+		if m.To != "" {
+			newPath, newVersion := m.To, ""
+			if i := strings.LastIndex(m.To, "@"); i >= 0 {
+				newPath, newVersion = m.To[:i], m.To[i+1:]
+			}
+			fmt.Fprintf(os.Stderr, "(rego) replace: %s %s -> %s\n", m.Path, m.Version, m.To)
+			return after.AddReplace(m.Path, m.Version, newPath, newVersion)
+		}
+		for _, r := range before.Replace {
+			if r.Old.Path == m.Path && r.Old.Version == m.Version {
+				fmt.Fprintf(os.Stderr, "(rego) replace revert: %s -> %s\n", r.Old, r.New)
+				return after.AddReplace(r.Old.Path, r.Old.Version, r.New.Path, r.New.Version)
+			}
+		}
+		fmt.Fprintf(os.Stderr, "(rego) replace revert: drop %s %s\n", m.Path, m.Version)
+		return after.DropReplace(m.Path, m.Version)
+	case "exclude":
+		for _, e := range before.Exclude {
+			if e.Mod.Path == m.Path && e.Mod.Version == m.Version {
+				fmt.Fprintf(os.Stderr, "(rego) exclude revert: %s\n", e.Mod)
+				return after.AddExclude(m.Path, m.Version)
+			}
+		}
+		fmt.Fprintf(os.Stderr, "(rego) exclude revert: drop %s %s\n", m.Path, m.Version)
+		return after.DropExclude(m.Path, m.Version)
+	default:
+		return fmt.Errorf("rego policy: cannot modify %q entries", m.Kind)
+	}
+	return nil
+}
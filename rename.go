@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+
+	"golang.org/x/mod/modfile"
+	"golang.org/x/mod/module"
+)
+
+// renameModule replaces the module path from with to in the requirements,
+// replacements (of the module, and to it) and exclusions of f, and, if
+// rename is set, in its module directive, renaming the module itself.
+// References of nested modules to a renamed module are fixed up without
+// renaming them.
+func renameModule(f *modfile.File, from, to string, rename bool, t trace) error {
+	if rename && f.Module.Mod.Path != to {
+		t.logf(to, "(module) rename: %s -> %s", f.Module.Mod.Path, to)
+		if err := f.AddModuleStmt(to); err != nil {
+			return err
+		}
+	}
+	if from == to {
+		return nil
+	}
+
+	// Dropping an entry clears it, so the entries are copied first.
+	var requires []modfile.Require
+	for _, r := range f.Require {
+		requires = append(requires, *r)
+	}
+	for _, r := range requires {
+		if r.Mod.Path != from {
+			continue
+		}
+		t.logf(to, "(module) require: %s -> %s", r.Mod, to)
+		if err := f.DropRequire(from); err != nil {
+			return err
+		}
+		f.AddNewRequire(to, r.Mod.Version, r.Indirect)
+	}
+
+	var replaces []modfile.Replace
+	for _, r := range f.Replace {
+		replaces = append(replaces, *r)
+	}
+	for _, r := range replaces {
+		old, target := r.Old.Path, r.New.Path
+		if old == from {
+			old = to
+		}
+		if r.New.Version != "" && target == from {
+			target = to
+		}
+		if old == r.Old.Path && target == r.New.Path {
+			continue
+		}
+		t.logf(to, "(module) replace: %s => %s -> %s => %s", r.Old, r.New, module.Version{Path: old, Version: r.Old.Version}, module.Version{Path: target, Version: r.New.Version})
+		if err := f.DropReplace(r.Old.Path, r.Old.Version); err != nil {
+			return err
+		}
+		if err := f.AddReplace(old, r.Old.Version, target, r.New.Version); err != nil {
+			return err
+		}
+	}
+
+	var excludes []module.Version
+	for _, e := range f.Exclude {
+		excludes = append(excludes, e.Mod)
+	}
+	for _, e := range excludes {
+		if e.Path != from {
+			continue
+		}
+		t.logf(to, "(module) exclude: %s -> %s@%s", e, to, e.Version)
+		if err := f.DropExclude(e.Path, e.Version); err != nil {
+			return err
+		}
+		if err := f.AddExclude(to, e.Version); err != nil {
+			return err
+		}
+	}
+	f.Cleanup()
+	f.SetRequire(f.Require)
+	return nil
+}
+
+// checkModulePath checks that path can be the path of a main module.
+func checkModulePath(path string) error {
+	if err := module.CheckImportPath(path); err != nil {
+		return fmt.Errorf("-set-module: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/mod/modfile"
+)
+
+// repoRoot returns the root of the repository containing dir: the closest
+// directory at or above it with a .git entry. It falls back to dir itself
+// outside a repository.
+func repoRoot(dir string) (string, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	for d := dir; ; {
+		if _, err := os.Stat(filepath.Join(d, ".git")); err == nil {
+			return d, nil
+		}
+		parent := filepath.Dir(d)
+		if parent == d {
+			return dir, nil
+		}
+		d = parent
+	}
+}
+
+// checkLocalReplaces reports the replacements of f with a filesystem target
+// outside the repository containing destFile. Such replacements only work in
+// the clone they were written in. If forbid is set, they fail the run.
+func checkLocalReplaces(f *modfile.File, destFile string, forbid bool) error {
+	destDir := filepath.Dir(destFile)
+	root, err := repoRoot(destDir)
+	if err != nil {
+		return err
+	}
+	var escaping []string
+	for _, r := range f.Replace {
+		if r.New.Version != "" || !modfile.IsDirectoryPath(r.New.Path) {
+			continue
+		}
+		target := r.New.Path
+		if !filepath.IsAbs(target) {
+			target = filepath.Join(destDir, target)
+		}
+		target, err := filepath.Abs(target)
+		if err != nil {
+			return err
+		}
+		if rel, err := filepath.Rel(root, target); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			continue
+		}
+		fmt.Fprintf(os.Stderr, "(replace) outside repository %s: %s => %s\n", root, r.Old, r.New.Path)
+		escaping = append(escaping, fmt.Sprintf("%s => %s", r.Old, r.New.Path))
+	}
+	if !forbid {
+		return nil
+	}
+	return gateError("%d replacement(s) point outside the repository:", escaping)
+}
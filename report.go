@@ -0,0 +1,74 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+
+	"github.com/brettbuddin/modtransplant/transplant"
+)
+
+// report describes a transplant to hooks and policies.
+type report struct {
+	// Stage is the point of the merge the report is made at: "pre-merge",
+	// "post-merge", "on-conflict" or "policy".
+	Stage   string   `json:"stage"`
+	Module  string   `json:"module"`
+	Dest    string   `json:"dest"`
+	Src     string   `json:"src"`
+	Changes []change `json:"changes"`
+	// Digests identify the files the merge was made from and produced.
+	Digests digests `json:"digests"`
+	// Deprecated and SrcDeprecated are the deprecation messages of the
+	// destination and source modules, if they are deprecated.
+	Deprecated    string `json:"deprecated,omitempty"`
+	SrcDeprecated string `json:"src_deprecated,omitempty"`
+	// Conflict is the conflict an on-conflict hook is run for.
+	Conflict *transplant.Conflict `json:"conflict,omitempty"`
+}
+
+// runHook runs a hook command with the shell, passing the report as JSON on
+// stdin. The hook's output goes to stderr, keeping stdout for the merged
+// go.mod. A failing hook vetoes the run.
+func runHook(ctx context.Context, command string, r report) error {
+	if command == "" {
+		return nil
+	}
+	if r.Changes == nil {
+		r.Changes = []change{}
+	}
+	input, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "(hook) %s: %s\n", r.Stage, command)
+	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	cmd.Env = append(os.Environ(), "MODTRANSPLANT_HOOK="+r.Stage)
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("%s hook vetoed the run: %w", r.Stage, err)
+	}
+	return nil
+}
+
+// hookResolver runs a hook for every conflict before deferring to the next
+// resolver, if any.
+type hookResolver struct {
+	hook func(context.Context, transplant.Conflict) error
+	next transplant.Resolver
+}
+
+func (r hookResolver) Resolve(ctx context.Context, c transplant.Conflict) (transplant.Resolution, error) {
+	if err := r.hook(ctx, c); err != nil {
+		return "", err
+	}
+	if r.next == nil {
+		return transplant.Highest, nil
+	}
+	return r.next.Resolve(ctx, c)
+}
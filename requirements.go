@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/mod/modfile"
+	"golang.org/x/mod/module"
+
+	"github.com/brettbuddin/modtransplant/transplant"
+)
+
+// requirementsModule is the module path of a requirements manifest that names
+// none. No module can require it, so merging the manifest drops nothing from
+// the destination.
+const requirementsModule = "requirements.invalid"
+
+// isRequirementsFile reports whether the source at path is a requirements
+// manifest, by its extension. vendor/modules.txt files are not.
+func isRequirementsFile(path string) bool {
+	if filepath.Base(path) == "modules.txt" {
+		return false
+	}
+	switch filepath.Ext(path) {
+	case ".txt", ".yaml", ".yml":
+		return true
+	}
+	return false
+}
+
+// parseRequirements builds a module file from a requirements manifest: a list
+// of module@version lines, like a requirements.txt, maintained as a curated
+// baseline outside of any go.mod. Lines may also be YAML list items ("- "
+// followed by a possibly quoted module@version) under a "requires:" key, so
+// the manifest can be kept as YAML. "#" starts a comment. A "module: <path>"
+// (or "module <path>") line names the module of the manifest. Every module
+// becomes a direct requirement.
+func parseRequirements(path string, content []byte) (*modfile.File, error) {
+	f := &modfile.File{Syntax: &modfile.FileSyntax{Name: path}}
+	modulePath := requirementsModule
+	seen := map[string]bool{}
+	for i, line := range strings.Split(string(content), "\n") {
+		if j := strings.Index(line, "#"); j >= 0 {
+			line = line[:j]
+		}
+		line = strings.TrimSpace(line)
+		switch {
+		case line == "" || line == "requires:" || line == "---":
+			continue
+		case strings.HasPrefix(line, "module:") || strings.HasPrefix(line, "module "):
+			rest := strings.TrimPrefix(line[len("module"):], ":")
+			modulePath = unquoteYAML(strings.TrimSpace(rest))
+			if err := module.CheckImportPath(modulePath); err != nil {
+				return nil, fmt.Errorf("%s:%d: %w", path, i+1, err)
+			}
+			continue
+		}
+		entry := unquoteYAML(strings.TrimSpace(strings.TrimPrefix(line, "- ")))
+		at := strings.LastIndex(entry, "@")
+		if at < 0 {
+			return nil, fmt.Errorf("%s:%d: expected module@version, got %q", path, i+1, entry)
+		}
+		mod := module.Version{Path: entry[:at], Version: entry[at+1:]}
+		if err := module.Check(mod.Path, mod.Version); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, i+1, err)
+		}
+		if seen[mod.Path] {
+			return nil, fmt.Errorf("%s:%d: %s is listed more than once", path, i+1, mod.Path)
+		}
+		seen[mod.Path] = true
+		f.AddNewRequire(mod.Path, mod.Version, false)
+	}
+	if err := f.AddModuleStmt(modulePath); err != nil {
+		return nil, err
+	}
+	f.SetRequire(f.Require)
+	return f, nil
+}
+
+// unquoteYAML removes the quotes around a YAML scalar, if any.
+func unquoteYAML(s string) string {
+	if len(s) >= 2 && (s[0] == '"' || s[0] == '\'') && s[len(s)-1] == s[0] {
+		return s[1 : len(s)-1]
+	}
+	return s
+}
+
+// runRequirements prints a requirements manifest of the destination's direct
+// requirements, for use as a baseline to transplant elsewhere. If a source is
+// given, the manifest describes the merged result instead.
+func runRequirements(ctx context.Context, args []string) error {
+	var (
+		destFile       string
+		srcFile        string
+		format         string
+		modulePath     string
+		forceOverwrite bool
+		configFile     string
+		netOpts        netOptions
+	)
+	fs := flag.NewFlagSet("modtransplant manifest", flag.ExitOnError)
+	fs.StringVar(&destFile, "dest", "", "destination go.mod file")
+	fs.StringVar(&srcFile, "src", "", "optional source module to merge before generating the manifest")
+	fs.StringVar(&format, "format", "text", "output format (text or yaml)")
+	fs.StringVar(&modulePath, "module", "", "module path to name in the manifest (none by default)")
+	fs.BoolVar(&forceOverwrite, "force-overwrite", false, "force overwrite of versions of matching module paths")
+	fs.StringVar(&configFile, "config", "", "JSON config file (e.g. with a conflict_policy)")
+	netOpts.register(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if destFile == "" {
+		return errors.New(usage)
+	}
+	var write func(io.Writer, string, []module.Version) error
+	switch format {
+	case "text":
+		write = writeRequirementsText
+	case "yaml":
+		write = writeRequirementsYAML
+	default:
+		return fmt.Errorf("unsupported format %q", format)
+	}
+	if modulePath != "" {
+		if err := module.CheckImportPath(modulePath); err != nil {
+			return err
+		}
+	}
+
+	dest, err := parseModFile(destFile)
+	if err != nil {
+		return err
+	}
+	if srcFile != "" {
+		cfg, err := loadConfig(configFile)
+		if err != nil {
+			return err
+		}
+		resolver, err := cfg.resolver()
+		if err != nil {
+			return err
+		}
+		src, err := loadSource(ctx, srcFile, &netOpts)
+		if err != nil {
+			return err
+		}
+		if err := setSourceModule(src, srcFile, ""); err != nil {
+			return err
+		}
+		if _, err := transplant.Merge(ctx, dest, src, transplant.WithForceOverwrite(forceOverwrite), transplant.WithResolver(resolver)); err != nil {
+			return err
+		}
+	}
+
+	var mods []module.Version
+	for _, r := range dest.Require {
+		if r.Indirect {
+			continue
+		}
+		if rep := replacedModule(dest, r.Mod.Path, r.Mod.Version); rep.Path != "" {
+			fmt.Fprintf(os.Stderr, "(manifest) %s is replaced with %s, which the manifest doesn't record\n", r.Mod, rep)
+		}
+		mods = append(mods, r.Mod)
+	}
+	module.Sort(mods)
+	return write(os.Stdout, modulePath, mods)
+}
+
+// writeRequirementsText prints a requirements manifest with a module@version
+// per line.
+func writeRequirementsText(w io.Writer, modulePath string, mods []module.Version) error {
+	var b strings.Builder
+	if modulePath != "" {
+		fmt.Fprintf(&b, "module %s\n", modulePath)
+	}
+	for _, m := range mods {
+		fmt.Fprintf(&b, "%s@%s\n", m.Path, m.Version)
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// writeRequirementsYAML prints a requirements manifest as YAML, with the
+// modules under a "requires:" key.
+func writeRequirementsYAML(w io.Writer, modulePath string, mods []module.Version) error {
+	var b strings.Builder
+	if modulePath != "" {
+		fmt.Fprintf(&b, "module: %s\n", modulePath)
+	}
+	b.WriteString("requires:\n")
+	for _, m := range mods {
+		fmt.Fprintf(&b, "  - %s@%s\n", m.Path, m.Version)
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
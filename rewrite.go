@@ -0,0 +1,172 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"golang.org/x/mod/modfile"
+	"golang.org/x/mod/module"
+)
+
+// rewriteRule is a sed-style substitution of module paths,
+// s<d><regexp><d><replacement><d> for any delimiter d.
+type rewriteRule struct {
+	expr string
+	re   *regexp.Regexp
+	repl string
+}
+
+// parseRewriteRule parses a substitution like s#^github.com/old/#github.com/new/#.
+// The replacement may refer to submatches of the regexp as in
+// regexp.Regexp.Expand ($1, ${name}).
+func parseRewriteRule(expr string) (rewriteRule, error) {
+	if len(expr) < 4 || expr[0] != 's' {
+		return rewriteRule{}, fmt.Errorf("invalid rewrite rule %q: want s#regexp#replacement#", expr)
+	}
+	d := expr[1:2]
+	parts := strings.Split(expr[2:], d)
+	if len(parts) != 3 || parts[2] != "" {
+		return rewriteRule{}, fmt.Errorf("invalid rewrite rule %q: want s%sregexp%sreplacement%s", expr, d, d, d)
+	}
+	re, err := regexp.Compile(parts[0])
+	if err != nil {
+		return rewriteRule{}, fmt.Errorf("invalid rewrite rule %q: %w", expr, err)
+	}
+	return rewriteRule{expr: expr, re: re, repl: parts[1]}, nil
+}
+
+// rewriteRules are the -rewrite rules, applied in order. It implements
+// flag.Value, so the flag can be given several times.
+type rewriteRules []rewriteRule
+
+func (rs *rewriteRules) String() string {
+	if rs == nil {
+		return ""
+	}
+	exprs := make([]string, len(*rs))
+	for i, r := range *rs {
+		exprs[i] = r.expr
+	}
+	return strings.Join(exprs, " ")
+}
+
+func (rs *rewriteRules) Set(expr string) error {
+	r, err := parseRewriteRule(expr)
+	if err != nil {
+		return err
+	}
+	*rs = append(*rs, r)
+	return nil
+}
+
+// apply returns path rewritten by every rule in turn.
+func (rs rewriteRules) apply(path string) string {
+	for _, r := range rs {
+		path = r.re.ReplaceAllString(path, r.repl)
+	}
+	return path
+}
+
+// rewrite is a module path changed by the -rewrite rules.
+type rewrite struct {
+	// Kind is the kind of entry the path is in: require, replace (for the
+	// replaced module), replace-target or exclude.
+	Kind string `json:"kind"`
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// rewritePaths rewrites the module paths of the requirements, replacements
+// and exclusions of src, and the targets of its module replacements, with
+// rules. Directory replacement targets are left alone. Every rewrite is
+// logged and returned; a rule producing an invalid module path, or two paths
+// rewritten to the same one, is an error.
+func rewritePaths(src *modfile.File, rules rewriteRules, t trace) ([]rewrite, error) {
+	var rewrites []rewrite
+	rewritten := func(kind, path string) (string, error) {
+		to := rules.apply(path)
+		if to == path {
+			return path, nil
+		}
+		if err := module.CheckPath(to); err != nil {
+			return "", fmt.Errorf("rewrite %s: %w", path, err)
+		}
+		t.logf(to, "(rewrite) %s: %s -> %s", kind, path, to)
+		rewrites = append(rewrites, rewrite{Kind: kind, From: path, To: to})
+		return to, nil
+	}
+
+	// Dropping an entry clears it, so the entries are copied first.
+	var requires []modfile.Require
+	for _, r := range src.Require {
+		requires = append(requires, *r)
+	}
+	from := map[string]string{}
+	for _, r := range requires {
+		to, err := rewritten("require", r.Mod.Path)
+		if err != nil {
+			return nil, err
+		}
+		if prev, ok := from[to]; ok {
+			return nil, fmt.Errorf("rewrite: %s and %s both become %s", prev, r.Mod.Path, to)
+		}
+		from[to] = r.Mod.Path
+		if to == r.Mod.Path {
+			continue
+		}
+		if err := src.DropRequire(r.Mod.Path); err != nil {
+			return nil, err
+		}
+		src.AddNewRequire(to, r.Mod.Version, r.Indirect)
+	}
+
+	var replaces []modfile.Replace
+	for _, r := range src.Replace {
+		replaces = append(replaces, *r)
+	}
+	for _, r := range replaces {
+		old, err := rewritten("replace", r.Old.Path)
+		if err != nil {
+			return nil, err
+		}
+		target := r.New.Path
+		if r.New.Version != "" {
+			if target, err = rewritten("replace-target", r.New.Path); err != nil {
+				return nil, err
+			}
+		}
+		if old == r.Old.Path && target == r.New.Path {
+			continue
+		}
+		if err := src.DropReplace(r.Old.Path, r.Old.Version); err != nil {
+			return nil, err
+		}
+		if err := src.AddReplace(old, r.Old.Version, target, r.New.Version); err != nil {
+			return nil, err
+		}
+	}
+
+	var excludes []module.Version
+	for _, e := range src.Exclude {
+		excludes = append(excludes, e.Mod)
+	}
+	for _, e := range excludes {
+		path, err := rewritten("exclude", e.Path)
+		if err != nil {
+			return nil, err
+		}
+		if path == e.Path {
+			continue
+		}
+		if err := src.DropExclude(e.Path, e.Version); err != nil {
+			return nil, err
+		}
+		if err := src.AddExclude(path, e.Version); err != nil {
+			return nil, err
+		}
+	}
+	src.Cleanup()
+	src.SetRequire(src.Require)
+	return rewrites, nil
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/brettbuddin/modtransplant/transplant"
+)
+
+// JSON-RPC 2.0 error codes.
+const (
+	rpcParseError     = -32700
+	rpcInvalidRequest = -32600
+	rpcMethodNotFound = -32601
+	rpcInvalidParams  = -32602
+	rpcServerError    = -32000
+)
+
+type rpcRequest struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id,omitempty"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params"`
+}
+
+type rpcResponse struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  interface{}     `json:"result,omitempty"`
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+type rpcError struct {
+	Code    int         `json:"code"`
+	Message string      `json:"message"`
+	Data    interface{} `json:"data,omitempty"`
+}
+
+// rpcMergeParams are the parameters of the merge, check and plan methods. They
+// mirror the flags of a merge.
+type rpcMergeParams struct {
+	Dest            string   `json:"dest"`
+	Src             string   `json:"src"`
+	Config          string   `json:"config"`
+	Write           bool     `json:"write"`
+	ForceOverwrite  bool     `json:"force_overwrite"`
+	AddOnly         bool     `json:"add_only"`
+	OrgPrefix       string   `json:"org_prefix"`
+	SetModule       string   `json:"set_module"`
+	PruneReplaces   bool     `json:"prune_replaces"`
+	PruneExcludes   bool     `json:"prune_excludes"`
+	FailOnDowngrade bool     `json:"fail_on_downgrade"`
+	FailOnNewDep    bool     `json:"fail_on_new_dep"`
+	NewDepPaths     string   `json:"new_dep_paths"`
+	Freeze          string   `json:"freeze"`
+	Latest          bool     `json:"latest"`
+	SameMajor       bool     `json:"same_major"`
+	Deep            bool     `json:"deep"`
+	Rewrite         []string `json:"rewrite"`
+	AutoPatch       bool     `json:"auto_patch"`
+	NoHistory       bool     `json:"no_history"`
+}
+
+// rpcMergeResult is the result of the merge and plan methods.
+type rpcMergeResult struct {
+	Module  string   `json:"module"`
+	Changes []change `json:"changes"`
+	Digests digests  `json:"digests"`
+	Written bool     `json:"written"`
+	// Deprecated and SrcDeprecated are the deprecation messages of the
+	// destination and source modules, if they are deprecated.
+	Deprecated    string `json:"deprecated,omitempty"`
+	SrcDeprecated string `json:"src_deprecated,omitempty"`
+	// Output is the merged file, unless it was written.
+	Output string `json:"output,omitempty"`
+	// Edits are the edits turning the destination into the merged file.
+	Edits json.RawMessage `json:"edits,omitempty"`
+}
+
+// rpcCheckResult is the result of the check method.
+type rpcCheckResult struct {
+	OK       bool     `json:"ok"`
+	Error    string   `json:"error,omitempty"`
+	Problems []string `json:"problems,omitempty"`
+	Changes  []change `json:"changes"`
+}
+
+// runServe serves JSON-RPC 2.0 requests on stdin, one per line, writing one
+// response per line to stdout, until stdin is closed. Keeping a single process
+// around lets tooling reuse its caches across requests.
+func runServe(ctx context.Context, args []string) error {
+	var netOpts netOptions
+	fs := flag.NewFlagSet("modtransplant serve", flag.ExitOnError)
+	netOpts.register(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	return serveRPC(ctx, os.Stdin, os.Stdout, &netOpts)
+}
+
+func serveRPC(ctx context.Context, r io.Reader, w io.Writer, netOpts *netOptions) error {
+	sc := bufio.NewScanner(r)
+	sc.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	enc := json.NewEncoder(w)
+	for sc.Scan() {
+		if len(bytes.TrimSpace(sc.Bytes())) == 0 {
+			continue
+		}
+		var req rpcRequest
+		if err := json.Unmarshal(sc.Bytes(), &req); err != nil {
+			if err := enc.Encode(rpcResponse{JSONRPC: "2.0", ID: json.RawMessage("null"), Error: &rpcError{Code: rpcParseError, Message: err.Error()}}); err != nil {
+				return err
+			}
+			continue
+		}
+		result, rerr := handleRPC(ctx, req, netOpts)
+		if errors.Is(ctx.Err(), context.Canceled) {
+			return ctx.Err()
+		}
+		// Requests without an ID are notifications, which get no response.
+		if req.ID == nil {
+			continue
+		}
+		if err := enc.Encode(rpcResponse{JSONRPC: "2.0", ID: req.ID, Result: result, Error: rerr}); err != nil {
+			return err
+		}
+	}
+	return sc.Err()
+}
+
+func handleRPC(ctx context.Context, req rpcRequest, netOpts *netOptions) (interface{}, *rpcError) {
+	if req.JSONRPC != "2.0" || req.Method == "" {
+		return nil, &rpcError{Code: rpcInvalidRequest, Message: "invalid JSON-RPC 2.0 request"}
+	}
+	switch req.Method {
+	case "merge", "check", "plan":
+	default:
+		return nil, &rpcError{Code: rpcMethodNotFound, Message: "unknown method " + req.Method}
+	}
+
+	var p rpcMergeParams
+	if err := json.Unmarshal(req.Params, &p); err != nil {
+		return nil, &rpcError{Code: rpcInvalidParams, Message: err.Error()}
+	}
+	if p.Dest == "" || p.Src == "" {
+		return nil, &rpcError{Code: rpcInvalidParams, Message: "dest and src are required"}
+	}
+	if p.Dest == stdinDest {
+		return nil, &rpcError{Code: rpcInvalidParams, Message: "dest must be a file"}
+	}
+
+	srcOpts := sourceOptions{latest: p.Latest, sameMajor: p.SameMajor, deep: p.Deep}
+	for _, expr := range p.Rewrite {
+		if err := srcOpts.rewrites.Set(expr); err != nil {
+			return nil, &rpcError{Code: rpcInvalidParams, Message: err.Error()}
+		}
+	}
+
+	var out bytes.Buffer
+	opts := mergeOptions{
+		forceOverwrite:  p.ForceOverwrite,
+		addOnly:         p.AddOnly,
+		orgPrefix:       p.OrgPrefix,
+		setModule:       p.SetModule,
+		pruneReplaces:   p.PruneReplaces,
+		pruneExcludes:   p.PruneExcludes,
+		autoPatch:       p.AutoPatch,
+		osvURL:          defaultOSVURL,
+		bzlMacroName:    "go_dependencies",
+		write:           req.Method == "merge" && p.Write,
+		emit:            emitFile,
+		noHistory:       p.NoHistory || req.Method != "merge",
+		results:         new([]fileResult),
+		output:          &out,
+		failOnDowngrade: p.FailOnDowngrade,
+		failOnNewDep:    p.FailOnNewDep,
+		newDepPaths:     p.NewDepPaths,
+		freeze:          p.Freeze,
+	}
+	if req.Method != "merge" {
+		opts.emit = emitEdits
+	}
+	if err := opts.check(srcOpts); err != nil {
+		return nil, &rpcError{Code: rpcInvalidParams, Message: err.Error()}
+	}
+	err := func() error {
+		src, err := opts.prepare(ctx, p.Config, p.Src, netOpts, srcOpts)
+		if err != nil {
+			return err
+		}
+		return mergeInto(ctx, p.Dest, src, &opts)
+	}()
+	var result fileResult
+	if len(*opts.results) > 0 {
+		result = (*opts.results)[0]
+	}
+	changes := result.Changes
+	if changes == nil {
+		changes = []change{}
+	}
+
+	if req.Method == "check" {
+		res := rpcCheckResult{OK: err == nil, Changes: changes}
+		if err != nil {
+			res.Error = err.Error()
+			res.Problems = problems(err)
+		}
+		return res, nil
+	}
+	if err != nil {
+		return nil, &rpcError{Code: rpcServerError, Message: err.Error(), Data: problems(err)}
+	}
+	res := rpcMergeResult{Module: result.Module, Changes: changes, Digests: result.Digests, Written: result.Written, Deprecated: result.Deprecated, SrcDeprecated: result.SrcDeprecated}
+	switch {
+	case req.Method == "plan":
+		res.Edits = json.RawMessage(out.Bytes())
+	case !res.Written:
+		res.Output = strings.TrimSuffix(out.String(), "\n")
+	}
+	return res, nil
+}
+
+// problems lists the problems of a failed merge, if err reports any.
+func problems(err error) []string {
+	var merr *transplant.MergeError
+	if !errors.As(err, &merr) {
+		return nil
+	}
+	var list []string
+	for _, p := range merr.Problems {
+		list = append(list, p.String())
+	}
+	return list
+}
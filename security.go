@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"golang.org/x/mod/modfile"
+	"golang.org/x/mod/semver"
+)
+
+const defaultOSVURL = "https://api.osv.dev"
+
+// osvBatchSize is the maximum number of queries the OSV API accepts per batch.
+const osvBatchSize = 1000
+
+// filterSecurityFixes reduces src to the requirement upgrades that fix a known
+// OSV advisory affecting the destination's current version. Everything else,
+// including new modules, replacements, exclusions and ignored directories, is
+// dropped, so the merge only raises versions for security reasons. Upgraded
+// modules keep their indirect marker from the destination.
+func filterSecurityFixes(ctx context.Context, dest, src *modfile.File, opts *netOptions, osvURL string, t trace) error {
+	destVersions := map[string]*modfile.Require{}
+	for _, r := range dest.Require {
+		destVersions[r.Mod.Path] = r
+	}
+	var candidates []*modfile.Require
+	var queries []osvQuery
+	for _, r := range src.Require {
+		destR, ok := destVersions[r.Mod.Path]
+		if !ok || semver.Compare(r.Mod.Version, destR.Mod.Version) <= 0 {
+			continue
+		}
+		candidates = append(candidates, r)
+		queries = append(queries, newOSVQuery(r.Mod.Path, destR.Mod.Version), newOSVQuery(r.Mod.Path, r.Mod.Version))
+	}
+
+	results, err := queryOSV(ctx, newHTTPClient(opts), osvURL, queries)
+	if err != nil {
+		return err
+	}
+
+	keep := map[*modfile.Require]bool{}
+	for i, r := range candidates {
+		destR := destVersions[r.Mod.Path]
+		fixed := results[2*i].minus(results[2*i+1])
+		if len(fixed) == 0 {
+			t.logf(r.Mod.Path, "(security) keep: %s (%s fixes no advisory)", destR.Mod, r.Mod.Version)
+			continue
+		}
+		t.logf(r.Mod.Path, "(security) upgrade: %s %s -> %s (fixes %s)", r.Mod.Path, destR.Mod.Version, r.Mod.Version, strings.Join(fixed, ", "))
+		r.Indirect = destR.Indirect
+		keep[r] = true
+	}
+	for _, r := range src.Require {
+		if !keep[r] {
+			src.DropRequire(r.Mod.Path)
+		}
+	}
+	src.Replace = nil
+	src.Exclude = nil
+	src.Ignore = nil
+	src.Cleanup()
+	src.SetRequire(src.Require)
+	return nil
+}
+
+type osvPackage struct {
+	Name      string `json:"name"`
+	Ecosystem string `json:"ecosystem"`
+}
+
+type osvQuery struct {
+	Package osvPackage `json:"package"`
+	Version string     `json:"version"`
+}
+
+// newOSVQuery asks for the advisories affecting path@version. The OSV Go
+// ecosystem writes versions without the "v" prefix.
+func newOSVQuery(path, version string) osvQuery {
+	return osvQuery{
+		Package: osvPackage{Name: path, Ecosystem: "Go"},
+		Version: strings.TrimPrefix(version, "v"),
+	}
+}
+
+// osvVulns is the set of advisory IDs affecting a module version.
+type osvVulns []string
+
+// minus returns the IDs of v that aren't in other, sorted.
+func (v osvVulns) minus(other osvVulns) []string {
+	seen := map[string]bool{}
+	for _, id := range other {
+		seen[id] = true
+	}
+	var ids []string
+	for _, id := range v {
+		if !seen[id] {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// queryOSV runs queries against the OSV batch API and returns the advisories
+// found for each, in order.
+func queryOSV(ctx context.Context, client *http.Client, baseURL string, queries []osvQuery) ([]osvVulns, error) {
+	var results []osvVulns
+	for len(queries) > 0 {
+		batch := queries
+		if len(batch) > osvBatchSize {
+			batch = batch[:osvBatchSize]
+		}
+		queries = queries[len(batch):]
+
+		body, err := json.Marshal(struct {
+			Queries []osvQuery `json:"queries"`
+		}{batch})
+		if err != nil {
+			return nil, err
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(baseURL, "/")+"/v1/querybatch", bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("OSV query: %w", err)
+		}
+		var out struct {
+			Results []struct {
+				Vulns []struct {
+					ID string `json:"id"`
+				} `json:"vulns"`
+			} `json:"results"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&out)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("OSV query: %s", resp.Status)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("OSV query: %w", err)
+		}
+		if len(out.Results) != len(batch) {
+			return nil, fmt.Errorf("OSV query: got %d results for %d queries", len(out.Results), len(batch))
+		}
+		for _, r := range out.Results {
+			var ids osvVulns
+			for _, v := range r.Vulns {
+				ids = append(ids, v.ID)
+			}
+			results = append(results, ids)
+		}
+	}
+	return results, nil
+}
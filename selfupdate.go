@@ -0,0 +1,299 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"strings"
+
+	"golang.org/x/mod/semver"
+)
+
+const (
+	// defaultReleasesURL is the GitHub API endpoint of the project's latest
+	// release.
+	defaultReleasesURL = "https://api.github.com/repos/brettbuddin/modtransplant/releases/latest"
+	// releasesURLEnv names the environment variable overriding it, so a
+	// fleet of machines can be pointed at an internal mirror centrally.
+	releasesURLEnv = "MODTRANSPLANT_RELEASES_URL"
+	// checksumsAsset is the release asset listing the SHA-256 checksums of
+	// the binaries, in the format of sha256sum.
+	checksumsAsset = "checksums.txt"
+)
+
+// release is a release as described by the GitHub API. Mirrors serve a
+// document of the same shape.
+type release struct {
+	Tag    string         `json:"tag_name"`
+	Assets []releaseAsset `json:"assets"`
+}
+
+type releaseAsset struct {
+	Name string `json:"name"`
+	URL  string `json:"browser_download_url"`
+}
+
+// asset returns the URL of the asset named name.
+func (r release) asset(name string) (string, error) {
+	for _, a := range r.Assets {
+		if a.Name == name {
+			return a.URL, nil
+		}
+	}
+	return "", fmt.Errorf("release %s has no asset %s", r.Tag, name)
+}
+
+// binaryAsset returns the name of the release asset of the binary for the
+// running platform.
+func binaryAsset() string {
+	name := fmt.Sprintf("modtransplant_%s_%s", runtime.GOOS, runtime.GOARCH)
+	if runtime.GOOS == "windows" {
+		name += ".exe"
+	}
+	return name
+}
+
+// currentVersion returns the module version the running binary was built
+// from, or "(devel)" if it wasn't built from a released module.
+func currentVersion() string {
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" {
+		return info.Main.Version
+	}
+	return "(devel)"
+}
+
+// runSelfUpdate replaces the running binary with the latest release, after
+// verifying its checksum and, if a key or signer identity is given, the
+// signature of the checksums.
+func runSelfUpdate(ctx context.Context, args []string) error {
+	var (
+		releasesURL string
+		check       bool
+		force       bool
+		key         string
+		identity    string
+		issuer      string
+		netOpts     netOptions
+	)
+	defaultURL := os.Getenv(releasesURLEnv)
+	if defaultURL == "" {
+		defaultURL = defaultReleasesURL
+	}
+	fs := flag.NewFlagSet("modtransplant self-update", flag.ExitOnError)
+	fs.StringVar(&releasesURL, "url", defaultURL, "URL of the latest release, as described by the GitHub API (defaults to $"+releasesURLEnv+" or the project's GitHub releases)")
+	fs.BoolVar(&check, "check", false, "only report whether a newer release is available")
+	fs.BoolVar(&force, "force", false, "install the latest release even if it isn't newer, or the running binary is a development build")
+	fs.StringVar(&key, "key", "", "public key the checksums were signed with (key-based signing)")
+	fs.StringVar(&identity, "certificate-identity", "", "identity of the signer of the checksums (keyless signing)")
+	fs.StringVar(&issuer, "certificate-oidc-issuer", "", "OIDC issuer of the signer's identity (keyless signing)")
+	netOpts.register(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return errors.New(usage)
+	}
+	if key != "" && identity != "" {
+		return errors.New("-key can't be combined with -certificate-identity")
+	}
+	if (identity == "") != (issuer == "") {
+		return errors.New("-certificate-identity and -certificate-oidc-issuer must be given together")
+	}
+
+	client := newHTTPClient(&netOpts)
+	data, err := download(ctx, client, releasesURL)
+	if err != nil {
+		return err
+	}
+	var latest release
+	if err := json.Unmarshal(data, &latest); err != nil {
+		return fmt.Errorf("%s: %w", releasesURL, err)
+	}
+	if !semver.IsValid(latest.Tag) {
+		return fmt.Errorf("%s: invalid release tag %q", releasesURL, latest.Tag)
+	}
+
+	current := currentVersion()
+	newer := !semver.IsValid(current) || semver.Compare(latest.Tag, current) > 0
+	switch {
+	case check && newer:
+		fmt.Printf("%s is available (running %s)\n", latest.Tag, current)
+		return nil
+	case check:
+		fmt.Printf("%s is the latest release\n", current)
+		return nil
+	case !semver.IsValid(current) && !force:
+		return fmt.Errorf("the running binary is a %s build; use -force to replace it with %s", current, latest.Tag)
+	case !newer && !force:
+		fmt.Fprintf(os.Stderr, "(self-update) %s is the latest release\n", current)
+		return nil
+	}
+
+	binaryURL, err := latest.asset(binaryAsset())
+	if err != nil {
+		return err
+	}
+	checksumsURL, err := latest.asset(checksumsAsset)
+	if err != nil {
+		return err
+	}
+	checksums, err := download(ctx, client, checksumsURL)
+	if err != nil {
+		return err
+	}
+	if key != "" || identity != "" {
+		if err := verifyChecksumsSignature(ctx, client, latest, checksums, key, identity, issuer); err != nil {
+			return err
+		}
+	} else {
+		fmt.Fprintln(os.Stderr, "(self-update) signature not verified: no -key or -certificate-identity given")
+	}
+	want, err := releaseChecksum(checksums, binaryAsset())
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "(self-update) download: %s\n", binaryURL)
+	binary, err := download(ctx, client, binaryURL)
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(binary)
+	if got := hex.EncodeToString(sum[:]); got != want {
+		return fmt.Errorf("%s: checksum %s, %s says %s", binaryAsset(), got, checksumsAsset, want)
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	if exe, err = filepath.EvalSymlinks(exe); err != nil {
+		return err
+	}
+	if err := replaceExecutable(exe, binary); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "(self-update) updated %s from %s to %s\n", exe, current, latest.Tag)
+	return nil
+}
+
+// download retrieves a URL, failing on any response but 200 OK.
+func download(ctx context.Context, client *http.Client, rawurl string) ([]byte, error) {
+	resp, err := httpGet(ctx, client, rawurl)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("%s: %s", rawurl, resp.Status)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+// verifyChecksumsSignature verifies the Sigstore bundle of the checksums of r
+// with cosign.
+func verifyChecksumsSignature(ctx context.Context, client *http.Client, r release, checksums []byte, key, identity, issuer string) error {
+	bundleURL, err := r.asset(bundleFile(checksumsAsset))
+	if err != nil {
+		return err
+	}
+	bundle, err := download(ctx, client, bundleURL)
+	if err != nil {
+		return err
+	}
+	dir, err := ioutil.TempDir("", "modtransplant-self-update")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	checksumsFile := filepath.Join(dir, checksumsAsset)
+	if err := ioutil.WriteFile(checksumsFile, checksums, 0o644); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(bundleFile(checksumsFile), bundle, 0o644); err != nil {
+		return err
+	}
+	args := []string{"verify-blob", "--bundle", bundleFile(checksumsFile)}
+	if key != "" {
+		args = append(args, "--key", key)
+	} else {
+		args = append(args, "--certificate-identity", identity, "--certificate-oidc-issuer", issuer)
+	}
+	if err := cosign(ctx, append(args, checksumsFile)...); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "(self-update) signature verified: %s\n", checksumsAsset)
+	return nil
+}
+
+// releaseChecksum returns the hex SHA-256 checksum of the asset named name in
+// checksums, a file in the format of sha256sum.
+func releaseChecksum(checksums []byte, name string) (string, error) {
+	s := bufio.NewScanner(bytes.NewReader(checksums))
+	for s.Scan() {
+		fields := strings.Fields(s.Text())
+		if len(fields) == 2 && strings.TrimPrefix(fields[1], "*") == name {
+			return strings.ToLower(fields[0]), nil
+		}
+	}
+	if err := s.Err(); err != nil {
+		return "", err
+	}
+	return "", fmt.Errorf("%s has no checksum of %s", checksumsAsset, name)
+}
+
+// replaceExecutable replaces the executable at path with content, keeping its
+// permissions. The new binary is written next to it and renamed over it, so
+// the executable is never left half-written. Windows can't replace a running
+// executable, but it can rename it, so there the old binary is moved aside
+// first.
+func replaceExecutable(path string, content []byte) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".new")
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+	_, err = f.Write(content)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(tmp, info.Mode().Perm())
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	var old string
+	if runtime.GOOS == "windows" {
+		old = path + ".old"
+		os.Remove(old)
+		if err := os.Rename(path, old); err != nil {
+			os.Remove(tmp)
+			return err
+		}
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		if old != "" {
+			os.Rename(old, path)
+		}
+		return err
+	}
+	return nil
+}
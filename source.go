@@ -0,0 +1,292 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"debug/buildinfo"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"strings"
+
+	"golang.org/x/mod/modfile"
+	"golang.org/x/mod/module"
+)
+
+// loadSource reads the source module from path. Besides go.mod files, the
+// source may be a compiled Go binary, in which case the module list embedded
+// in its build information is used, a vendor/modules.txt file, a module zip
+// or release tarball containing the go.mod file, or (experimentally) an oci://
+// container image reference whose Go binaries are inspected. A module@version
+// query names a module whose go.mod is fetched through GOPROXY, a directory a
+// tree of modules whose requirements are combined, and a .txt, .yaml or .yml
+// file a requirements manifest of module@version lines.
+func loadSource(ctx context.Context, path string, opts *netOptions) (*modfile.File, error) {
+	if strings.HasPrefix(path, "oci://") {
+		return loadImageSource(ctx, strings.TrimPrefix(path, "oci://"), opts)
+	}
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) && strings.Contains(path, "@") {
+		return loadRemoteSource(ctx, path, opts)
+	}
+	if err == nil && info.IsDir() {
+		f, _, err := loadSourceTree(path, nil, nil)
+		return f, err
+	}
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case filepath.Base(path) == "modules.txt":
+		return parseVendorModules(path, content)
+	case isExecutable(content):
+		return parseBuildInfo(path, content)
+	case bytes.HasPrefix(content, []byte("PK\x03\x04")):
+		return parseZipGoMod(path, content)
+	case bytes.HasPrefix(content, []byte{0x1f, 0x8b}):
+		zr, err := gzip.NewReader(bytes.NewReader(content))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		return parseTarGoMod(path, zr)
+	case len(content) > 262 && string(content[257:262]) == "ustar":
+		return parseTarGoMod(path, bytes.NewReader(content))
+	case isRequirementsFile(path):
+		return parseRequirements(path, content)
+	}
+	return modfile.Parse(path, content, nil)
+}
+
+// setSourceModule makes modulePath, if given, the module path of the source
+// loaded from srcFile, and otherwise makes sure the source has one.
+func setSourceModule(src *modfile.File, srcFile, modulePath string) error {
+	if modulePath != "" {
+		if err := module.CheckImportPath(modulePath); err != nil {
+			return fmt.Errorf("-src-module: %w", err)
+		}
+		return src.AddModuleStmt(modulePath)
+	}
+	if src.Module == nil || src.Module.Mod.Path == "" {
+		return fmt.Errorf("%s has no module directive; give the source's module path with -src-module", srcFile)
+	}
+	return nil
+}
+
+// parseVendorModules builds a module file from a vendor/modules.txt file.
+// Modules marked "## explicit" become direct requirements and the rest
+// indirect ones. Since modules.txt doesn't name the main module, its path is
+// taken from the go.mod file next to the vendor directory.
+func parseVendorModules(path string, content []byte) (*modfile.File, error) {
+	modPath := filepath.Join(filepath.Dir(filepath.Dir(path)), "go.mod")
+	modContent, err := ioutil.ReadFile(modPath)
+	if err != nil {
+		return nil, fmt.Errorf("%s: cannot determine module path: %w", path, err)
+	}
+	mainPath := modfile.ModulePath(modContent)
+	if mainPath == "" {
+		return nil, fmt.Errorf("%s: cannot determine module path: no module directive in %s", path, modPath)
+	}
+
+	f := &modfile.File{Syntax: &modfile.FileSyntax{}}
+	if err := f.AddModuleStmt(mainPath); err != nil {
+		return nil, err
+	}
+
+	var current *modfile.Require
+	for i, line := range strings.Split(string(content), "\n") {
+		switch {
+		case strings.HasPrefix(line, "## "):
+			if current == nil {
+				continue
+			}
+			for _, marker := range strings.Split(strings.TrimPrefix(line, "## "), ";") {
+				if strings.TrimSpace(marker) == "explicit" {
+					current.Indirect = false
+				}
+			}
+		case strings.HasPrefix(line, "# "):
+			current = nil
+			fields := strings.Fields(strings.TrimPrefix(line, "# "))
+			old, repl := fields, []string(nil)
+			for j, field := range fields {
+				if field == "=>" {
+					old, repl = fields[:j], fields[j+1:]
+					break
+				}
+			}
+			if len(old) < 1 || len(old) > 2 || repl != nil && (len(repl) < 1 || len(repl) > 2) {
+				return nil, fmt.Errorf("%s:%d: malformed module line: %s", path, i+1, line)
+			}
+			if len(old) == 2 {
+				f.AddNewRequire(old[0], old[1], true)
+				current = f.Require[len(f.Require)-1]
+			}
+			if repl != nil {
+				oldVersion := ""
+				if len(old) == 2 {
+					oldVersion = old[1]
+				}
+				newVersion := ""
+				if len(repl) == 2 {
+					newVersion = repl[1]
+				}
+				if err := f.AddReplace(old[0], oldVersion, repl[0], newVersion); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	f.SetRequire(f.Require)
+	return f, nil
+}
+
+var errNoGoMod = errors.New("no go.mod file found in archive")
+
+// parseZipGoMod parses the root go.mod file of a module zip, as served by a
+// module proxy.
+func parseZipGoMod(path string, content []byte) (*modfile.File, error) {
+	zr, err := zip.NewReader(bytes.NewReader(content), int64(len(content)))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	var root *zip.File
+	for _, f := range zr.File {
+		if root == nil && isGoModEntry(f.Name) || root != nil && isShallowerGoMod(f.Name, root.Name) {
+			root = f
+		}
+	}
+	if root == nil {
+		return nil, fmt.Errorf("%s: %w", path, errNoGoMod)
+	}
+	rc, err := root.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	data, err := ioutil.ReadAll(rc)
+	if err != nil {
+		return nil, err
+	}
+	return modfile.Parse(path+":"+root.Name, data, nil)
+}
+
+// parseTarGoMod parses the root go.mod file of a release tarball.
+func parseTarGoMod(path string, r io.Reader) (*modfile.File, error) {
+	var (
+		name string
+		data []byte
+	)
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		if hdr.Typeflag != tar.TypeReg || !isGoModEntry(hdr.Name) || data != nil && !isShallowerGoMod(hdr.Name, name) {
+			continue
+		}
+		if data, err = ioutil.ReadAll(tr); err != nil {
+			return nil, err
+		}
+		name = hdr.Name
+	}
+	if data == nil {
+		return nil, fmt.Errorf("%s: %w", path, errNoGoMod)
+	}
+	return modfile.Parse(path+":"+name, data, nil)
+}
+
+func isGoModEntry(name string) bool {
+	return name == "go.mod" || strings.HasSuffix(name, "/go.mod")
+}
+
+// isShallowerGoMod reports whether the go.mod entry name is closer to the
+// archive root than best. The shallowest one is the module's own go.mod rather
+// than one of a nested module or testdata.
+func isShallowerGoMod(name, best string) bool {
+	return isGoModEntry(name) && strings.Count(name, "/") < strings.Count(best, "/")
+}
+
+// isExecutable reports whether content starts with the magic number of one of
+// the executable formats the Go toolchain produces.
+func isExecutable(content []byte) bool {
+	for _, magic := range [][]byte{
+		[]byte("\x7fELF"),
+		[]byte("MZ"),
+		[]byte("\x00asm"),
+		{0xfe, 0xed, 0xfa, 0xce},
+		{0xfe, 0xed, 0xfa, 0xcf},
+		{0xce, 0xfa, 0xed, 0xfe},
+		{0xcf, 0xfa, 0xed, 0xfe},
+	} {
+		if bytes.HasPrefix(content, magic) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseBuildInfo builds a module file from the build information embedded in
+// a Go binary. The binary doesn't record which dependencies were direct, so
+// every requirement is marked indirect.
+func parseBuildInfo(path string, content []byte) (*modfile.File, error) {
+	bi, err := buildinfo.Read(bytes.NewReader(content))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if bi.Main.Path == "" {
+		return nil, fmt.Errorf("%s: binary was not built from a module", path)
+	}
+	return buildInfoModFile(bi)
+}
+
+// buildInfoModFile converts build information to a module file.
+func buildInfoModFile(bi *debug.BuildInfo) (*modfile.File, error) {
+	f := &modfile.File{Syntax: &modfile.FileSyntax{}}
+	if err := f.AddModuleStmt(bi.Main.Path); err != nil {
+		return nil, err
+	}
+	if v := goDirectiveVersion(bi.GoVersion); v != "" {
+		if err := f.AddGoStmt(v); err != nil {
+			return nil, err
+		}
+	}
+	for _, dep := range bi.Deps {
+		f.AddNewRequire(dep.Path, dep.Version, true)
+		if dep.Replace != nil {
+			if err := f.AddReplace(dep.Path, dep.Version, dep.Replace.Path, dep.Replace.Version); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return f, nil
+}
+
+// goDirectiveVersion converts a toolchain version like "go1.21.3" to the
+// language version used by the go directive ("1.21").
+func goDirectiveVersion(toolchain string) string {
+	v := strings.TrimPrefix(toolchain, "go")
+	parts := strings.SplitN(v, ".", 3)
+	if len(parts) < 2 {
+		return ""
+	}
+	minor := parts[1]
+	if i := strings.IndexFunc(minor, func(r rune) bool { return r < '0' || r > '9' }); i >= 0 {
+		minor = minor[:i]
+	}
+	if minor == "" {
+		return ""
+	}
+	return parts[0] + "." + minor
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"golang.org/x/mod/modfile"
+	"golang.org/x/mod/module"
+	"golang.org/x/mod/semver"
+)
+
+// sourceDir returns the directory the directory replacements of the source
+// at srcFile are relative to: srcFile itself for a source tree, and the
+// directory of the file otherwise.
+func sourceDir(srcFile string) string {
+	if info, err := os.Stat(srcFile); err == nil && info.IsDir() {
+		return srcFile
+	}
+	return filepath.Dir(srcFile)
+}
+
+// loadSourceTree combines the go.mod files of the modules in the tree below
+// dir (as found for -recursive) into a single module file, so a multi-module
+// repository can be transplanted at once. Requirements are combined keeping
+// the highest version of each module, direct if any module requires it
+// directly; replacements and exclusions are combined too, with directory
+// replacements made relative to dir. Requirements on and replacements of the
+// combined module itself are left out. Two modules replacing the same version
+// of a module differently are an error. The module at the root of dir, or
+// else the first one found, provides the module path (with its deprecation,
+// if any) and go version. If
+// files are given, only those go.mod files below dir are combined, and the
+// first one provides the module path. Which modules of the tree require,
+// replace or exclude what is recorded in t. The paths of the other modules
+// combined are returned too.
+func loadSourceTree(dir string, files []string, t trace) (*modfile.File, []string, error) {
+	if len(files) == 0 {
+		var err error
+		if files, err = findModFiles(dir); err != nil {
+			return nil, nil, err
+		}
+		for i, file := range files {
+			if filepath.Dir(file) == filepath.Clean(dir) {
+				files[0], files[i] = files[i], files[0]
+			}
+		}
+	}
+
+	var (
+		combined *modfile.File
+		nested   []string
+		required = map[string]*modfile.Require{}
+		replaced = map[module.Version]module.Version{}
+		// replacedBy records the go.mod file each replacement comes from.
+		replacedBy = map[module.Version]string{}
+	)
+	for _, file := range files {
+		f, err := parseModFile(file)
+		if err != nil {
+			return nil, nil, err
+		}
+		if f.Module == nil {
+			return nil, nil, fmt.Errorf("%s: no module directive", file)
+		}
+		rel, err := filepath.Rel(dir, file)
+		if err != nil {
+			return nil, nil, err
+		}
+		rel = filepath.ToSlash(rel)
+		fmt.Fprintf(actionLog(), "(source) %s: %s (%d requirement%s)\n", rel, f.Module.Mod.Path, len(f.Require), plural(len(f.Require)))
+		if combined == nil {
+			combined = &modfile.File{Syntax: &modfile.FileSyntax{}}
+			if err := combined.AddModuleStmt(f.Module.Mod.Path); err != nil {
+				return nil, nil, err
+			}
+			// Keep the module's comments, and with them its deprecation.
+			combined.Module.Syntax.Comments = f.Module.Syntax.Comments
+			combined.Module.Deprecated = f.Module.Deprecated
+			if f.Go != nil {
+				if err := combined.AddGoStmt(f.Go.Version); err != nil {
+					return nil, nil, err
+				}
+			}
+		}
+
+		self := combined.Module.Mod.Path
+		if f.Module.Mod.Path != self {
+			nested = append(nested, f.Module.Mod.Path)
+		}
+		for _, r := range f.Require {
+			if r.Mod.Path == self {
+				continue
+			}
+			t.note(r.Mod.Path, fmt.Sprintf("(source) %s requires %s%s", rel, r.Mod, indirectComment(r.Indirect)))
+			have, ok := required[r.Mod.Path]
+			if !ok {
+				combined.AddNewRequire(r.Mod.Path, r.Mod.Version, r.Indirect)
+				required[r.Mod.Path] = combined.Require[len(combined.Require)-1]
+				continue
+			}
+			if semver.Compare(r.Mod.Version, have.Mod.Version) > 0 {
+				have.Mod.Version = r.Mod.Version
+			}
+			have.Indirect = have.Indirect && r.Indirect
+		}
+
+		for _, r := range f.Replace {
+			if r.Old.Path == self {
+				continue
+			}
+			target := r.New
+			if target.Version == "" && !filepath.IsAbs(target.Path) {
+				target.Path = filepath.ToSlash(filepath.Join(filepath.Dir(filepath.FromSlash(rel)), target.Path))
+				if !modfile.IsDirectoryPath(target.Path) {
+					target.Path = "./" + target.Path
+				}
+			}
+			t.note(r.Old.Path, fmt.Sprintf("(source) %s replaces %s => %s", rel, r.Old, target))
+			if have, ok := replaced[r.Old]; ok {
+				if have != target {
+					return nil, nil, fmt.Errorf("%s: %s replaces %s with %s, but %s replaces it with %s", dir, rel, r.Old, target, replacedBy[r.Old], have)
+				}
+				continue
+			}
+			replaced[r.Old] = target
+			replacedBy[r.Old] = rel
+			if err := combined.AddReplace(r.Old.Path, r.Old.Version, target.Path, target.Version); err != nil {
+				return nil, nil, err
+			}
+		}
+
+		for _, e := range f.Exclude {
+			t.note(e.Mod.Path, fmt.Sprintf("(source) %s excludes %s", rel, e.Mod))
+			if err := combined.AddExclude(e.Mod.Path, e.Mod.Version); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+	combined.SetRequire(combined.Require)
+	return combined, nested, nil
+}
+
+func indirectComment(indirect bool) string {
+	if indirect {
+		return " // indirect"
+	}
+	return ""
+}
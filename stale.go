@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"go/version"
+	"os"
+
+	"golang.org/x/mod/modfile"
+	"golang.org/x/mod/module"
+	"golang.org/x/mod/semver"
+)
+
+// prunedGraph reports whether f lists every module its build needs, which is
+// the case from go 1.17 on. Only then can f be checked for stale directives
+// without loading the module graph.
+func prunedGraph(f *modfile.File) bool {
+	return f.Go != nil && version.Compare("go"+f.Go.Version, "go1.17") >= 0
+}
+
+// staleReplaces returns the replacements of f whose old module is no longer
+// required, or whose version-specific replacement applies to a version that
+// is no longer required.
+func staleReplaces(f *modfile.File) []module.Version {
+	required := map[string]string{}
+	for _, r := range f.Require {
+		required[r.Mod.Path] = r.Mod.Version
+	}
+	var stale []module.Version
+	for _, r := range f.Replace {
+		v, ok := required[r.Old.Path]
+		if !ok || (r.Old.Version != "" && r.Old.Version != v) {
+			stale = append(stale, r.Old)
+		}
+	}
+	return stale
+}
+
+// pruneReplaces reports the stale replacements of f, dropping them if prune
+// is set. Those of modules with a control comment are left alone.
+func pruneReplaces(f *modfile.File, prune bool, t trace) error {
+	if !prunedGraph(f) {
+		if prune {
+			fmt.Fprintln(os.Stderr, "(replace) prune skipped: go directive is older than 1.17")
+		}
+		return nil
+	}
+	controls := destControls(f)
+	for _, old := range staleReplaces(f) {
+		if c := controls[old.Path]; c != "" {
+			t.warnf(old.Path, "(replace) stale: %s (left alone by %s)", old, c)
+			continue
+		}
+		if !prune {
+			t.warnf(old.Path, "(replace) stale: %s (drop with -prune-replaces)", old)
+			continue
+		}
+		t.logf(old.Path, "(replace) prune stale: %s", old)
+		if err := f.DropReplace(old.Path, old.Version); err != nil {
+			return err
+		}
+	}
+	f.Cleanup()
+	return nil
+}
+
+// staleExcludes returns the exclusions of f for versions that can no longer be
+// selected: the module isn't required at all, or a higher version is.
+func staleExcludes(f *modfile.File) []module.Version {
+	required := map[string]string{}
+	for _, r := range f.Require {
+		required[r.Mod.Path] = r.Mod.Version
+	}
+	var stale []module.Version
+	for _, e := range f.Exclude {
+		v, ok := required[e.Mod.Path]
+		if !ok || semver.Compare(v, e.Mod.Version) > 0 {
+			stale = append(stale, e.Mod)
+		}
+	}
+	return stale
+}
+
+// pruneExcludes reports the stale exclusions of f, dropping them if prune is
+// set. Those of modules with a control comment are left alone.
+func pruneExcludes(f *modfile.File, prune bool, t trace) error {
+	if !prunedGraph(f) {
+		if prune {
+			fmt.Fprintln(os.Stderr, "(exclude) prune skipped: go directive is older than 1.17")
+		}
+		return nil
+	}
+	controls := destControls(f)
+	for _, mod := range staleExcludes(f) {
+		if c := controls[mod.Path]; c != "" {
+			t.warnf(mod.Path, "(exclude) stale: %s (left alone by %s)", mod, c)
+			continue
+		}
+		if !prune {
+			t.warnf(mod.Path, "(exclude) stale: %s (drop with -prune-excludes)", mod)
+			continue
+		}
+		t.logf(mod.Path, "(exclude) prune stale: %s", mod)
+		if err := f.DropExclude(mod.Path, mod.Version); err != nil {
+			return err
+		}
+	}
+	f.Cleanup()
+	return nil
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"golang.org/x/mod/semver"
+
+	"github.com/brettbuddin/modtransplant/transplant"
+)
+
+// writeSummary ends a run with a table counting the changes and conflicts of
+// each section of the destination, and a git-style diffstat of its go.mod.
+func writeSummary(w io.Writer, destFile string, changes []change, mergeReport *transplant.Report, before, after string) error {
+	type counts struct{ added, updated, downgraded, removed, conflicts int }
+	sections := []string{"require", "replace", "exclude", "ignore"}
+	bySection := map[string]*counts{}
+	for _, s := range sections {
+		bySection[s] = &counts{}
+	}
+	for _, c := range changes {
+		n, ok := bySection[c.Kind]
+		if !ok {
+			// A module rename shows in the diffstat.
+			continue
+		}
+		switch {
+		case c.Action == actionAdd:
+			n.added++
+		case c.Action == actionRemove:
+			n.removed++
+		case c.Kind == "require" && semver.Compare(c.To, c.From) < 0:
+			n.downgraded++
+		default:
+			n.updated++
+		}
+	}
+	for _, e := range mergeReport.Entries {
+		switch e := e.(type) {
+		case transplant.ConflictResolved:
+			bySection[string(e.Conflict.Kind)].conflicts++
+		case transplant.ConflictUnresolved:
+			bySection[string(e.Conflict.Kind)].conflicts++
+		}
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "section\tadded\tupdated\tdowngraded\tremoved\tconflicts")
+	for _, s := range sections {
+		n := bySection[s]
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%d\t%d\n", s, n.added, n.updated, n.downgraded, n.removed, n.conflicts)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	var insertions, deletions int
+	for _, l := range diffLines(before, after) {
+		switch l.Op {
+		case "+":
+			insertions++
+		case "-":
+			deletions++
+		}
+	}
+	name := filepath.Base(destFile)
+	if destFile == stdinDest {
+		name = "<stdin>"
+	}
+	fmt.Fprintf(w, " %s | %d %s%s\n", name, insertions+deletions, strings.Repeat("+", insertions), strings.Repeat("-", deletions))
+	_, err := fmt.Fprintf(w, " 1 file changed, %d insertion%s(+), %d deletion%s(-)\n", insertions, plural(insertions), deletions, plural(deletions))
+	return err
+}
+
+func plural(n int) string {
+	if n == 1 {
+		return ""
+	}
+	return "s"
+}
@@ -0,0 +1,123 @@
+package transplant
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/mod/modfile"
+)
+
+// ErrExistingEntry is wrapped by the problems of a merge with WithAddOnly
+// that would change an existing entry of the destination.
+var ErrExistingEntry = errors.New("existing destination entry would change")
+
+// ErrNoModule is returned by Merge for a source without a module directive,
+// such as a partial go.mod file, since the source module can't be dropped
+// from the destination without its path.
+var ErrNoModule = errors.New("source has no module directive")
+
+// ErrVersionConflict is wrapped by the problems of requirements whose
+// versions in the source and destination can't be reconciled, because they
+// can't be compared.
+var ErrVersionConflict = errors.New("versions cannot be reconciled")
+
+// ErrReplaceConflict is wrapped by the problems of replacements of the same
+// module version with different targets in the source and destination.
+var ErrReplaceConflict = errors.New("replacements conflict")
+
+// ErrInvalidVersion is wrapped by the problems of requirements with versions
+// that aren't semantic versions.
+var ErrInvalidVersion = errors.New("invalid version")
+
+// ErrExcludedVersion is wrapped by the problems of requirements whose selected
+// version is excluded when no higher version can be selected instead.
+var ErrExcludedVersion = errors.New("selected version is excluded")
+
+// PolicyViolationError is the problem of a conflict resolved with Fail, by a
+// resolver or the strategy set with WithStrategy.
+type PolicyViolationError struct {
+	Conflict Conflict
+}
+
+func (e *PolicyViolationError) Error() string {
+	return fmt.Sprintf("%s: refused (dest=%s src=%s)", e.Conflict, e.Conflict.DestVersion, e.Conflict.SrcVersion)
+}
+
+// sentinelError is an error that errors.Is matches with a sentinel without
+// the sentinel's text being part of its message.
+type sentinelError struct {
+	error
+	sentinel error
+}
+
+func (e sentinelError) Is(target error) bool {
+	return target == e.sentinel
+}
+
+func (e sentinelError) Unwrap() error {
+	return e.error
+}
+
+// errorf formats an error like fmt.Errorf that also matches sentinel.
+func errorf(sentinel error, format string, args ...interface{}) error {
+	return sentinelError{fmt.Errorf(format, args...), sentinel}
+}
+
+// A Problem is an issue found during a merge, along with the positions of the
+// statements involved.
+type Problem struct {
+	// Dest and Src locate the destination and source statements as
+	// "file:line". Either is empty when not applicable or unknown.
+	Dest, Src string
+	Err       error
+}
+
+func (p Problem) String() string {
+	var pos []string
+	for _, s := range []string{p.Dest, p.Src} {
+		if s != "" {
+			pos = append(pos, s)
+		}
+	}
+	if len(pos) == 0 {
+		return p.Err.Error()
+	}
+	return fmt.Sprintf("%s: %v", strings.Join(pos, ", "), p.Err)
+}
+
+// MergeError reports all problems found during a merge.
+type MergeError struct {
+	Problems []Problem
+}
+
+func (e *MergeError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "merge failed with %d problem(s):", len(e.Problems))
+	for _, p := range e.Problems {
+		fmt.Fprintf(&b, "\n\t%s", p)
+	}
+	return b.String()
+}
+
+// Unwrap returns the errors of the problems, so errors.Is and errors.As see
+// through a MergeError.
+func (e *MergeError) Unwrap() []error {
+	errs := make([]error, len(e.Problems))
+	for i, p := range e.Problems {
+		errs[i] = p.Err
+	}
+	return errs
+}
+
+// problem records err as a problem with the given statements.
+func (m *merger) problem(dest *modfile.File, destLine *modfile.Line, src *modfile.File, srcLine *modfile.Line, err error) {
+	m.problems = append(m.problems, Problem{Dest: position(dest, destLine), Src: position(src, srcLine), Err: err})
+}
+
+func position(f *modfile.File, line *modfile.Line) string {
+	if f == nil || f.Syntax == nil || line == nil || line.Start.Line == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%s:%d", f.Syntax.Name, line.Start.Line)
+}
@@ -0,0 +1,136 @@
+package transplant
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"golang.org/x/mod/module"
+)
+
+// DefaultProxyURL is the module proxy a ProxyFetcher without a URL queries.
+const DefaultProxyURL = "https://proxy.golang.org"
+
+// ErrNotFound is wrapped by the errors of a ModuleFetcher for modules and
+// versions that don't exist, as opposed to ones that couldn't be looked up.
+var ErrNotFound = errors.New("not found")
+
+// VersionInfo describes a module version, like the .info files of a module
+// proxy.
+type VersionInfo struct {
+	Version string
+	Time    time.Time
+}
+
+// A ModuleFetcher answers the queries about module versions that merges and
+// the features of the modtransplant command built on them make. ProxyFetcher
+// implements it with a module proxy; embedding programs can supply their own
+// backends, such as an internal registry, or fakes in tests.
+type ModuleFetcher interface {
+	// Info describes path@version, failing with an error wrapping
+	// ErrNotFound if the version doesn't exist.
+	Info(ctx context.Context, path, version string) (*VersionInfo, error)
+	// Latest resolves the latest version of path.
+	Latest(ctx context.Context, path string) (string, error)
+	// GoMod returns the go.mod file of path@version.
+	GoMod(ctx context.Context, path, version string) ([]byte, error)
+	// List lists the known versions of path.
+	List(ctx context.Context, path string) ([]string, error)
+}
+
+// WithFetcher sets the fetcher the versions of a module are listed with, as
+// WithVersions does with a function.
+func WithFetcher(f ModuleFetcher) Option {
+	return WithVersions(f.List)
+}
+
+// ProxyFetcher is a ModuleFetcher querying a single module proxy with the
+// GOPROXY protocol. The zero value queries DefaultProxyURL with
+// http.DefaultClient.
+type ProxyFetcher struct {
+	// URL is the base URL of the proxy.
+	URL    string
+	Client *http.Client
+}
+
+func (p *ProxyFetcher) Info(ctx context.Context, path, version string) (*VersionInfo, error) {
+	v, err := module.EscapeVersion(version)
+	if err != nil {
+		return nil, err
+	}
+	data, err := p.get(ctx, path, "@v/"+v+".info")
+	if err != nil {
+		return nil, err
+	}
+	var info VersionInfo
+	if err := json.Unmarshal(data, &info); err != nil {
+		return nil, fmt.Errorf("%s@%s: %w", path, version, err)
+	}
+	return &info, nil
+}
+
+func (p *ProxyFetcher) Latest(ctx context.Context, path string) (string, error) {
+	data, err := p.get(ctx, path, "@latest")
+	if err != nil {
+		return "", err
+	}
+	var info VersionInfo
+	if err := json.Unmarshal(data, &info); err != nil {
+		return "", fmt.Errorf("%s@latest: %w", path, err)
+	}
+	return info.Version, nil
+}
+
+func (p *ProxyFetcher) GoMod(ctx context.Context, path, version string) ([]byte, error) {
+	v, err := module.EscapeVersion(version)
+	if err != nil {
+		return nil, err
+	}
+	return p.get(ctx, path, "@v/"+v+".mod")
+}
+
+func (p *ProxyFetcher) List(ctx context.Context, path string) ([]string, error) {
+	data, err := p.get(ctx, path, "@v/list")
+	if err != nil {
+		return nil, err
+	}
+	return strings.Fields(string(data)), nil
+}
+
+// get retrieves the file at rel (e.g. "@v/list") for module path, mapping 404
+// and 410 responses to ErrNotFound.
+func (p *ProxyFetcher) get(ctx context.Context, path, rel string) ([]byte, error) {
+	escaped, err := module.EscapePath(path)
+	if err != nil {
+		return nil, err
+	}
+	base, client := p.URL, p.Client
+	if base == "" {
+		base = DefaultProxyURL
+	}
+	if client == nil {
+		client = http.DefaultClient
+	}
+	rawurl := strings.TrimSuffix(base, "/") + "/" + escaped + "/" + rel
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, rawurl, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone:
+		return nil, fmt.Errorf("%s/%s: %s: %w", path, rel, resp.Status, ErrNotFound)
+	case resp.StatusCode != http.StatusOK:
+		return nil, fmt.Errorf("%s: %s", rawurl, resp.Status)
+	}
+	return ioutil.ReadAll(resp.Body)
+}
@@ -0,0 +1,632 @@
+// Package transplant merges the dependency set of one Go module into another.
+// It is the engine behind the modtransplant command and can be embedded in
+// other programs.
+package transplant
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/Masterminds/semver"
+	"golang.org/x/mod/modfile"
+	"golang.org/x/mod/module"
+	modsemver "golang.org/x/mod/semver"
+)
+
+// An Option tunes how a source is merged into a destination.
+type Option func(*options)
+
+type options struct {
+	strategy       Resolution
+	forceOverwrite bool
+	addOnly        bool
+	resolver       Resolver
+	filters        []func(module.Version) bool
+	logger         *log.Logger
+	debug          func(Step)
+	versions       func(ctx context.Context, path string) ([]string, error)
+	absorbed       []func(path string) bool
+}
+
+// WithStrategy sets the resolution of conflicts no resolver decides on. The
+// default is Highest.
+func WithStrategy(r Resolution) Option {
+	return func(o *options) { o.strategy = r }
+}
+
+// WithForceOverwrite makes the source's version of a module win whenever the
+// versions differ, even when they cannot be compared.
+func WithForceOverwrite(force bool) Option {
+	return func(o *options) { o.forceOverwrite = force }
+}
+
+// WithAddOnly restricts the merge to adding the source's requirements,
+// replacements and exclusions missing from the destination. Any change to an
+// existing entry of the destination is a problem wrapping ErrExistingEntry
+// instead. The destination's dependency on the source module is still
+// removed.
+func WithAddOnly(addOnly bool) Option {
+	return func(o *options) { o.addOnly = addOnly }
+}
+
+// WithResolver sets the resolver deciding how conflicts are resolved.
+func WithResolver(r Resolver) Option {
+	return func(o *options) { o.resolver = r }
+}
+
+// WithFilter restricts the merge to the source's requirements, replacements
+// and exclusions of modules for which keep returns true. Filters accumulate;
+// a module must pass all of them.
+func WithFilter(keep func(module.Version) bool) Option {
+	return func(o *options) { o.filters = append(o.filters, keep) }
+}
+
+// WithLogger sets the logger a line is written to for every step of the
+// merge. By default the lines go to stderr; a nil logger silences them.
+func WithLogger(l *log.Logger) Option {
+	return func(o *options) { o.logger = l }
+}
+
+// WithAbsorbed marks further modules as part of the source, such as the
+// nested modules of its repository: like the source module, they are dropped
+// from the destination's requirements and replacements, and the source's
+// requirements and replacements of them are left out. The sets of modules
+// accumulate.
+func WithAbsorbed(absorbed func(path string) bool) Option {
+	return func(o *options) { o.absorbed = append(o.absorbed, absorbed) }
+}
+
+// WithVersions sets the function listing the available versions of a module.
+// When the version a merge selects for a requirement is excluded by either
+// file, the next higher version that isn't excluded is selected instead.
+// Without it, selecting an excluded version is an unresolved conflict.
+func WithVersions(list func(ctx context.Context, path string) ([]string, error)) Option {
+	return func(o *options) { o.versions = list }
+}
+
+// WithDebug sets a function called with every comparison and rule evaluation
+// of the merge, for postmortems of merges that did something unexpected.
+func WithDebug(f func(Step)) Option {
+	return func(o *options) { o.debug = f }
+}
+
+// Merge merges the requires, replacements, excludes and ignores of src into
+// dest and reports what it did. Problems, such as invalid versions or unresolved
+// conflicts, don't stop the merge; they are collected and returned together as
+// a *MergeError once everything else has been merged. The merge only stops
+// early if ctx is cancelled. The report is returned in either case. A source
+// without a module directive is rejected with ErrNoModule before anything is
+// merged.
+func Merge(ctx context.Context, dest, src *modfile.File, opts ...Option) (*Report, error) {
+	if src.Module == nil || src.Module.Mod.Path == "" {
+		return &Report{}, ErrNoModule
+	}
+	m := &merger{
+		opts: options{
+			strategy: Highest,
+			logger:   log.New(os.Stderr, "", 0),
+		},
+		report:   &Report{},
+		excluded: map[module.Version]bool{},
+	}
+	for _, opt := range opts {
+		opt(&m.opts)
+	}
+	for _, f := range []*modfile.File{dest, src} {
+		for _, e := range f.Exclude {
+			m.excluded[e.Mod] = true
+		}
+	}
+	if err := m.mergeRequires(ctx, dest, src); err != nil {
+		return m.report, err
+	}
+	if err := ctx.Err(); err != nil {
+		return m.report, err
+	}
+	if err := m.mergeReplacements(ctx, dest, src); err != nil {
+		return m.report, err
+	}
+	if err := ctx.Err(); err != nil {
+		return m.report, err
+	}
+	if err := m.mergeExcludes(ctx, dest, src); err != nil {
+		return m.report, err
+	}
+	if err := m.mergeIgnores(dest, src); err != nil {
+		return m.report, err
+	}
+	dest.Cleanup()
+	if len(m.problems) > 0 {
+		return m.report, &MergeError{Problems: m.problems}
+	}
+	return m.report, nil
+}
+
+// merger carries the state of a single merge.
+type merger struct {
+	opts     options
+	report   *Report
+	problems []Problem
+	// excluded are the module versions excluded by either file, which are
+	// never selected.
+	excluded map[module.Version]bool
+}
+
+// record adds e to the report and logs it.
+func (m *merger) record(e Entry) {
+	m.logf("%s", e)
+	m.report.Entries = append(m.report.Entries, e)
+}
+
+func (m *merger) logf(format string, args ...interface{}) {
+	if m.opts.logger != nil {
+		m.opts.logger.Printf(format, args...)
+	}
+}
+
+// isAbsorbed reports whether path is the source module, or marked as part of
+// it with WithAbsorbed.
+func (m *merger) isAbsorbed(src *modfile.File, path string) bool {
+	if path == src.Module.Mod.Path {
+		return true
+	}
+	for _, absorbed := range m.opts.absorbed {
+		if absorbed(path) {
+			return true
+		}
+	}
+	return false
+}
+
+// step reports s to the debug function, if any.
+func (m *merger) step(s Step) {
+	if m.opts.debug != nil {
+		m.opts.debug(s)
+	}
+}
+
+// keep reports whether mod passes the filters.
+func (m *merger) keep(kind string, mod module.Version) bool {
+	for i, keep := range m.opts.filters {
+		if !keep(mod) {
+			m.step(Step{Kind: kind, Path: mod.Path, Rule: "filter", Src: mod.Version, Result: fmt.Sprintf("filter %d rejects", i)})
+			m.logf("(%s) skip filtered: %s", kind, mod)
+			return false
+		}
+	}
+	if len(m.opts.filters) > 0 {
+		m.step(Step{Kind: kind, Path: mod.Path, Rule: "filter", Src: mod.Version, Result: "pass"})
+	}
+	return true
+}
+
+// mergeRequires merges "require" statements into the destination.
+//
+// Mutation Rules:
+// - Module paths missing from the destination entirely will be added.
+// - Module paths in the destination that have mismatched versions will be
+// overwritten by what's in the source.
+// - Module paths that are indirect in the destination, but direct in the source
+// will be made direct.
+// - Any dependency that the destination has on the source, or on modules
+// absorbed with it, will be removed, and the source's requirements of
+// absorbed modules are left out.
+// - A version excluded by either file is never selected; the next higher
+// version is, if the available versions can be listed.
+//
+// A resolver, if configured, can override how mismatched versions are
+// resolved.
+func (m *merger) mergeRequires(ctx context.Context, dest, src *modfile.File) error {
+	var dropPaths []string
+	for _, r := range dest.Require {
+		if m.isAbsorbed(src, r.Mod.Path) {
+			m.step(Step{Kind: "require", Path: r.Mod.Path, Rule: "source-module", Dest: r.Mod.Version, Result: "drop"})
+			m.record(RequireDropped{Path: r.Mod.Path})
+			dropPaths = append(dropPaths, r.Mod.Path)
+		}
+	}
+	for _, path := range dropPaths {
+		if err := dest.DropRequire(path); err != nil {
+			return err
+		}
+	}
+
+	// Index the destination's requirements by path, keeping the first of
+	// any duplicates, so huge files aren't scanned once per requirement.
+	destReqs := make(map[string]*modfile.Require, len(dest.Require))
+	for _, r := range dest.Require {
+		if _, ok := destReqs[r.Mod.Path]; !ok {
+			destReqs[r.Mod.Path] = r
+		}
+	}
+	for _, srcR := range src.Require {
+		if m.isAbsorbed(src, srcR.Mod.Path) {
+			m.step(Step{Kind: "require", Path: srcR.Mod.Path, Rule: "source-module", Src: srcR.Mod.Version, Result: "skip"})
+			m.logf("(require) skip absorbed: %s", srcR.Mod)
+			continue
+		}
+		if !m.keep("require", srcR.Mod) {
+			continue
+		}
+		destR := destReqs[srcR.Mod.Path]
+		if destR == nil {
+			m.step(Step{Kind: "require", Path: srcR.Mod.Path, Rule: "lookup", Src: srcR.Mod.Version, Result: "missing from dest"})
+			version, err := m.allowedVersion(ctx, srcR.Mod)
+			if err != nil {
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				m.problem(dest, nil, src, srcR.Syntax, err)
+				continue
+			}
+			mod := module.Version{Path: srcR.Mod.Path, Version: version}
+			m.record(RequireAdded{Module: mod, Indirect: srcR.Indirect})
+			dest.AddNewRequire(mod.Path, mod.Version, srcR.Indirect)
+			destReqs[srcR.Mod.Path] = dest.Require[len(dest.Require)-1]
+			continue
+		}
+		err := m.mergeRequire(ctx, dest, src, destR, srcR)
+		if err == nil {
+			err = m.avoidExcluded(ctx, destR)
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			m.problem(dest, destR.Syntax, src, srcR.Syntax, err)
+		}
+	}
+
+	// Versions and indirect markers are changed in place above, so sync them
+	// back into the file's syntax tree.
+	dest.Cleanup()
+	dest.SetRequire(dest.Require)
+
+	return nil
+}
+
+// mergeRequire merges srcR of src into destR of dest, which requires the same
+// module. Versions are compared as each file's replacements make them.
+func (m *merger) mergeRequire(ctx context.Context, dest, src *modfile.File, destR, srcR *modfile.Require) error {
+	if srcR.Mod.Version == destR.Mod.Version {
+		m.step(Step{Kind: "require", Path: srcR.Mod.Path, Rule: "equal", Dest: destR.Mod.Version, Src: srcR.Mod.Version, Result: "match"})
+		m.logf("(require) match: %s", srcR.Mod)
+		return nil
+	}
+
+	conflict := Conflict{
+		Kind:         RequireConflict,
+		Path:         srcR.Mod.Path,
+		DestVersion:  destR.Mod.Version,
+		SrcVersion:   srcR.Mod.Version,
+		DestIndirect: destR.Indirect,
+		SrcIndirect:  srcR.Indirect,
+	}
+	destEff, srcEff := effectiveModule(dest, destR.Mod), effectiveModule(src, srcR.Mod)
+	if destEff != destR.Mod {
+		conflict.DestReplacement = destEff.String()
+	}
+	if srcEff != srcR.Mod {
+		conflict.SrcReplacement = srcEff.String()
+	}
+	resolution, err := m.resolve(ctx, conflict)
+	if err != nil {
+		return err
+	}
+
+	replace := func() error {
+		if m.opts.addOnly {
+			return m.unresolved(conflict, fmt.Errorf("%w: version of %s would change from %s to %s", ErrExistingEntry, destR.Mod.Path, destR.Mod.Version, srcR.Mod.Version))
+		}
+		m.record(RequireUpdated{Path: destR.Mod.Path, Old: destR.Mod.Version, New: srcR.Mod.Version})
+		destR.Mod.Version = srcR.Mod.Version
+		return nil
+	}
+	if destEff != srcEff && (destEff.Version == "" || srcEff.Version == "") {
+		// A local directory replaces every version of the module, so it is
+		// the required versions that are compared.
+		m.step(Step{Kind: "require", Path: srcR.Mod.Path, Rule: "effective", Dest: destEff.String(), Src: srcEff.String(), Result: "local directory"})
+		destEff, srcEff = destR.Mod, srcR.Mod
+	}
+	switch {
+	case resolution == KeepDest:
+		m.record(RequireKept{Module: destR.Mod, Src: srcR.Mod.Version})
+	case resolution == TakeSrc || m.opts.forceOverwrite:
+		if resolution != TakeSrc {
+			m.step(Step{Kind: "require", Path: srcR.Mod.Path, Rule: "force-overwrite", Dest: destR.Mod.Version, Src: srcR.Mod.Version, Result: "take src"})
+		}
+		if err := replace(); err != nil {
+			return err
+		}
+	case destEff == srcEff:
+		// Both files use the same replacement, so neither version wins.
+		m.step(Step{Kind: "require", Path: srcR.Mod.Path, Rule: "effective", Dest: destEff.String(), Src: srcEff.String(), Result: "match"})
+		m.logf("(require) match replacement: %s => %s", srcR.Mod.Path, srcEff)
+	case destEff.Path != srcEff.Path:
+		m.step(Step{Kind: "require", Path: srcR.Mod.Path, Rule: "effective", Dest: destEff.String(), Src: srcEff.String(), Result: "incomparable"})
+		return m.unresolved(conflict, errorf(ErrVersionConflict, "cannot compare versions of %s: dest uses %s, src uses %s", srcR.Mod.Path, destEff, srcEff))
+	default:
+		destVersion, err := semver.NewVersion(destEff.Version)
+		if err != nil {
+			return m.unresolved(conflict, errorf(ErrInvalidVersion, "invalid version %s: %w", destEff, err))
+		}
+		srcVersion, err := semver.NewVersion(srcEff.Version)
+		if err != nil {
+			return m.unresolved(conflict, errorf(ErrInvalidVersion, "invalid version %s: %w", srcEff, err))
+		}
+		if !canCompare(destVersion, srcVersion) {
+			m.step(Step{Kind: "require", Path: srcR.Mod.Path, Rule: "comparable", Dest: destEff.Version, Src: srcEff.Version, Result: "incomparable"})
+			return m.unresolved(conflict, errorf(ErrVersionConflict, "cannot reconcile difference between versions: dest=%s src=%s", destEff, srcEff))
+		}
+		less := destVersion.LessThan(srcVersion)
+		m.step(Step{Kind: "require", Path: srcR.Mod.Path, Rule: "less-than", Dest: destEff.Version, Src: srcEff.Version, Result: fmt.Sprint(less)})
+		if less {
+			if err := replace(); err != nil {
+				return err
+			}
+		}
+	}
+
+	if destR.Indirect != srcR.Indirect {
+		m.step(Step{Kind: "require", Path: srcR.Mod.Path, Rule: "indirect", Dest: indirectStr(destR.Indirect), Src: indirectStr(srcR.Indirect), Result: fmt.Sprintf("make direct: %t", destR.Indirect)})
+	}
+	if destR.Indirect && !srcR.Indirect {
+		if m.opts.addOnly {
+			return m.unresolved(conflict, errorf(ErrExistingEntry, "%s would be made direct", destR.Mod))
+		}
+		m.record(RequireMadeDirect{Module: destR.Mod})
+		destR.Indirect = false
+	}
+	return nil
+}
+
+// avoidExcluded moves destR, as merged, off a version excluded by either file.
+func (m *merger) avoidExcluded(ctx context.Context, destR *modfile.Require) error {
+	version, err := m.allowedVersion(ctx, destR.Mod)
+	if err != nil || version == destR.Mod.Version {
+		return err
+	}
+	if m.opts.addOnly {
+		conflict := Conflict{Kind: ExcludeConflict, Path: destR.Mod.Path, DestVersion: destR.Mod.Version, SrcVersion: destR.Mod.Version}
+		return m.unresolved(conflict, errorf(ErrExistingEntry, "excluded version %s would change to %s", destR.Mod, version))
+	}
+	m.record(RequireUpdated{Path: destR.Mod.Path, Old: destR.Mod.Version, New: version})
+	destR.Mod.Version = version
+	return nil
+}
+
+// allowedVersion returns the version of mod if neither file excludes it, or
+// the next higher version that isn't excluded. Pre-releases are only
+// considered if mod's version is one.
+func (m *merger) allowedVersion(ctx context.Context, mod module.Version) (string, error) {
+	if !m.excluded[mod] {
+		return mod.Version, nil
+	}
+	conflict := Conflict{Kind: ExcludeConflict, Path: mod.Path, DestVersion: mod.Version, SrcVersion: mod.Version}
+	if m.opts.versions == nil {
+		m.step(Step{Kind: "require", Path: mod.Path, Rule: "excluded", Src: mod.Version, Result: "no version lister"})
+		return "", m.unresolved(conflict, errorf(ErrExcludedVersion, "selected version %s is excluded", mod))
+	}
+	versions, err := m.opts.versions(ctx, mod.Path)
+	if err != nil {
+		return "", m.unresolved(conflict, errorf(ErrExcludedVersion, "listing versions of %s to replace excluded %s: %w", mod.Path, mod.Version, err))
+	}
+	modsemver.Sort(versions)
+	for _, v := range versions {
+		if modsemver.Compare(v, mod.Version) <= 0 || m.excluded[module.Version{Path: mod.Path, Version: v}] {
+			continue
+		}
+		if modsemver.Prerelease(v) != "" && modsemver.Prerelease(mod.Version) == "" {
+			continue
+		}
+		m.step(Step{Kind: "require", Path: mod.Path, Rule: "excluded", Src: mod.Version, Result: "next " + v})
+		m.logf("(require) skip excluded: %s, next %s", mod, v)
+		return v, nil
+	}
+	m.step(Step{Kind: "require", Path: mod.Path, Rule: "excluded", Src: mod.Version, Result: "no higher version"})
+	return "", m.unresolved(conflict, errorf(ErrExcludedVersion, "selected version %s is excluded, as are all higher versions", mod))
+}
+
+// mergeReplacements merges "replace" statements into the destination.
+//
+// Mutation rules:
+// - Module paths missing from the destination entirely will be added.
+// - Replacements for the source module, and for modules absorbed with it, in
+// the destination will be removed, and the source's replacements of them are
+// left out.
+//
+// This function will error if matching module paths are found in both the
+// source and destination, but the versions mismatch. This is considered a
+// condition that will need human intervention, unless a resolver decides
+// otherwise.
+func (m *merger) mergeReplacements(ctx context.Context, dest, src *modfile.File) error {
+	var dropVersions []module.Version
+	for _, r := range dest.Replace {
+		if r.Old.Path != "" && m.isAbsorbed(src, r.Old.Path) {
+			dropVersions = append(dropVersions, r.Old)
+		}
+	}
+	for _, v := range dropVersions {
+		m.record(ReplaceDropped{Old: v})
+		dest.DropReplace(v.Path, v.Version)
+	}
+
+	for _, srcR := range src.Replace {
+		if m.isAbsorbed(src, srcR.Old.Path) {
+			m.logf("(replace) skip absorbed: %s", srcR.Old)
+			continue
+		}
+		if !m.keep("replace", srcR.Old) {
+			continue
+		}
+		var found bool
+		for _, destR := range dest.Replace {
+			if srcR.Old != destR.Old {
+				continue
+			}
+			found = true
+			if srcR.New == destR.New {
+				m.step(Step{Kind: "replace", Path: srcR.Old.Path, Rule: "equal", Dest: destR.New.String(), Src: srcR.New.String(), Result: "match"})
+				m.logf("(replace) match: %s", srcR.Old)
+				break
+			}
+			m.step(Step{Kind: "replace", Path: srcR.Old.Path, Rule: "equal", Dest: destR.New.String(), Src: srcR.New.String(), Result: "mismatch"})
+			conflict := Conflict{
+				Kind:            ReplaceConflict,
+				Path:            srcR.Old.Path,
+				DestVersion:     destR.Old.Version,
+				SrcVersion:      srcR.Old.Version,
+				DestReplacement: destR.New.String(),
+				SrcReplacement:  srcR.New.String(),
+			}
+			resolution, err := m.resolve(ctx, conflict)
+			switch {
+			case err != nil:
+			case resolution == KeepDest:
+				m.record(ReplaceKept{Old: destR.Old, New: destR.New, Src: srcR.New})
+			case resolution == TakeSrc && m.opts.addOnly:
+				err = m.unresolved(conflict, fmt.Errorf("%w: replacement of %s would change from %s to %s", ErrExistingEntry, srcR.Old, destR.New, srcR.New))
+			case resolution == TakeSrc:
+				m.record(ReplaceUpdated{Old: srcR.Old, From: destR.New, To: srcR.New})
+				err = dest.AddReplace(srcR.Old.Path, srcR.Old.Version, srcR.New.Path, srcR.New.Version)
+			default:
+				err = m.unresolved(conflict, errorf(ErrReplaceConflict, "source and destination old path/version match, but new path/version do not: %s -> %s vs %s", srcR.Old, destR.New, srcR.New))
+			}
+			if err != nil {
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				m.problem(dest, destR.Syntax, src, srcR.Syntax, err)
+			}
+			break
+		}
+
+		if !found {
+			m.step(Step{Kind: "replace", Path: srcR.Old.Path, Rule: "lookup", Src: srcR.New.String(), Result: "missing from dest"})
+			m.record(ReplaceAdded{Old: srcR.Old, New: srcR.New})
+			dest.AddReplace(srcR.Old.Path, srcR.Old.Version, srcR.New.Path, srcR.New.Version)
+		}
+	}
+
+	return nil
+}
+
+// mergeExcludes merges "exclude" statements into the destination. Only
+// exclusions missing from the destination will be added. Excluding the version
+// the destination requires is a conflict, which a resolver may settle by
+// keeping the destination as it is.
+func (m *merger) mergeExcludes(ctx context.Context, dest, src *modfile.File) error {
+	required := map[string]string{}
+	for _, r := range dest.Require {
+		required[r.Mod.Path] = r.Mod.Version
+	}
+	excluded := make(map[module.Version]bool, len(dest.Exclude))
+	for _, e := range dest.Exclude {
+		excluded[e.Mod] = true
+	}
+
+	for _, srcE := range src.Exclude {
+		if !m.keep("exclude", srcE.Mod) {
+			continue
+		}
+		if excluded[srcE.Mod] {
+			m.step(Step{Kind: "exclude", Path: srcE.Mod.Path, Rule: "lookup", Src: srcE.Mod.Version, Result: "match"})
+			m.logf("(exclude) match: %s", srcE.Mod)
+			continue
+		}
+
+		isRequired := required[srcE.Mod.Path] == srcE.Mod.Version
+		m.step(Step{Kind: "exclude", Path: srcE.Mod.Path, Rule: "excludes-required", Dest: required[srcE.Mod.Path], Src: srcE.Mod.Version, Result: fmt.Sprint(isRequired)})
+		if isRequired {
+			conflict := Conflict{
+				Kind:        ExcludeConflict,
+				Path:        srcE.Mod.Path,
+				DestVersion: required[srcE.Mod.Path],
+				SrcVersion:  srcE.Mod.Version,
+			}
+			resolution, err := m.resolve(ctx, conflict)
+			if err != nil {
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				m.problem(dest, nil, src, srcE.Syntax, err)
+				continue
+			}
+			if resolution == KeepDest {
+				m.record(ExcludeSkipped{Module: srcE.Mod})
+				continue
+			}
+			if m.opts.addOnly {
+				m.problem(dest, nil, src, srcE.Syntax, m.unresolved(conflict, fmt.Errorf("%w: required version %s would be excluded", ErrExistingEntry, srcE.Mod)))
+				continue
+			}
+		}
+		m.record(ExcludeAdded{Module: srcE.Mod})
+		excluded[srcE.Mod] = true
+		dest.AddExclude(srcE.Mod.Path, srcE.Mod.Version)
+	}
+
+	return nil
+}
+
+// mergeIgnores merges "ignore" statements into the destination. Directory
+// patterns missing from the destination are added; they don't name modules,
+// so filters don't apply to them.
+func (m *merger) mergeIgnores(dest, src *modfile.File) error {
+	ignored := map[string]bool{}
+	for _, i := range dest.Ignore {
+		ignored[i.Path] = true
+	}
+	for _, srcI := range src.Ignore {
+		m.step(Step{Kind: "ignore", Path: srcI.Path, Rule: "lookup", Result: fmt.Sprintf("in dest: %t", ignored[srcI.Path])})
+		if ignored[srcI.Path] {
+			m.logf("(ignore) match: %s", srcI.Path)
+			continue
+		}
+		ignored[srcI.Path] = true
+		m.record(IgnoreAdded{Path: srcI.Path})
+		if err := dest.AddIgnore(srcI.Path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// effectiveModule returns the module mod resolves to in f: its replacement, if
+// f replaces it, or mod itself. Version-specific replacements take precedence
+// over path-wide ones.
+func effectiveModule(f *modfile.File, mod module.Version) module.Version {
+	var replacement *module.Version
+	for _, r := range f.Replace {
+		if r.Old.Path != mod.Path {
+			continue
+		}
+		if r.Old.Version == mod.Version {
+			return r.New
+		}
+		if r.Old.Version == "" {
+			replacement = &r.New
+		}
+	}
+	if replacement != nil {
+		return *replacement
+	}
+	return mod
+}
+
+func indirectStr(indirect bool) string {
+	if indirect {
+		return "indirect"
+	}
+	return "direct"
+}
+
+func canCompare(a, b *semver.Version) bool {
+	return (isZero(a) && isZero(b)) || (!isZero(a) && !isZero(b))
+}
+
+func isZero(v *semver.Version) bool {
+	return v.Major() == 0 && v.Minor() == 0 && v.Patch() == 0
+}
@@ -0,0 +1,59 @@
+package transplant
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"golang.org/x/mod/modfile"
+)
+
+// hugeModFile generates a go.mod file of module path with n requirements, a
+// replacement for every tenth and an exclusion for every twentieth. Versions
+// are offset by minor so that two files share modules at different versions.
+func hugeModFile(b *testing.B, path string, n, minor int) *modfile.File {
+	b.Helper()
+	var s strings.Builder
+	fmt.Fprintf(&s, "module %s\n\ngo 1.21\n\nrequire (\n", path)
+	for i := 0; i < n; i++ {
+		fmt.Fprintf(&s, "\texample.com/dep%d v1.%d.0\n", i, minor+i%7)
+	}
+	s.WriteString(")\n\n")
+	for i := 0; i < n; i += 10 {
+		fmt.Fprintf(&s, "replace example.com/dep%d => example.com/fork%d v1.0.0\n", i, i)
+	}
+	for i := 0; i < n; i += 20 {
+		fmt.Fprintf(&s, "exclude example.com/dep%d v0.%d.0\n", i, minor)
+	}
+	f, err := modfile.Parse(path+"/go.mod", []byte(s.String()), nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+	return f
+}
+
+func BenchmarkMerge(b *testing.B) {
+	for _, n := range []int{1000, 5000} {
+		b.Run(fmt.Sprintf("requires=%d", n), func(b *testing.B) {
+			dest := hugeModFile(b, "example.com/dest", n, 1)
+			src := hugeModFile(b, "example.com/src", n, 2)
+			destContent, err := dest.Format()
+			if err != nil {
+				b.Fatal(err)
+			}
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				d, err := modfile.Parse("go.mod", destContent, nil)
+				if err != nil {
+					b.Fatal(err)
+				}
+				b.StartTimer()
+				if _, err := Merge(context.Background(), d, src, WithLogger(nil)); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,211 @@
+package transplant
+
+import (
+	"fmt"
+
+	"golang.org/x/mod/module"
+)
+
+// Report lists what a merge did, in order.
+type Report struct {
+	Entries []Entry
+}
+
+// An Entry records one change to the destination or one conflict. It is one of
+// RequireAdded, RequireUpdated, RequireKept, RequireMadeDirect, RequireDropped,
+// ReplaceAdded, ReplaceUpdated, ReplaceKept, ReplaceDropped, ExcludeAdded,
+// ExcludeSkipped, IgnoreAdded, ConflictResolved or ConflictUnresolved. Its String method
+// gives the line logged for it.
+type Entry interface {
+	fmt.Stringer
+	isEntry()
+}
+
+// Changed reports whether the merge changed the destination.
+func (r *Report) Changed() bool {
+	for _, e := range r.Entries {
+		switch e.(type) {
+		case RequireKept, ReplaceKept, ExcludeSkipped, ConflictResolved, ConflictUnresolved:
+		default:
+			return true
+		}
+	}
+	return false
+}
+
+// Conflicts returns the conflicts the merge could not resolve.
+func (r *Report) Conflicts() []ConflictUnresolved {
+	var conflicts []ConflictUnresolved
+	for _, e := range r.Entries {
+		if c, ok := e.(ConflictUnresolved); ok {
+			conflicts = append(conflicts, c)
+		}
+	}
+	return conflicts
+}
+
+// RequireAdded: the destination now requires a module it didn't before.
+type RequireAdded struct {
+	Module   module.Version
+	Indirect bool
+}
+
+// RequireUpdated: the destination's required version of a module changed.
+type RequireUpdated struct {
+	Path     string
+	Old, New string
+}
+
+// RequireKept: the destination's version was kept over the source's.
+type RequireKept struct {
+	Module module.Version
+	// Src is the version the source requires.
+	Src string
+}
+
+// RequireMadeDirect: an indirect requirement of the destination is direct in
+// the source.
+type RequireMadeDirect struct {
+	Module module.Version
+}
+
+// RequireDropped: the destination no longer requires the source module.
+type RequireDropped struct {
+	Path string
+}
+
+// ReplaceAdded: a replacement was added to the destination.
+type ReplaceAdded struct {
+	Old, New module.Version
+}
+
+// ReplaceUpdated: a replacement of the destination was changed to the source's.
+type ReplaceUpdated struct {
+	Old      module.Version
+	From, To module.Version
+}
+
+// ReplaceKept: the destination's replacement was kept over the source's.
+type ReplaceKept struct {
+	Old      module.Version
+	New, Src module.Version
+}
+
+// ReplaceDropped: a replacement of the source module was removed from the
+// destination.
+type ReplaceDropped struct {
+	Old module.Version
+}
+
+// ExcludeAdded: an exclusion was added to the destination.
+type ExcludeAdded struct {
+	Module module.Version
+}
+
+// ExcludeSkipped: an exclusion of the source was not added because the
+// destination requires the excluded version.
+type ExcludeSkipped struct {
+	Module module.Version
+}
+
+// IgnoreAdded: an ignore directive was added to the destination.
+type IgnoreAdded struct {
+	Path string
+}
+
+// ConflictResolved: a resolver decided on a conflict.
+type ConflictResolved struct {
+	Conflict   Conflict
+	Resolution Resolution
+}
+
+// ConflictUnresolved: a conflict could not be resolved, failing the merge.
+type ConflictUnresolved struct {
+	Conflict Conflict
+	Err      error
+}
+
+func (e RequireAdded) String() string {
+	return fmt.Sprintf("(require) add new: %s (%s)", e.Module, indirectStr(e.Indirect))
+}
+
+func (e RequireUpdated) String() string {
+	return fmt.Sprintf("(require) replace version: %s %s -> %s", e.Path, e.Old, e.New)
+}
+
+func (e RequireKept) String() string {
+	return fmt.Sprintf("(require) keep version: %s (src %s)", e.Module, e.Src)
+}
+
+func (e RequireMadeDirect) String() string {
+	return fmt.Sprintf("(require) make direct: %s", e.Module)
+}
+
+func (e RequireDropped) String() string {
+	return fmt.Sprintf("(require) drop: %s", e.Path)
+}
+
+func (e ReplaceAdded) String() string {
+	return fmt.Sprintf("(replace) add new: %s -> %s", e.Old, e.New)
+}
+
+func (e ReplaceUpdated) String() string {
+	return fmt.Sprintf("(replace) replace: %s -> %s (was %s)", e.Old, e.To, e.From)
+}
+
+func (e ReplaceKept) String() string {
+	return fmt.Sprintf("(replace) keep: %s -> %s (src %s)", e.Old, e.New, e.Src)
+}
+
+func (e ReplaceDropped) String() string {
+	return fmt.Sprintf("(replace) drop: %s", e.Old)
+}
+
+func (e ExcludeAdded) String() string {
+	return fmt.Sprintf("(exclude) add new: %s", e.Module)
+}
+
+func (e ExcludeSkipped) String() string {
+	return fmt.Sprintf("(exclude) skip required: %s", e.Module)
+}
+
+func (e IgnoreAdded) String() string {
+	return fmt.Sprintf("(ignore) add new: %s", e.Path)
+}
+
+func (e ConflictResolved) String() string {
+	return fmt.Sprintf("(conflict) %s: %s", e.Conflict, e.Resolution)
+}
+
+func (e ConflictUnresolved) String() string {
+	return fmt.Sprintf("(conflict) %s: unresolved", e.Conflict)
+}
+
+func (RequireAdded) isEntry()       {}
+func (RequireUpdated) isEntry()     {}
+func (RequireKept) isEntry()        {}
+func (RequireMadeDirect) isEntry()  {}
+func (RequireDropped) isEntry()     {}
+func (ReplaceAdded) isEntry()       {}
+func (ReplaceUpdated) isEntry()     {}
+func (ReplaceKept) isEntry()        {}
+func (ReplaceDropped) isEntry()     {}
+func (ExcludeAdded) isEntry()       {}
+func (ExcludeSkipped) isEntry()     {}
+func (IgnoreAdded) isEntry()        {}
+func (ConflictResolved) isEntry()   {}
+func (ConflictUnresolved) isEntry() {}
+
+// Step is one comparison or rule evaluation of a merge, passed to the function
+// set with WithDebug. Rule names what was evaluated, e.g. "lookup", "equal",
+// "less-than" or "resolver". Dest and Src are the destination's and source's
+// sides of the comparison, such as versions or replacements, and Result its
+// outcome.
+type Step struct {
+	Kind   string `json:"kind"`
+	Path   string `json:"path"`
+	Rule   string `json:"rule"`
+	Dest   string `json:"dest,omitempty"`
+	Src    string `json:"src,omitempty"`
+	Result string `json:"result"`
+}
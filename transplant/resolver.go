@@ -0,0 +1,114 @@
+package transplant
+
+import (
+	"context"
+	"fmt"
+)
+
+// ConflictKind names the kind of statement a conflict is about.
+type ConflictKind string
+
+const (
+	// RequireConflict: the source and destination require different versions
+	// of a module.
+	RequireConflict ConflictKind = "require"
+	// ReplaceConflict: the source and destination replace the same module
+	// version with different targets.
+	ReplaceConflict ConflictKind = "replace"
+	// ExcludeConflict: the source excludes the version of a module the
+	// destination requires.
+	ExcludeConflict ConflictKind = "exclude"
+)
+
+// Conflict describes a module that the source and destination disagree on.
+type Conflict struct {
+	Kind ConflictKind `json:"kind"`
+	Path string       `json:"path"`
+	// DestVersion and SrcVersion are the required versions for require
+	// conflicts, the replaced versions for replace conflicts, and the required
+	// and excluded version for exclude conflicts.
+	DestVersion string `json:"dest_version,omitempty"`
+	SrcVersion  string `json:"src_version,omitempty"`
+	// DestIndirect and SrcIndirect are only set for require conflicts.
+	DestIndirect bool `json:"dest_indirect,omitempty"`
+	SrcIndirect  bool `json:"src_indirect,omitempty"`
+	// DestReplacement and SrcReplacement are set for replace conflicts, and
+	// for require conflicts of modules either file replaces, as "path" or
+	// "path@version".
+	DestReplacement string `json:"dest_replacement,omitempty"`
+	SrcReplacement  string `json:"src_replacement,omitempty"`
+}
+
+func (c Conflict) String() string {
+	return fmt.Sprintf("%s %s", c.Kind, c.Path)
+}
+
+// Resolution is the outcome a Resolver chooses for a conflict.
+type Resolution string
+
+const (
+	// Highest applies the default rules: requirements are raised to the
+	// higher version, differing replacements are an error and exclusions are
+	// added.
+	Highest Resolution = "highest"
+	// KeepDest leaves the destination as it is.
+	KeepDest Resolution = "keep-dest"
+	// TakeSrc adopts the source's version, replacement or exclusion.
+	TakeSrc Resolution = "take-src"
+	// Fail fails the merge.
+	Fail Resolution = "fail"
+)
+
+// Valid reports whether r is one of the defined resolutions.
+func (r Resolution) Valid() bool {
+	switch r {
+	case Highest, KeepDest, TakeSrc, Fail:
+		return true
+	}
+	return false
+}
+
+// A Resolver decides how each conflict found during a merge is resolved.
+// Embedding programs can implement arbitrary logic, including consulting their
+// own databases; returning an error fails the merge.
+type Resolver interface {
+	Resolve(ctx context.Context, c Conflict) (Resolution, error)
+}
+
+// ResolverFunc adapts a function to the Resolver interface.
+type ResolverFunc func(ctx context.Context, c Conflict) (Resolution, error)
+
+func (f ResolverFunc) Resolve(ctx context.Context, c Conflict) (Resolution, error) {
+	return f(ctx, c)
+}
+
+// resolve asks the resolver, if any, about c, falling back to the strategy.
+func (m *merger) resolve(ctx context.Context, c Conflict) (Resolution, error) {
+	r, err := m.opts.strategy, error(nil)
+	if m.opts.resolver != nil {
+		r, err = m.opts.resolver.Resolve(ctx, c)
+		result := string(r)
+		if err != nil {
+			result = "error: " + err.Error()
+		}
+		m.step(Step{Kind: string(c.Kind), Path: c.Path, Rule: "resolver", Dest: c.DestVersion, Src: c.SrcVersion, Result: result})
+	} else if r == Highest {
+		return r, nil
+	}
+	switch {
+	case err != nil:
+		return "", m.unresolved(c, err)
+	case r == Fail:
+		return "", m.unresolved(c, &PolicyViolationError{Conflict: c})
+	case !r.Valid():
+		return "", m.unresolved(c, fmt.Errorf("%s: unknown resolution %q", c, r))
+	}
+	m.record(ConflictResolved{Conflict: c, Resolution: r})
+	return r, nil
+}
+
+// unresolved records that c could not be resolved and returns err.
+func (m *merger) unresolved(c Conflict, err error) error {
+	m.record(ConflictUnresolved{Conflict: c, Err: err})
+	return err
+}
@@ -0,0 +1,477 @@
+package transplant_test
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/mod/module"
+
+	"github.com/brettbuddin/modtransplant/transplant"
+	"github.com/brettbuddin/modtransplant/transplant/transplanttest"
+)
+
+// TestMergeRules merges each source into its destination and compares the
+// merged destination and the log of the merge with testdata/rules/<name>.golden.
+func TestMergeRules(t *testing.T) {
+	fetcher := transplant.WithFetcher(&transplanttest.Fetcher{Mods: map[string]string{
+		"example.com/x@v1.2.0":       "",
+		"example.com/x@v1.2.1":       "",
+		"example.com/x@v1.3.0-rc.1":  "",
+		"example.com/x@v1.3.0":       "",
+		"example.com/pre@v1.0.0-a.1": "",
+		"example.com/pre@v1.0.0-a.2": "",
+		"example.com/pre@v1.0.0":     "",
+	}})
+	for _, tt := range []struct {
+		name      string
+		dest, src string
+		opts      []transplant.Option
+		// err is the sentinel the merge must fail with, if any.
+		err error
+	}{
+		{
+			name: "require-add",
+			dest: `
+				module example.com/app
+			`,
+			src: `
+				module example.com/lib
+
+				require (
+					example.com/a v1.0.0
+					example.com/b v1.1.0 // indirect
+				)
+			`,
+		},
+		{
+			name: "require-raise",
+			dest: `
+				module example.com/app
+
+				require example.com/a v1.0.0
+			`,
+			src: `
+				module example.com/lib
+
+				require example.com/a v1.2.0
+			`,
+		},
+		{
+			name: "require-keep-higher",
+			dest: `
+				module example.com/app
+
+				require example.com/a v1.3.0
+			`,
+			src: `
+				module example.com/lib
+
+				require example.com/a v1.2.0
+			`,
+		},
+		{
+			name: "require-force-overwrite",
+			dest: `
+				module example.com/app
+
+				require example.com/a v1.3.0
+			`,
+			src: `
+				module example.com/lib
+
+				require example.com/a v1.2.0
+			`,
+			opts: []transplant.Option{transplant.WithForceOverwrite(true)},
+		},
+		{
+			name: "require-make-direct",
+			dest: `
+				module example.com/app
+
+				require example.com/a v1.0.0 // indirect
+			`,
+			src: `
+				module example.com/lib
+
+				require example.com/a v1.1.0
+			`,
+		},
+		{
+			name: "require-resolver-keep-dest",
+			dest: `
+				module example.com/app
+
+				require example.com/a v1.0.0
+			`,
+			src: `
+				module example.com/lib
+
+				require example.com/a v1.2.0
+			`,
+			opts: []transplant.Option{transplant.WithResolver(transplant.ResolverFunc(func(ctx context.Context, c transplant.Conflict) (transplant.Resolution, error) {
+				return transplant.KeepDest, nil
+			}))},
+		},
+		{
+			name: "require-filter",
+			dest: `
+				module example.com/app
+			`,
+			src: `
+				module example.com/lib
+
+				require (
+					example.com/a v1.0.0
+					other.org/b v1.0.0
+				)
+			`,
+			opts: []transplant.Option{transplant.WithFilter(func(m module.Version) bool {
+				return strings.HasPrefix(m.Path, "example.com/")
+			})},
+		},
+		{
+			name: "source-module",
+			dest: `
+				module example.com/app
+
+				require (
+					example.com/a v1.0.0
+					example.com/lib v0.1.0
+				)
+
+				replace example.com/lib => ../lib
+			`,
+			src: `
+				module example.com/lib
+
+				require example.com/a v1.0.0
+			`,
+		},
+		{
+			name: "absorbed",
+			dest: `
+				module example.com/app
+
+				require example.com/lib/sub v0.1.0
+
+				replace example.com/lib/sub => ../lib/sub
+			`,
+			src: `
+				module example.com/lib
+
+				require (
+					example.com/a v1.0.0
+					example.com/lib/sub v0.2.0
+				)
+
+				replace example.com/lib/sub => ./sub
+			`,
+			opts: []transplant.Option{transplant.WithAbsorbed(func(path string) bool {
+				return path == "example.com/lib/sub"
+			})},
+		},
+		{
+			name: "replace-add-and-match",
+			dest: `
+				module example.com/app
+
+				replace example.com/a => example.com/fork v1.0.0
+			`,
+			src: `
+				module example.com/lib
+
+				replace (
+					example.com/a => example.com/fork v1.0.0
+					example.com/b v1.0.0 => ../b
+				)
+			`,
+		},
+		{
+			name: "replace-conflict",
+			dest: `
+				module example.com/app
+
+				replace example.com/a => example.com/fork v1.0.0
+			`,
+			src: `
+				module example.com/lib
+
+				replace example.com/a => example.com/fork v1.1.0
+			`,
+			err: transplant.ErrReplaceConflict,
+		},
+		{
+			name: "replace-take-src",
+			dest: `
+				module example.com/app
+
+				replace example.com/a => example.com/fork v1.0.0
+			`,
+			src: `
+				module example.com/lib
+
+				replace example.com/a => example.com/fork v1.1.0
+			`,
+			opts: []transplant.Option{transplant.WithStrategy(transplant.TakeSrc)},
+		},
+		{
+			name: "exclude-add",
+			dest: `
+				module example.com/app
+
+				require example.com/a v1.2.0
+
+				exclude example.com/a v1.0.0
+			`,
+			src: `
+				module example.com/lib
+
+				exclude (
+					example.com/a v1.0.0
+					example.com/a v1.1.0
+				)
+			`,
+		},
+		{
+			name: "exclude-required-keep-dest",
+			dest: `
+				module example.com/app
+
+				require example.com/a v1.2.0
+			`,
+			src: `
+				module example.com/lib
+
+				exclude example.com/a v1.2.0
+			`,
+			opts: []transplant.Option{transplant.WithStrategy(transplant.KeepDest)},
+		},
+		{
+			name: "ignore",
+			dest: `
+				module example.com/app
+
+				ignore ./node_modules
+			`,
+			src: `
+				module example.com/lib
+
+				ignore (
+					./node_modules
+					./testdata/huge
+				)
+			`,
+		},
+		{
+			name: "add-only",
+			dest: `
+				module example.com/app
+
+				require (
+					example.com/a v1.0.0
+					example.com/b v1.0.0 // indirect
+				)
+			`,
+			src: `
+				module example.com/lib
+
+				require (
+					example.com/a v1.2.0
+					example.com/b v1.1.0
+					example.com/c v1.0.0
+				)
+			`,
+			opts: []transplant.Option{transplant.WithAddOnly(true)},
+			err:  transplant.ErrExistingEntry,
+		},
+		{
+			name: "add-only-excluded-version",
+			dest: `
+				module example.com/app
+
+				require example.com/x v1.2.0
+			`,
+			src: `
+				module example.com/lib
+
+				require example.com/x v1.2.0
+
+				exclude example.com/x v1.2.0
+			`,
+			opts: []transplant.Option{transplant.WithAddOnly(true), fetcher},
+			err:  transplant.ErrExistingEntry,
+		},
+		{
+			name: "excluded-version-next",
+			dest: `
+				module example.com/app
+
+				require example.com/x v1.0.0
+
+				exclude example.com/x v1.2.1
+			`,
+			src: `
+				module example.com/lib
+
+				require (
+					example.com/pre v1.0.0-a.1
+					example.com/x v1.2.0
+				)
+
+				exclude (
+					example.com/pre v1.0.0-a.1
+					example.com/x v1.2.0
+				)
+			`,
+			opts: []transplant.Option{fetcher},
+		},
+		{
+			name: "excluded-version-no-lister",
+			dest: `
+				module example.com/app
+			`,
+			src: `
+				module example.com/lib
+
+				require example.com/x v1.2.0
+
+				exclude example.com/x v1.2.0
+			`,
+			err: transplant.ErrExcludedVersion,
+		},
+		{
+			name: "excluded-version-none-higher",
+			dest: `
+				module example.com/app
+
+				exclude example.com/x v1.3.0
+			`,
+			src: `
+				module example.com/lib
+
+				require example.com/x v1.2.1
+
+				exclude example.com/x v1.2.1
+			`,
+			opts: []transplant.Option{fetcher},
+			err:  transplant.ErrExcludedVersion,
+		},
+		{
+			name: "effective-same-replacement",
+			dest: `
+				module example.com/app
+
+				require example.com/a v1.0.0
+
+				replace example.com/a => example.com/fork v1.5.0
+			`,
+			src: `
+				module example.com/lib
+
+				require example.com/a v1.2.0
+
+				replace example.com/a => example.com/fork v1.5.0
+			`,
+		},
+		{
+			name: "effective-replacement-versions",
+			dest: `
+				module example.com/app
+
+				require example.com/a v1.3.0
+
+				replace example.com/a => example.com/fork v1.0.0
+			`,
+			src: `
+				module example.com/lib
+
+				require example.com/a v1.2.0
+
+				replace example.com/a v1.2.0 => example.com/fork v1.1.0
+			`,
+		},
+		{
+			name: "effective-fork-and-upstream",
+			dest: `
+				module example.com/app
+
+				require example.com/a v1.0.0
+			`,
+			src: `
+				module example.com/lib
+
+				require example.com/a v1.2.0
+
+				replace example.com/a => example.com/fork v1.5.0
+			`,
+			err: transplant.ErrVersionConflict,
+		},
+		{
+			name: "effective-local-directory",
+			dest: `
+				module example.com/app
+
+				require example.com/x v1.0.0
+			`,
+			src: `
+				module example.com/lib
+
+				require example.com/x v1.2.0
+
+				replace example.com/x => ../x
+			`,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			r := transplanttest.Merge(t, tt.dest, tt.src, tt.opts...)
+			switch {
+			case tt.err == nil && r.Err != nil:
+				t.Fatalf("merge failed: %v", r.Err)
+			case tt.err != nil && !errors.Is(r.Err, tt.err):
+				t.Fatalf("merge failed with %v, want %v", r.Err, tt.err)
+			}
+			transplanttest.Golden(t, filepath.Join("testdata", "rules", tt.name+".golden"), []byte(string(r.Dest)+"-- log --\n"+r.Log()))
+		})
+	}
+}
+
+func TestMergeErrors(t *testing.T) {
+	ctx := context.Background()
+
+	dest := transplanttest.Mod(t, "go.mod", "module example.com/app\n")
+	src := transplanttest.Mod(t, "src/go.mod", "require example.com/a v1.0.0\n")
+	if _, err := transplant.Merge(ctx, dest, src, transplant.WithLogger(nil)); !errors.Is(err, transplant.ErrNoModule) {
+		t.Errorf("merging a source without a module directive: %v, want ErrNoModule", err)
+	}
+
+	// Parsing rejects invalid versions, so one is set afterwards, like an
+	// embedding program building files in memory could.
+	dest = transplanttest.Mod(t, "go.mod", "module example.com/app\n\nrequire example.com/a v1.0.0\n")
+	dest.Require[0].Mod.Version = "master"
+	src = transplanttest.Mod(t, "src/go.mod", "module example.com/lib\n\nrequire example.com/a v1.2.0\n")
+	_, err := transplant.Merge(ctx, dest, src, transplant.WithLogger(nil))
+	if !errors.Is(err, transplant.ErrInvalidVersion) {
+		t.Errorf("merging an invalid version: %v, want ErrInvalidVersion", err)
+	}
+	var merr *transplant.MergeError
+	if !errors.As(err, &merr) || len(merr.Problems) != 1 || merr.Problems[0].Dest != "go.mod:3" || merr.Problems[0].Src != "src/go.mod:3" {
+		t.Errorf("merging an invalid version: %#v, want one problem at go.mod:3 and src/go.mod:3", err)
+	}
+
+	r := transplanttest.Merge(t, `
+		module example.com/app
+
+		require example.com/a v1.0.0
+	`, `
+		module example.com/lib
+
+		require example.com/a v1.2.0
+	`, transplant.WithStrategy(transplant.Fail))
+	var perr *transplant.PolicyViolationError
+	if !errors.As(r.Err, &perr) || perr.Conflict.Kind != transplant.RequireConflict || perr.Conflict.Path != "example.com/a" {
+		t.Errorf("merging with the Fail strategy: %v, want a PolicyViolationError about example.com/a", r.Err)
+	}
+}
@@ -0,0 +1,189 @@
+// Package transplanttest provides helpers for testing programs that embed the
+// transplant package: building go.mod fixtures, running merges, faking module
+// lookups and comparing outputs with golden files.
+package transplanttest
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/mod/modfile"
+	"golang.org/x/mod/semver"
+
+	"github.com/brettbuddin/modtransplant/transplant"
+)
+
+// update makes Golden write the golden files instead of comparing with them.
+var update = flag.Bool("transplanttest.update", false, "rewrite the golden files of transplanttest.Golden")
+
+// Mod parses content as a go.mod file named name, failing t if it doesn't
+// parse. Leading tabs common to all lines are trimmed, so fixtures can be
+// indented raw strings.
+func Mod(t testing.TB, name, content string) *modfile.File {
+	t.Helper()
+	f, err := modfile.Parse(name, []byte(dedent(content)), nil)
+	if err != nil {
+		t.Fatalf("parsing fixture %s: %v", name, err)
+	}
+	return f
+}
+
+// dedent trims the tabs common to the non-blank lines of s, and empties the
+// blank ones.
+func dedent(s string) string {
+	lines := strings.Split(s, "\n")
+	indent := -1
+	for _, l := range lines {
+		if strings.TrimSpace(l) == "" {
+			continue
+		}
+		if n := len(l) - len(strings.TrimLeft(l, "\t")); indent < 0 || n < indent {
+			indent = n
+		}
+	}
+	for i, l := range lines {
+		switch {
+		case strings.TrimSpace(l) == "":
+			lines[i] = ""
+		case indent > 0:
+			lines[i] = l[indent:]
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Result is the outcome of a merge run by Merge.
+type Result struct {
+	// Dest is the formatted destination after the merge.
+	Dest   []byte
+	Report *transplant.Report
+	// Err is the error the merge returned, such as a *transplant.MergeError.
+	Err error
+}
+
+// Log returns the lines the merge logged, one per report entry, followed by
+// the error, if any. It is the text usually compared with a golden file.
+func (r Result) Log() string {
+	var b strings.Builder
+	if r.Report != nil {
+		for _, e := range r.Report.Entries {
+			fmt.Fprintln(&b, e)
+		}
+	}
+	if r.Err != nil {
+		fmt.Fprintf(&b, "error: %v\n", r.Err)
+	}
+	return b.String()
+}
+
+// Merge merges the go.mod fixture src into the fixture dest with opts, as
+// parsed by Mod, and returns the result. The merge logs nothing unless opts
+// set a logger. Merge errors are returned in the result rather than failing t,
+// so tests can assert on them.
+func Merge(t testing.TB, dest, src string, opts ...transplant.Option) Result {
+	t.Helper()
+	d := Mod(t, "go.mod", dest)
+	s := Mod(t, "src/go.mod", src)
+	report, err := transplant.Merge(context.Background(), d, s, append([]transplant.Option{transplant.WithLogger(nil)}, opts...)...)
+	d.Cleanup()
+	out, ferr := d.Format()
+	if ferr != nil {
+		t.Fatalf("formatting merged destination: %v", ferr)
+	}
+	return Result{Dest: out, Report: report, Err: err}
+}
+
+// Golden compares got with the golden file at path, relative to the package
+// directory of the test (usually under testdata). When the test is run with
+// -transplanttest.update, the file is written with got instead.
+func Golden(t testing.TB, path string, got []byte) {
+	t.Helper()
+	if *update {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, got, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("%v (run with -transplanttest.update to create it)", err)
+	}
+	if string(got) == string(want) {
+		return
+	}
+	gotLines, wantLines := strings.Split(string(got), "\n"), strings.Split(string(want), "\n")
+	for i := 0; ; i++ {
+		var g, w string
+		if i < len(gotLines) {
+			g = gotLines[i]
+		}
+		if i < len(wantLines) {
+			w = wantLines[i]
+		}
+		if g != w || i >= len(gotLines) || i >= len(wantLines) {
+			t.Fatalf("%s:%d differs:\n\tgot:  %q\n\twant: %q\n(run with -transplanttest.update to accept the output)", path, i+1, g, w)
+		}
+	}
+}
+
+// Fetcher is a transplant.ModuleFetcher answering from memory, for tests of
+// resolvers and merges that look up module versions. Mods maps "path@version"
+// to the content of the go.mod file of that version; an empty content stands
+// for a go.mod file with just the module directive.
+type Fetcher struct {
+	Mods map[string]string
+}
+
+func (f *Fetcher) Info(ctx context.Context, path, version string) (*transplant.VersionInfo, error) {
+	if _, ok := f.Mods[path+"@"+version]; !ok {
+		return nil, fmt.Errorf("%s@%s: %w", path, version, transplant.ErrNotFound)
+	}
+	return &transplant.VersionInfo{Version: version}, nil
+}
+
+// Latest returns the highest release of path, or its highest pre-release if
+// it has no release.
+func (f *Fetcher) Latest(ctx context.Context, path string) (string, error) {
+	versions, _ := f.List(ctx, path)
+	if len(versions) == 0 {
+		return "", fmt.Errorf("%s@latest: %w", path, transplant.ErrNotFound)
+	}
+	for i := len(versions) - 1; i >= 0; i-- {
+		if semver.Prerelease(versions[i]) == "" {
+			return versions[i], nil
+		}
+	}
+	return versions[len(versions)-1], nil
+}
+
+func (f *Fetcher) GoMod(ctx context.Context, path, version string) ([]byte, error) {
+	content, ok := f.Mods[path+"@"+version]
+	if !ok {
+		return nil, fmt.Errorf("%s@%s: %w", path, version, transplant.ErrNotFound)
+	}
+	if content == "" {
+		content = "module " + path + "\n"
+	}
+	return []byte(dedent(content)), nil
+}
+
+// List returns the versions of path in Mods, in semver order.
+func (f *Fetcher) List(ctx context.Context, path string) ([]string, error) {
+	var versions []string
+	for key := range f.Mods {
+		if p, v, _ := strings.Cut(key, "@"); p == path {
+			versions = append(versions, v)
+		}
+	}
+	semver.Sort(versions)
+	return versions, nil
+}
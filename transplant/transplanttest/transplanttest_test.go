@@ -0,0 +1,164 @@
+package transplanttest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/brettbuddin/modtransplant/transplant"
+)
+
+func TestDedent(t *testing.T) {
+	for _, tt := range []struct {
+		name, in, want string
+	}{
+		{
+			name: "common tabs",
+			in:   "\n\t\tmodule a\n\n\t\trequire (\n\t\t\tb v1.0.0\n\t\t)\n\t",
+			want: "\nmodule a\n\nrequire (\n\tb v1.0.0\n)\n",
+		},
+		{
+			name: "blank lines with other whitespace",
+			in:   "\t\tmodule a\n  \n\t\tgo 1.21\n",
+			want: "module a\n\ngo 1.21\n",
+		},
+		{
+			name: "a line indented with spaces",
+			in:   "\tmodule a\n    go 1.21\n",
+			want: "\tmodule a\n    go 1.21\n",
+		},
+		{
+			name: "tabs after spaces",
+			in:   "\t\tmodule a\n \tgo 1.21\n",
+			want: "\t\tmodule a\n \tgo 1.21\n",
+		},
+	} {
+		if got := dedent(tt.in); got != tt.want {
+			t.Errorf("%s: dedent(%q) = %q, want %q", tt.name, tt.in, got, tt.want)
+		}
+	}
+}
+
+// fatalTB records the failure of a test helper instead of failing the test.
+type fatalTB struct {
+	testing.TB
+	failure string
+}
+
+func (t *fatalTB) Helper() {}
+
+func (t *fatalTB) Fatal(args ...interface{}) {
+	t.failure = fmt.Sprint(args...)
+	runtime.Goexit()
+}
+
+func (t *fatalTB) Fatalf(format string, args ...interface{}) {
+	t.failure = fmt.Sprintf(format, args...)
+	runtime.Goexit()
+}
+
+// golden runs Golden with a fatalTB, returning the failure if any.
+func golden(t *testing.T, path string, got []byte) string {
+	tb := &fatalTB{TB: t}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		Golden(tb, path, got)
+	}()
+	<-done
+	return tb.failure
+}
+
+func TestGolden(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sub", "out.golden")
+
+	if failure := golden(t, path, []byte("a\n")); failure == "" {
+		t.Fatal("comparing with a missing golden file succeeded")
+	}
+
+	defer func(u bool) { *update = u }(*update)
+	*update = true
+	if failure := golden(t, path, []byte("a\nb\n")); failure != "" {
+		t.Fatalf("updating: %s", failure)
+	}
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != "a\nb\n" {
+		t.Fatalf("updated golden file = %q, want %q", content, "a\nb\n")
+	}
+
+	*update = false
+	if failure := golden(t, path, []byte("a\nb\n")); failure != "" {
+		t.Errorf("comparing equal output: %s", failure)
+	}
+	failure := golden(t, path, []byte("a\nc\n"))
+	if want := path + ":2 differs"; len(failure) < len(want) || failure[:len(want)] != want {
+		t.Errorf("comparing different output failed with %q, want it to start with %q", failure, want)
+	}
+	if failure := golden(t, path, []byte("a\n")); failure == "" {
+		t.Error("comparing shorter output succeeded")
+	}
+}
+
+func TestFetcherLatest(t *testing.T) {
+	f := &Fetcher{Mods: map[string]string{
+		"example.com/a@v1.0.0":      "",
+		"example.com/a@v1.2.0":      "",
+		"example.com/a@v1.3.0-rc.1": "",
+		"example.com/b@v0.1.0-pre":  "",
+		"example.com/b@v0.2.0-pre":  "",
+	}}
+	for path, want := range map[string]string{
+		"example.com/a": "v1.2.0",
+		"example.com/b": "v0.2.0-pre",
+	} {
+		got, err := f.Latest(context.Background(), path)
+		if err != nil || got != want {
+			t.Errorf("Latest(%s) = %q, %v, want %q", path, got, err, want)
+		}
+	}
+	if _, err := f.Latest(context.Background(), "example.com/missing"); !errors.Is(err, transplant.ErrNotFound) {
+		t.Errorf("Latest of a missing module = %v, want ErrNotFound", err)
+	}
+}
+
+func TestMerge(t *testing.T) {
+	r := Merge(t, `
+		module example.com/app
+
+		go 1.21
+
+		require (
+			example.com/a v1.0.0
+			example.com/b v1.1.0 // indirect
+		)
+
+		exclude example.com/c v1.2.0
+	`, `
+		module example.com/lib
+
+		go 1.21
+
+		require (
+			example.com/a v1.1.0
+			example.com/b v1.0.0
+			example.com/c v1.2.0
+		)
+
+		replace example.com/d => ../d
+	`, transplant.WithFetcher(&Fetcher{Mods: map[string]string{
+		"example.com/c@v1.2.0": "",
+		"example.com/c@v1.2.1": "",
+	}}))
+	if r.Err != nil {
+		t.Fatal(r.Err)
+	}
+	Golden(t, "testdata/merge.golden", r.Dest)
+	Golden(t, "testdata/merge.log.golden", []byte(r.Log()))
+}
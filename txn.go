@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// transaction stages the changes a merge makes to the working tree, so they
+// are applied together, and undone if any of them fails, instead of leaving
+// the tree half updated.
+type transaction struct {
+	files []stagedFile
+	steps []stagedStep
+}
+
+type stagedFile struct {
+	path    string
+	content []byte
+}
+
+// stagedStep is a command changing the tree below dir (or the file dir), run
+// once the files are written.
+type stagedStep struct {
+	dir string
+	run func(ctx context.Context) error
+}
+
+// stage stages writing content to path.
+func (t *transaction) stage(path string, content []byte) {
+	t.files = append(t.files, stagedFile{path, content})
+}
+
+// stageStep stages running a command that changes the tree below dir.
+func (t *transaction) stageStep(dir string, run func(ctx context.Context) error) {
+	t.steps = append(t.steps, stagedStep{dir, run})
+}
+
+// commit writes the staged files and runs the staged steps. If anything
+// fails, every file and directory is restored to what it was.
+func (t *transaction) commit(ctx context.Context) (err error) {
+	var (
+		undo     []func() error
+		discards []func()
+	)
+	defer func() {
+		defer func() {
+			for _, discard := range discards {
+				discard()
+			}
+		}()
+		if err == nil {
+			return
+		}
+		fmt.Fprintln(os.Stderr, "(transaction) rolling back")
+		var failed []string
+		for i := len(undo) - 1; i >= 0; i-- {
+			if uerr := undo[i](); uerr != nil {
+				failed = append(failed, uerr.Error())
+			}
+		}
+		if len(failed) > 0 {
+			err = fmt.Errorf("%w\nrollback failed:\n\t%s", err, strings.Join(failed, "\n\t"))
+		}
+	}()
+
+	for _, f := range t.files {
+		restore, err := backupFile(f.path)
+		if err != nil {
+			return err
+		}
+		undo = append(undo, restore)
+		perm := os.FileMode(0o644)
+		if info, err := os.Stat(f.path); err == nil {
+			perm = info.Mode().Perm()
+		}
+		if err := ioutil.WriteFile(f.path, f.content, perm); err != nil {
+			return err
+		}
+	}
+	for _, s := range t.steps {
+		restore, discard, err := backupDir(s.dir)
+		if err != nil {
+			return err
+		}
+		discards = append(discards, discard)
+		undo = append(undo, restore)
+		if err := s.run(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// backupFile returns a function restoring path to its current content, or
+// removing it if it doesn't exist yet.
+func backupFile(path string) (func() error, error) {
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return func() error {
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			return nil
+		}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return func() error {
+		return ioutil.WriteFile(path, content, info.Mode().Perm())
+	}, nil
+}
+
+// backupDir copies dir aside and returns a function putting the copy back in
+// its place, or removing dir if it doesn't exist yet, and one discarding the
+// copy. A file is kept in memory instead.
+func backupDir(dir string) (restore func() error, discard func(), err error) {
+	info, err := os.Stat(dir)
+	if os.IsNotExist(err) {
+		return func() error { return os.RemoveAll(dir) }, func() {}, nil
+	} else if err != nil {
+		return nil, nil, err
+	}
+	if !info.IsDir() {
+		restore, err := backupFile(dir)
+		return restore, func() {}, err
+	}
+	tmp, err := ioutil.TempDir("", "modtransplant-backup-")
+	if err != nil {
+		return nil, nil, err
+	}
+	discard = func() { os.RemoveAll(tmp) }
+	if err := copyTree(dir, tmp); err != nil {
+		discard()
+		return nil, nil, err
+	}
+	return func() error {
+		if err := os.RemoveAll(dir); err != nil {
+			return err
+		}
+		return copyTree(tmp, dir)
+	}, discard, nil
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+
+	"golang.org/x/mod/modfile"
+	"golang.org/x/mod/module"
+)
+
+// popularModules are widely used modules whose paths typosquats imitate.
+// -popular-modules adds to them.
+var popularModules = []string{
+	"cloud.google.com/go",
+	"github.com/alecthomas/kingpin",
+	"github.com/aws/aws-sdk-go",
+	"github.com/aws/aws-sdk-go-v2",
+	"github.com/beorn7/perks",
+	"github.com/cespare/xxhash/v2",
+	"github.com/cheggaaa/pb",
+	"github.com/davecgh/go-spew",
+	"github.com/dgrijalva/jwt-go",
+	"github.com/docker/docker",
+	"github.com/fatih/color",
+	"github.com/fsnotify/fsnotify",
+	"github.com/gin-gonic/gin",
+	"github.com/go-chi/chi",
+	"github.com/go-kit/kit",
+	"github.com/go-logr/logr",
+	"github.com/go-redis/redis",
+	"github.com/go-sql-driver/mysql",
+	"github.com/go-yaml/yaml",
+	"github.com/gofrs/uuid",
+	"github.com/gogo/protobuf",
+	"github.com/golang-jwt/jwt",
+	"github.com/golang/glog",
+	"github.com/golang/mock",
+	"github.com/golang/protobuf",
+	"github.com/google/go-cmp",
+	"github.com/google/uuid",
+	"github.com/gorilla/mux",
+	"github.com/gorilla/websocket",
+	"github.com/grpc-ecosystem/grpc-gateway",
+	"github.com/hashicorp/consul/api",
+	"github.com/hashicorp/go-multierror",
+	"github.com/hashicorp/hcl",
+	"github.com/hashicorp/vault/api",
+	"github.com/jackc/pgx",
+	"github.com/jmoiron/sqlx",
+	"github.com/json-iterator/go",
+	"github.com/labstack/echo",
+	"github.com/lib/pq",
+	"github.com/mattn/go-isatty",
+	"github.com/mattn/go-sqlite3",
+	"github.com/mitchellh/mapstructure",
+	"github.com/onsi/ginkgo",
+	"github.com/onsi/gomega",
+	"github.com/opentracing/opentracing-go",
+	"github.com/pkg/errors",
+	"github.com/pmezard/go-difflib",
+	"github.com/prometheus/client_golang",
+	"github.com/prometheus/common",
+	"github.com/redis/go-redis/v9",
+	"github.com/rs/zerolog",
+	"github.com/sirupsen/logrus",
+	"github.com/spf13/afero",
+	"github.com/spf13/cast",
+	"github.com/spf13/cobra",
+	"github.com/spf13/pflag",
+	"github.com/spf13/viper",
+	"github.com/stretchr/objx",
+	"github.com/stretchr/testify",
+	"github.com/urfave/cli",
+	"github.com/valyala/fasthttp",
+	"go.etcd.io/etcd/client/v3",
+	"go.mongodb.org/mongo-driver",
+	"go.opentelemetry.io/otel",
+	"go.uber.org/atomic",
+	"go.uber.org/multierr",
+	"go.uber.org/zap",
+	"golang.org/x/crypto",
+	"golang.org/x/mod",
+	"golang.org/x/net",
+	"golang.org/x/oauth2",
+	"golang.org/x/sync",
+	"golang.org/x/sys",
+	"golang.org/x/text",
+	"golang.org/x/time",
+	"golang.org/x/tools",
+	"google.golang.org/api",
+	"google.golang.org/genproto",
+	"google.golang.org/grpc",
+	"google.golang.org/protobuf",
+	"gopkg.in/check.v1",
+	"gopkg.in/yaml.v2",
+	"gopkg.in/yaml.v3",
+	"gorm.io/gorm",
+	"k8s.io/api",
+	"k8s.io/apimachinery",
+	"k8s.io/client-go",
+	"sigs.k8s.io/controller-runtime",
+	"sigs.k8s.io/yaml",
+}
+
+// readPopularModules reads a file of module paths, one per line, with "#"
+// comments.
+func readPopularModules(file string) ([]string, error) {
+	content, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var paths []string
+	s := bufio.NewScanner(bytes.NewReader(content))
+	for line := 1; s.Scan(); line++ {
+		text, _, _ := strings.Cut(s.Text(), "#")
+		text = strings.TrimSpace(text)
+		if text == "" {
+			continue
+		}
+		if err := module.CheckPath(text); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", file, line, err)
+		}
+		paths = append(paths, text)
+	}
+	return paths, s.Err()
+}
+
+// suspiciousPaths lists the modules changes add, as requirements or
+// replacements, whose paths are near misses of popular ones: differing only in
+// case, or by a typo or two (an edit distance of 1, or 2 for long paths).
+// Siblings under the same parent path, like golang.org/x/net and
+// golang.org/x/text, are owned by the same party and never suspicious.
+func suspiciousPaths(changes []change, popular []string) []string {
+	exact := map[string]bool{}
+	for _, p := range popular {
+		exact[p] = true
+	}
+	var warnings []string
+	for _, c := range changes {
+		var p string
+		switch {
+		case c.Kind == "require" && c.Action == actionAdd:
+			p = c.Path
+		case c.Kind == "replace" && c.Action != actionRemove:
+			// A replacement is path@version, or a directory.
+			p, _, _ = strings.Cut(c.To, "@")
+			if modfile.IsDirectoryPath(p) {
+				continue
+			}
+		default:
+			continue
+		}
+		if exact[p] {
+			continue
+		}
+		for _, q := range popular {
+			if why := nearMiss(p, q); why != "" {
+				warnings = append(warnings, fmt.Sprintf("%s: %s %s", p, why, q))
+				break
+			}
+		}
+	}
+	return warnings
+}
+
+// checkTyposquats warns about added modules with suspicious paths, and fails
+// if there are any and fail is set.
+func checkTyposquats(changes []change, popular []string, fail bool) error {
+	warnings := suspiciousPaths(changes, popular)
+	if fail {
+		return gateError("merge would add %d module(s) with suspicious paths:", warnings)
+	}
+	for _, w := range warnings {
+		fmt.Fprintf(os.Stderr, "(typosquat) %s\n", w)
+	}
+	return nil
+}
+
+// nearMiss describes how p imitates the popular path q, or returns "".
+func nearMiss(p, q string) string {
+	if strings.EqualFold(p, q) {
+		return "differs only in case from"
+	}
+	if path.Dir(strings.ToLower(p)) == path.Dir(strings.ToLower(q)) {
+		return ""
+	}
+	limit := 1
+	if len(q) >= 20 {
+		limit = 2
+	}
+	if d := editDistance(strings.ToLower(p), strings.ToLower(q), limit); d <= limit {
+		return fmt.Sprintf("is %d edit%s away from", d, plural(d))
+	}
+	return ""
+}
+
+// editDistance returns the optimal string alignment distance between a and
+// b: the number of insertions, deletions, substitutions and transpositions of
+// adjacent bytes turning one into the other. Distances above limit are
+// reported as limit+1.
+func editDistance(a, b string, limit int) int {
+	if d := len(a) - len(b); d > limit || -d > limit {
+		return limit + 1
+	}
+	prev2 := make([]int, len(b)+1)
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		best := cur[0]
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+			if i > 1 && j > 1 && a[i-1] == b[j-2] && a[i-2] == b[j-1] {
+				cur[j] = min(cur[j], prev2[j-2]+1)
+			}
+			best = min(best, cur[j])
+		}
+		if best > limit {
+			return limit + 1
+		}
+		prev2, prev, cur = prev, cur, prev2
+	}
+	return min(prev[len(b)], limit+1)
+}
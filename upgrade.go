@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"strings"
+
+	"golang.org/x/mod/modfile"
+	"golang.org/x/mod/module"
+	"golang.org/x/mod/semver"
+
+	"github.com/brettbuddin/modtransplant/transplant"
+)
+
+// upgradeToLatest raises the version of every module src requires to its
+// latest release, so the transplant also catches up with upstream. With
+// sameMajor, only releases of the same major version as the source's are
+// considered, which keeps v0 modules from moving to v1 and v2+ modules without
+// a go.mod file from moving to another +incompatible major. Versions are never
+// lowered, and modules src replaces are left alone since the replacement
+// decides what is actually built.
+func upgradeToLatest(ctx context.Context, src *modfile.File, opts *netOptions, sameMajor bool, t trace) error {
+	p, err := newModuleFetcher(opts)
+	if err != nil {
+		return err
+	}
+
+	latest := make([]string, len(src.Require))
+	errs := make([]error, len(src.Require))
+	var mods []int
+	for i, r := range src.Require {
+		if replacedModule(src, r.Mod.Path, r.Mod.Version) != (module.Version{}) {
+			t.logf(r.Mod.Path, "(latest) skip replaced: %s", r.Mod)
+			continue
+		}
+		mods = append(mods, i)
+	}
+	progress := startProgress(opts, "latest", len(mods))
+	runJobs(ctx, opts.jobs, len(mods), func(j int) {
+		i := mods[j]
+		latest[i], errs[i] = latestRelease(ctx, p, src.Require[i].Mod, sameMajor)
+		progress.add(1)
+	})
+	progress.finish()
+
+	for i, r := range src.Require {
+		if errs[i] != nil {
+			return errs[i]
+		}
+		if latest[i] == "" || semver.Compare(latest[i], r.Mod.Version) <= 0 {
+			continue
+		}
+		t.logf(r.Mod.Path, "(latest) upgrade: %s %s -> %s", r.Mod.Path, r.Mod.Version, latest[i])
+		r.Mod.Version = latest[i]
+	}
+	src.Cleanup()
+	src.SetRequire(src.Require)
+	return nil
+}
+
+// latestRelease returns the highest release of mod's path, or of mod's major
+// version when sameMajor is set. It returns "" if the module has no tagged
+// versions.
+func latestRelease(ctx context.Context, p transplant.ModuleFetcher, mod module.Version, sameMajor bool) (string, error) {
+	return highestRelease(ctx, p, mod.Path, func(v string) bool {
+		return !sameMajor || (semver.Major(v) == semver.Major(mod.Version) && isIncompatible(v) == isIncompatible(mod.Version))
+	})
+}
+
+// highestRelease returns the highest tagged version of path accepted by match.
+// Pre-releases are only considered if there is no matching release at all,
+// following the go command's notion of "latest". It returns "" if no version
+// matches.
+func highestRelease(ctx context.Context, p transplant.ModuleFetcher, path string, match func(string) bool) (string, error) {
+	versions, err := p.List(ctx, path)
+	if err != nil {
+		return "", err
+	}
+	var release, prerelease string
+	for _, v := range versions {
+		if !semver.IsValid(v) || module.IsPseudoVersion(v) || !match(v) {
+			continue
+		}
+		if semver.Prerelease(v) == "" {
+			if release == "" || semver.Compare(v, release) > 0 {
+				release = v
+			}
+		} else if prerelease == "" || semver.Compare(v, prerelease) > 0 {
+			prerelease = v
+		}
+	}
+	if release != "" {
+		return release, nil
+	}
+	return prerelease, nil
+}
+
+func isIncompatible(version string) bool {
+	return strings.HasSuffix(version, "+incompatible")
+}
+
+// upgradePatches resolves the most common kind of conflict without human
+// input: when src and dest require versions of a module that differ only at
+// the patch level, the source's requirement is raised to the highest patch
+// release of that minor series, which the merge then selects.
+func upgradePatches(ctx context.Context, dest, src *modfile.File, opts *netOptions, t trace) error {
+	destVersions := map[string]string{}
+	for _, r := range dest.Require {
+		destVersions[r.Mod.Path] = r.Mod.Version
+	}
+
+	p, err := newModuleFetcher(opts)
+	if err != nil {
+		return err
+	}
+	highest := make([]string, len(src.Require))
+	errs := make([]error, len(src.Require))
+	var mods []int
+	for i, r := range src.Require {
+		destVersion, ok := destVersions[r.Mod.Path]
+		if ok && destVersion != r.Mod.Version && differOnlyInPatch(destVersion, r.Mod.Version) {
+			mods = append(mods, i)
+		}
+	}
+	progress := startProgress(opts, "auto-patch", len(mods))
+	runJobs(ctx, opts.jobs, len(mods), func(j int) {
+		i := mods[j]
+		version := src.Require[i].Mod.Version
+		highest[i], errs[i] = highestRelease(ctx, p, src.Require[i].Mod.Path, func(v string) bool {
+			return differOnlyInPatch(v, version)
+		})
+		progress.add(1)
+	})
+	progress.finish()
+
+	for i, r := range src.Require {
+		if errs[i] != nil {
+			return errs[i]
+		}
+		if highest[i] == "" || semver.Compare(highest[i], r.Mod.Version) <= 0 {
+			continue
+		}
+		t.logf(r.Mod.Path, "(patch) upgrade: %s %s -> %s", r.Mod.Path, r.Mod.Version, highest[i])
+		r.Mod.Version = highest[i]
+	}
+	src.Cleanup()
+	src.SetRequire(src.Require)
+	return nil
+}
+
+// differOnlyInPatch reports whether a and b are releases of the same minor
+// series.
+func differOnlyInPatch(a, b string) bool {
+	for _, v := range []string{a, b} {
+		if !semver.IsValid(v) || semver.Prerelease(v) != "" || module.IsPseudoVersion(v) {
+			return false
+		}
+	}
+	return semver.MajorMinor(a) == semver.MajorMinor(b) && isIncompatible(a) == isIncompatible(b)
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	defaultGitHubAPIURL = "https://api.github.com"
+	// githubTokenEnv names the environment variable with a token for the
+	// api.github.com repository API. Other hosts, like GitHub Enterprise
+	// servers, get the credentials configured for them like every request.
+	// Without a token, the API's rate limit is low.
+	githubTokenEnv = "GITHUB_TOKEN"
+	// defaultInactiveAfter is how long a repository may go without a push
+	// before it is considered abandoned.
+	defaultInactiveAfter = 2 * 365 * 24 * time.Hour
+)
+
+// upstreamStatus is the state of the GitHub repository of a module a merge
+// adds. Err is why it couldn't be looked up.
+type upstreamStatus struct {
+	Path       string `json:"path"`
+	Repository string `json:"repository"`
+	Archived   bool   `json:"archived"`
+	LastPush   string `json:"last_push,omitempty"`
+	// Inactive is set if the repository has had no push for longer than
+	// -inactive-after.
+	Inactive bool   `json:"inactive"`
+	Err      string `json:"error,omitempty"`
+}
+
+// abandoned reports whether the repository is archived or inactive.
+func (s upstreamStatus) abandoned() bool {
+	return s.Archived || s.Inactive
+}
+
+func (s upstreamStatus) String() string {
+	switch {
+	case s.Err != "":
+		return s.Err
+	case s.Archived:
+		return fmt.Sprintf("%s is archived (last push %s)", s.Repository, s.LastPush)
+	case s.Inactive:
+		return fmt.Sprintf("%s is inactive (last push %s)", s.Repository, s.LastPush)
+	}
+	return fmt.Sprintf("%s is active (last push %s)", s.Repository, s.LastPush)
+}
+
+// githubRepository returns the owner/name of the GitHub repository of a
+// module path, or "" if it isn't hosted on GitHub.
+func githubRepository(path string) string {
+	elems := strings.Split(path, "/")
+	if len(elems) < 3 || elems[0] != "github.com" {
+		return ""
+	}
+	return elems[1] + "/" + elems[2]
+}
+
+// upstreamStatuses looks up the GitHub repositories of the modules changes
+// add. Repositories inactive for longer than inactiveAfter are flagged. A
+// repository that can't be looked up gets its error recorded rather than
+// failing the merge.
+func upstreamStatuses(ctx context.Context, changes []change, opts *netOptions, apiURL string, inactiveAfter time.Duration) ([]upstreamStatus, error) {
+	defer opts.metrics.time(phaseNetwork)()
+	client := newHTTPClient(opts)
+	var statuses []upstreamStatus
+	for _, c := range changes {
+		if c.Kind != "require" || c.Action != actionAdd {
+			continue
+		}
+		repo := githubRepository(c.Path)
+		if repo == "" {
+			continue
+		}
+		s, err := githubRepositoryStatus(ctx, client, strings.TrimSuffix(apiURL, "/"), repo)
+		if errors.Is(err, context.Canceled) {
+			return nil, err
+		}
+		if err != nil {
+			s.Err = err.Error()
+		}
+		s.Path = c.Path
+		if t, err := time.Parse(time.RFC3339, s.LastPush); err == nil {
+			s.Inactive = time.Since(t) > inactiveAfter
+			s.LastPush = t.UTC().Format("2006-01-02")
+		}
+		fmt.Fprintf(os.Stderr, "(upstream) %s: %s\n", c.Path, s)
+		statuses = append(statuses, s)
+	}
+	return statuses, nil
+}
+
+// githubRepositoryStatus fetches the state of the repository owner/name from
+// the GitHub API at apiURL.
+func githubRepositoryStatus(ctx context.Context, client *http.Client, apiURL, repo string) (upstreamStatus, error) {
+	s := upstreamStatus{Repository: "github.com/" + repo}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, apiURL+"/repos/"+repo, nil)
+	if err != nil {
+		return s, err
+	}
+	req.Header.Set("Accept", "application/vnd.github+json")
+	if token := os.Getenv(githubTokenEnv); token != "" && req.URL.Host == "api.github.com" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return s, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return s, fmt.Errorf("%s: repository not found", s.Repository)
+	default:
+		return s, fmt.Errorf("%s: %s", s.Repository, resp.Status)
+	}
+	var r struct {
+		Archived bool   `json:"archived"`
+		PushedAt string `json:"pushed_at"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&r); err != nil {
+		return s, fmt.Errorf("%s: %w", s.Repository, err)
+	}
+	s.Archived, s.LastPush = r.Archived, r.PushedAt
+	return s, nil
+}
+
+// checkAbandoned fails if any of the statuses is of an archived or inactive
+// repository.
+func checkAbandoned(statuses []upstreamStatus) error {
+	var abandoned []string
+	for _, s := range statuses {
+		if s.abandoned() {
+			abandoned = append(abandoned, fmt.Sprintf("%s: %s", s.Path, s))
+		}
+	}
+	return gateError("merge would add %d module(s) whose upstream is abandoned:", abandoned)
+}
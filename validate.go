@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/mod/modfile"
+)
+
+// Checks of -validate, run as go commands in a copy of the destination module.
+var validateChecks = map[string][]string{
+	"build": {"build", "./..."},
+	"vet":   {"vet", "./..."},
+	// The packages and flags of test are given by -test-args.
+	"test": {"test"},
+}
+
+// defaultTestArgs are the -test-args used unless others are given.
+const defaultTestArgs = "./..."
+
+// parseValidate parses the comma-separated checks of -validate.
+func parseValidate(s string) ([]string, error) {
+	if s == "" {
+		return nil, nil
+	}
+	var checks []string
+	for _, c := range strings.Split(s, ",") {
+		c = strings.TrimSpace(c)
+		if _, ok := validateChecks[c]; !ok {
+			return nil, fmt.Errorf("unsupported -validate check %q (build, vet or test)", c)
+		}
+		checks = append(checks, c)
+	}
+	return checks, nil
+}
+
+// validateModule copies the module of destFile to a temporary directory,
+// replaces its go.mod file with out and the other files with their staged
+// content and runs the checks there, so a merge breaking the build or the
+// tests is caught before the real files are touched. testArgs are passed to
+// go test.
+func validateModule(ctx context.Context, destFile string, out []byte, files []stagedFile, checks, testArgs []string) error {
+	dir := filepath.Dir(destFile)
+	tmp, err := ioutil.TempDir("", "modtransplant-validate-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+	if err := copyTree(dir, tmp); err != nil {
+		return fmt.Errorf("copy module for validation: %w", err)
+	}
+	out, err = absLocalReplaces(out, dir)
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filepath.Join(tmp, "go.mod"), out, 0o644); err != nil {
+		return err
+	}
+	for _, f := range files {
+		rel, err := filepath.Rel(dir, f.path)
+		if err != nil {
+			return err
+		}
+		if err := ioutil.WriteFile(filepath.Join(tmp, rel), f.content, 0o644); err != nil {
+			return err
+		}
+	}
+
+	for _, c := range checks {
+		args := validateChecks[c]
+		if c == "test" {
+			args = append(args[:1:1], testArgs...)
+		}
+		fmt.Fprintf(os.Stderr, "(validate) go %s\n", strings.Join(args, " "))
+		cmd := exec.CommandContext(ctx, "go", args...)
+		cmd.Dir = tmp
+		// go.sum and vendor/ may not be up to date with the merged go.mod
+		// file yet, and the copy isn't part of any workspace.
+		cmd.Env = append(os.Environ(), "GOFLAGS=-mod=mod", "GOWORK=off")
+		var output bytes.Buffer
+		cmd.Stdout = io.MultiWriter(os.Stderr, &output)
+		cmd.Stderr = cmd.Stdout
+		if err := cmd.Run(); err != nil {
+			err = fmt.Errorf("validation failed: go %s: %w", strings.Join(args, " "), err)
+			if failures := testFailures(output.String()); len(failures) > 0 {
+				err = fmt.Errorf("%w\n\t%s", err, strings.Join(failures, "\n\t"))
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// testFailures picks the failed tests and packages from go test output, for
+// reports to show what broke.
+func testFailures(output string) []string {
+	var failures []string
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "--- FAIL: ") || strings.HasPrefix(line, "FAIL\t") {
+			failures = append(failures, line)
+		}
+	}
+	return failures
+}
+
+// absLocalReplaces makes the relative directory replacements of the go.mod
+// file content relative to dir absolute, so they still resolve from a copy of
+// the module.
+func absLocalReplaces(content []byte, dir string) ([]byte, error) {
+	f, err := modfile.Parse("go.mod", content, nil)
+	if err != nil {
+		return nil, err
+	}
+	var changed bool
+	for _, r := range f.Replace {
+		if r.New.Version != "" || filepath.IsAbs(r.New.Path) {
+			continue
+		}
+		abs, err := filepath.Abs(filepath.Join(dir, r.New.Path))
+		if err != nil {
+			return nil, err
+		}
+		if err := f.AddReplace(r.Old.Path, r.Old.Version, abs, ""); err != nil {
+			return nil, err
+		}
+		changed = true
+	}
+	if !changed {
+		return content, nil
+	}
+	return f.Format()
+}
+
+// copyTree copies the files below src to dst, except for VCS metadata.
+// Symbolic links are copied as links.
+func copyTree(src, dst string) error {
+	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		switch {
+		case info.IsDir():
+			if info.Name() == ".git" && path != src {
+				return filepath.SkipDir
+			}
+			return os.MkdirAll(target, 0o755)
+		case info.Mode()&os.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, target)
+		case info.Mode().IsRegular():
+			return copyFile(path, target, info.Mode().Perm())
+		}
+		return nil
+	})
+}
+
+func copyFile(src, dst string, perm os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
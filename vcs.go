@@ -0,0 +1,340 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/mod/modfile"
+	"golang.org/x/mod/module"
+	"golang.org/x/mod/semver"
+)
+
+// vcsRepo is the git repository a module lives in.
+type vcsRepo struct {
+	// root is the module path prefix corresponding to the repository root.
+	root string
+	url  string
+}
+
+// knownHosts maps hosting services to the number of path elements forming a
+// repository root.
+var knownHosts = map[string]int{
+	"github.com":    3,
+	"gitlab.com":    3,
+	"bitbucket.org": 3,
+}
+
+// directFetch answers a proxy request (rel is "@latest", "@v/list",
+// "@v/<version>.info" or "@v/<version>.mod") for path by talking to its git
+// repository directly, like the go command does for the "direct" GOPROXY
+// entry. git is used for all repository access, so ssh URLs, ssh-agent,
+// credential helpers and url.<base>.insteadOf settings all work as usual.
+func directFetch(ctx context.Context, client *http.Client, modPath, rel string) ([]byte, error) {
+	repo, err := resolveRepo(ctx, client, modPath)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case rel == "@latest":
+		versions, err := repoVersions(ctx, repo, modPath)
+		if err != nil {
+			return nil, err
+		}
+		if len(versions) == 0 {
+			return nil, fmt.Errorf("no tagged versions: %w", errNotFound)
+		}
+		// Prefer the highest release over the highest pre-release.
+		latest := versions[len(versions)-1]
+		for i := len(versions) - 1; i >= 0; i-- {
+			if semver.Prerelease(versions[i]) == "" {
+				latest = versions[i]
+				break
+			}
+		}
+		return json.Marshal(struct{ Version string }{latest})
+	case rel == "@v/list":
+		versions, err := repoVersions(ctx, repo, modPath)
+		if err != nil {
+			return nil, err
+		}
+		return []byte(strings.Join(versions, "\n")), nil
+	case strings.HasPrefix(rel, "@v/") && strings.HasSuffix(rel, ".info"):
+		version, err := module.UnescapeVersion(strings.TrimSuffix(strings.TrimPrefix(rel, "@v/"), ".info"))
+		if err != nil {
+			return nil, err
+		}
+		t, err := repoVersionTime(ctx, repo, modPath, version)
+		if err != nil {
+			return nil, err
+		}
+		return json.Marshal(struct {
+			Version string
+			Time    time.Time
+		}{version, t})
+	case strings.HasPrefix(rel, "@v/") && strings.HasSuffix(rel, ".mod"):
+		version, err := module.UnescapeVersion(strings.TrimSuffix(strings.TrimPrefix(rel, "@v/"), ".mod"))
+		if err != nil {
+			return nil, err
+		}
+		return repoGoMod(ctx, repo, modPath, version)
+	}
+	return nil, fmt.Errorf("%s: %w", rel, errNotFound)
+}
+
+// resolveRepo finds the repository of modPath: from the well-known hosting
+// services, a path element ending in ".git", or the go-import meta tag served
+// at https://<path>?go-get=1.
+func resolveRepo(ctx context.Context, client *http.Client, modPath string) (vcsRepo, error) {
+	elems := strings.Split(modPath, "/")
+	if n, ok := knownHosts[elems[0]]; ok && len(elems) >= n {
+		root := strings.Join(elems[:n], "/")
+		return vcsRepo{root: root, url: "https://" + root}, nil
+	}
+	for i, e := range elems {
+		if i > 0 && strings.HasSuffix(e, ".git") {
+			root := strings.Join(elems[:i+1], "/")
+			return vcsRepo{root: root, url: "https://" + root}, nil
+		}
+	}
+
+	resp, err := httpGet(ctx, client, "https://"+modPath+"?go-get=1")
+	if err != nil {
+		return vcsRepo{}, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return vcsRepo{}, err
+	}
+	for _, imp := range parseGoImports(body) {
+		if imp.prefix != modPath && !strings.HasPrefix(modPath, imp.prefix+"/") {
+			continue
+		}
+		if imp.vcs != "git" {
+			return vcsRepo{}, fmt.Errorf("%s: unsupported version control system %q", modPath, imp.vcs)
+		}
+		if err := checkRepoURL(imp.repo); err != nil {
+			return vcsRepo{}, fmt.Errorf("%s: go-import meta tag: %w", modPath, err)
+		}
+		return vcsRepo{root: imp.prefix, url: imp.repo}, nil
+	}
+	return vcsRepo{}, fmt.Errorf("%s: no go-import meta tag found: %w", modPath, errNotFound)
+}
+
+// repoSchemes are the schemes a repository URL served by a go-import meta tag
+// may use. Like the go command, local and command-running transports such as
+// file:// and ext:: are refused, since the page is controlled by whoever serves
+// the module path.
+var repoSchemes = map[string]bool{
+	"https":   true,
+	"ssh":     true,
+	"git+ssh": true,
+}
+
+// checkRepoURL checks a repository URL taken from a go-import meta tag before
+// it is handed to git.
+func checkRepoURL(rawurl string) error {
+	if strings.HasPrefix(rawurl, "-") {
+		return fmt.Errorf("invalid repository URL %q", rawurl)
+	}
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return fmt.Errorf("invalid repository URL %q: %w", rawurl, err)
+	}
+	if !repoSchemes[u.Scheme] || u.Host == "" {
+		return fmt.Errorf("repository URL %q: scheme must be https, ssh or git+ssh", rawurl)
+	}
+	return nil
+}
+
+type goImport struct {
+	prefix, vcs, repo string
+}
+
+var goImportRe = regexp.MustCompile(`(?is)<meta\s+[^>]*name=["']go-import["'][^>]*>`)
+var metaContentRe = regexp.MustCompile(`(?is)content=["']([^"']*)["']`)
+
+func parseGoImports(body []byte) []goImport {
+	var imports []goImport
+	for _, tag := range goImportRe.FindAll(body, -1) {
+		m := metaContentRe.FindSubmatch(tag)
+		if m == nil {
+			continue
+		}
+		if f := strings.Fields(string(m[1])); len(f) == 3 {
+			imports = append(imports, goImport{prefix: f[0], vcs: f[1], repo: f[2]})
+		}
+	}
+	return imports
+}
+
+// moduleDir returns the directory of modPath within repo, and the directory
+// without any major version suffix. The latter determines the prefix of the
+// module's version tags.
+func moduleDir(repo vcsRepo, modPath string) (dir, baseDir string) {
+	dir = strings.TrimPrefix(strings.TrimPrefix(modPath, repo.root), "/")
+	prefix, _, _ := module.SplitPathVersion(modPath)
+	baseDir = strings.TrimPrefix(strings.TrimPrefix(prefix, repo.root), "/")
+	return dir, baseDir
+}
+
+func tagPrefix(baseDir string) string {
+	if baseDir == "" {
+		return ""
+	}
+	return baseDir + "/"
+}
+
+// repoVersions lists the release versions of modPath tagged in repo, in
+// ascending order.
+func repoVersions(ctx context.Context, repo vcsRepo, modPath string) ([]string, error) {
+	out, err := git(ctx, "", "ls-remote", "--tags", "--", repo.url)
+	if err != nil {
+		return nil, err
+	}
+	_, baseDir := moduleDir(repo, modPath)
+	_, pathMajor, _ := module.SplitPathVersion(modPath)
+
+	var versions []string
+	for _, line := range strings.Split(string(out), "\n") {
+		f := strings.Fields(line)
+		if len(f) != 2 || strings.HasSuffix(f[1], "^{}") {
+			continue
+		}
+		v := strings.TrimPrefix(f[1], "refs/tags/"+tagPrefix(baseDir))
+		if v == f[1] || !semver.IsValid(v) || v != semver.Canonical(v) {
+			continue
+		}
+		if module.CheckPathMajor(v, pathMajor) != nil {
+			if pathMajor != "" || semver.Major(v) == "v0" || semver.Major(v) == "v1" {
+				continue
+			}
+			v += "+incompatible"
+		}
+		versions = append(versions, v)
+	}
+	sort.Slice(versions, func(i, j int) bool { return semver.Compare(versions[i], versions[j]) < 0 })
+	return versions, nil
+}
+
+// revision returns the git revision a module version refers to: the commit
+// hash of a pseudo-version or the tag of a release.
+func revision(baseDir, version string) string {
+	if module.IsPseudoVersion(version) {
+		rev, _ := module.PseudoVersionRev(version)
+		return rev
+	}
+	return "refs/tags/" + tagPrefix(baseDir) + strings.TrimSuffix(version, "+incompatible")
+}
+
+// fetchRevision fetches the revision of version into a temporary bare
+// repository and returns the repository and the resolved commit. Tags are
+// fetched shallowly; pseudo-versions only name an abbreviated commit, which
+// requires fetching the history (without file contents) to resolve.
+func fetchRevision(ctx context.Context, repo vcsRepo, baseDir, version string) (gitDir, commit string, err error) {
+	gitDir, err = ioutil.TempDir("", "modtransplant-git")
+	if err != nil {
+		return "", "", err
+	}
+	rev := revision(baseDir, version)
+	if _, err = git(ctx, gitDir, "init", "--bare", "-q"); err == nil {
+		// A named remote lets git lazily fetch the blobs a partial clone
+		// omits.
+		_, err = git(ctx, gitDir, "remote", "add", "--", "origin", repo.url)
+	}
+	if err == nil {
+		if module.IsPseudoVersion(version) {
+			_, err = git(ctx, gitDir, "fetch", "-q", "--filter=blob:none", "origin", "+refs/heads/*:refs/heads/*", "+refs/tags/*:refs/tags/*")
+		} else {
+			_, err = git(ctx, gitDir, "fetch", "-q", "--depth=1", "origin", rev+":"+rev)
+		}
+	}
+	if err != nil {
+		os.RemoveAll(gitDir)
+		return "", "", err
+	}
+	out, err := git(ctx, gitDir, "rev-parse", "--verify", rev+"^{commit}")
+	if err != nil {
+		os.RemoveAll(gitDir)
+		return "", "", fmt.Errorf("unknown revision %s: %w", rev, errNotFound)
+	}
+	return gitDir, strings.TrimSpace(string(out)), nil
+}
+
+// repoGoMod returns the go.mod file of modPath@version. For major versions
+// beyond v1 the major subdirectory is tried first. Modules without a go.mod
+// file get a synthesized one, as the go command does.
+func repoGoMod(ctx context.Context, repo vcsRepo, modPath, version string) ([]byte, error) {
+	dir, baseDir := moduleDir(repo, modPath)
+	gitDir, commit, err := fetchRevision(ctx, repo, baseDir, version)
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(gitDir)
+
+	for _, d := range []string{dir, baseDir} {
+		data, err := git(ctx, gitDir, "show", commit+":"+path.Join(d, "go.mod"))
+		if err != nil {
+			continue
+		}
+		if modfile.ModulePath(data) == modPath {
+			return data, nil
+		}
+	}
+	if strings.HasSuffix(version, "+incompatible") || semver.Major(version) == "v0" || semver.Major(version) == "v1" {
+		return []byte("module " + modfile.AutoQuote(modPath) + "\n"), nil
+	}
+	return nil, fmt.Errorf("%s@%s: go.mod not found: %w", modPath, version, errNotFound)
+}
+
+// repoVersionTime returns the commit time of modPath@version.
+func repoVersionTime(ctx context.Context, repo vcsRepo, modPath, version string) (time.Time, error) {
+	_, baseDir := moduleDir(repo, modPath)
+	gitDir, commit, err := fetchRevision(ctx, repo, baseDir, version)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer os.RemoveAll(gitDir)
+	out, err := git(ctx, gitDir, "log", "-1", "--format=%cI", commit)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Parse(time.RFC3339, strings.TrimSpace(string(out)))
+}
+
+// git runs a git command in dir, disabling interactive prompts like the go
+// command does, and restricting the transports to those git considers safe
+// for URLs it didn't get from the user. The command is killed when ctx is
+// done.
+func git(ctx context.Context, dir string, args ...string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0", "GIT_PROTOCOL_FROM_USER=0")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("git %s: %w", args[0], ctx.Err())
+		}
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return nil, fmt.Errorf("git %s: %s", args[0], strings.TrimSpace(stderr.String()))
+		}
+		return nil, err
+	}
+	return out, nil
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// vendorChurn is how much "go mod vendor" changed the vendor directory of a
+// destination, with -vendor.
+type vendorChurn struct {
+	Files   churnCounts `json:"files"`
+	Modules churnCounts `json:"modules"`
+}
+
+// churnCounts count the entries added, removed and changed.
+type churnCounts struct {
+	Added   int `json:"added"`
+	Removed int `json:"removed"`
+	Changed int `json:"changed"`
+}
+
+func (c churnCounts) String() string {
+	return fmt.Sprintf("%d added, %d removed, %d changed", c.Added, c.Removed, c.Changed)
+}
+
+// vendorModule runs "go mod vendor" in the module rooted at dir and reports how
+// much the vendor directory changed.
+func vendorModule(ctx context.Context, dir string) (*vendorChurn, error) {
+	vendorDir := filepath.Join(dir, "vendor")
+	before, err := hashTree(vendorDir)
+	if err != nil {
+		return nil, err
+	}
+	beforeMods := vendoredModules(filepath.Join(vendorDir, "modules.txt"))
+
+	cmd := exec.CommandContext(ctx, "go", "mod", "vendor")
+	cmd.Dir = dir
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("go mod vendor: %w", err)
+	}
+
+	after, err := hashTree(vendorDir)
+	if err != nil {
+		return nil, err
+	}
+	afterMods := vendoredModules(filepath.Join(vendorDir, "modules.txt"))
+
+	c := &vendorChurn{Files: churn(before, after), Modules: churn(beforeMods, afterMods)}
+	fmt.Fprintf(os.Stderr, "(vendor) files: %s\n", c.Files)
+	fmt.Fprintf(os.Stderr, "(vendor) modules: %s\n", c.Modules)
+	return c, nil
+}
+
+// hashTree returns the SHA-256 of every file below root, keyed by relative
+// path. A missing root yields an empty map.
+func hashTree(root string) (map[string]string, error) {
+	hashes := map[string]string{}
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path == root {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		content, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		hashes[rel] = fmt.Sprintf("%x", sha256.Sum256(content))
+		return nil
+	})
+	return hashes, err
+}
+
+// vendoredModules maps each module path listed in a vendor/modules.txt file to
+// its version (including any replacement).
+func vendoredModules(path string) map[string]string {
+	mods := map[string]string{}
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return mods
+	}
+	for _, line := range bytes.Split(content, []byte("\n")) {
+		if !bytes.HasPrefix(line, []byte("# ")) {
+			continue
+		}
+		fields := strings.Fields(string(line[2:]))
+		if len(fields) > 0 {
+			mods[fields[0]] = strings.Join(fields[1:], " ")
+		}
+	}
+	return mods
+}
+
+// churn counts the keys added to, removed from and changed between two maps.
+func churn(before, after map[string]string) churnCounts {
+	var c churnCounts
+	for k, v := range after {
+		old, ok := before[k]
+		switch {
+		case !ok:
+			c.Added++
+		case old != v:
+			c.Changed++
+		}
+	}
+	for k := range before {
+		if _, ok := after[k]; !ok {
+			c.Removed++
+		}
+	}
+	return c
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	"golang.org/x/mod/modfile"
+	"golang.org/x/mod/module"
+	"golang.org/x/mod/semver"
+)
+
+// runVerify checks that the destination has absorbed the source: that it
+// requires every module the source requires, at the same or a higher version.
+func runVerify(ctx context.Context, args []string) error {
+	var (
+		destFile string
+		srcFile  string
+		netOpts  netOptions
+	)
+	fs := flag.NewFlagSet("modtransplant verify", flag.ExitOnError)
+	fs.StringVar(&destFile, "dest", "", "destination go.mod file")
+	fs.StringVar(&srcFile, "src", "", "source module (go.mod file, directory of modules, Go binary, vendor/modules.txt, requirements manifest, archive or oci:// image)")
+	netOpts.register(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if destFile == "" || srcFile == "" {
+		return errors.New(usage)
+	}
+
+	dest, err := parseModFile(destFile)
+	if err != nil {
+		return err
+	}
+	src, err := loadSource(ctx, srcFile, &netOpts)
+	if err != nil {
+		return err
+	}
+	violations := verifyAbsorbed(dest, src)
+	if err := gateError("destination doesn't satisfy %d requirement(s) of the source:", violations); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "(verify) %s satisfies all %d requirement(s) of %s\n", destFile, len(src.Require), srcFile)
+	return nil
+}
+
+// verifyAbsorbed lists the requirements of src that dest doesn't satisfy.
+// Both sides are compared as their replacements make them: the module each
+// replaces a requirement with must be the same, at a version of dest no lower
+// than src's. A requirement dest replaces with a directory is satisfied, as
+// the module is then part of dest's own tree.
+func verifyAbsorbed(dest, src *modfile.File) []string {
+	destReqs := map[string]string{}
+	for _, r := range dest.Require {
+		destReqs[r.Mod.Path] = r.Mod.Version
+	}
+	var violations []string
+	for _, r := range src.Require {
+		if dest.Module != nil && r.Mod.Path == dest.Module.Mod.Path {
+			continue
+		}
+		destVersion, ok := destReqs[r.Mod.Path]
+		if !ok {
+			violations = append(violations, fmt.Sprintf("%s: not required (src %s)", r.Mod.Path, r.Mod.Version))
+			continue
+		}
+		want := effectiveModule(src, r.Mod.Path, r.Mod.Version)
+		got := effectiveModule(dest, r.Mod.Path, destVersion)
+		switch {
+		case got.Version == "":
+			// Replaced with a directory of the destination.
+		case got.Path != want.Path || want.Version == "":
+			violations = append(violations, fmt.Sprintf("%s: dest uses %s, src %s", r.Mod.Path, got, want))
+		case semver.Compare(got.Version, want.Version) < 0:
+			violations = append(violations, fmt.Sprintf("%s: dest uses %s, lower than src %s", r.Mod.Path, got, want))
+		}
+	}
+	return violations
+}
+
+// effectiveModule returns the module path@version resolves to in f: its
+// replacement, if f replaces it, or the module itself.
+func effectiveModule(f *modfile.File, path, version string) module.Version {
+	if r := replacedModule(f, path, version); r.Path != "" {
+		return r
+	}
+	return module.Version{Path: path, Version: version}
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/brettbuddin/modtransplant/transplant"
+)
+
+// trace collects, per module path, the log lines of the steps that shaped
+// the module's entries in a merge, so every change can be explained.
+type trace map[string][]string
+
+// logf logs the line of an action taken on a module and records it for path.
+func (t trace) logf(path, format string, args ...interface{}) {
+	line := fmt.Sprintf(format, args...)
+	fmt.Fprintln(actionLog(), line)
+	t.note(path, line)
+}
+
+// warnf logs a warning about a module, which -summary keeps on the console,
+// and records it for path.
+func (t trace) warnf(path, format string, args ...interface{}) {
+	line := fmt.Sprintf(format, args...)
+	fmt.Fprintln(os.Stderr, line)
+	t.note(path, line)
+}
+
+// note records a line for path without logging it.
+func (t trace) note(path, line string) {
+	if t != nil {
+		t[path] = append(t[path], line)
+	}
+}
+
+// clone returns a copy of t that can be added to independently.
+func (t trace) clone() trace {
+	c := trace{}
+	for path, lines := range t {
+		c[path] = append([]string(nil), lines...)
+	}
+	return c
+}
+
+// noteEntries records the entries of a merge report.
+func (t trace) noteEntries(r *transplant.Report) {
+	if r == nil {
+		return
+	}
+	for _, e := range r.Entries {
+		var path string
+		switch e := e.(type) {
+		case transplant.RequireAdded:
+			path = e.Module.Path
+		case transplant.RequireUpdated:
+			path = e.Path
+		case transplant.RequireKept:
+			path = e.Module.Path
+		case transplant.RequireMadeDirect:
+			path = e.Module.Path
+		case transplant.RequireDropped:
+			path = e.Path
+		case transplant.ReplaceAdded:
+			path = e.Old.Path
+		case transplant.ReplaceUpdated:
+			path = e.Old.Path
+		case transplant.ReplaceKept:
+			path = e.Old.Path
+		case transplant.ReplaceDropped:
+			path = e.Old.Path
+		case transplant.ExcludeAdded:
+			path = e.Module.Path
+		case transplant.ExcludeSkipped:
+			path = e.Module.Path
+		case transplant.IgnoreAdded:
+			path = e.Path
+		case transplant.ConflictResolved:
+			path = e.Conflict.Path
+		case transplant.ConflictUnresolved:
+			path = e.Conflict.Path
+		}
+		t.note(path, e.String())
+	}
+}
+
+// runWhy explains the changes a merge made to a module's entries in the
+// destination: those of the last merge recorded in the history or, if a
+// source is given, those a merge of it would make.
+func runWhy(ctx context.Context, args []string) error {
+	var (
+		destFile   string
+		srcFile    string
+		configFile string
+		packages   string
+		srcOpts    sourceOptions
+		netOpts    netOptions
+		opts       mergeOptions
+	)
+	fs := flag.NewFlagSet("modtransplant why", flag.ExitOnError)
+	fs.StringVar(&destFile, "dest", "", "destination go.mod file")
+	fs.StringVar(&srcFile, "src", "", "source module to plan a merge of, instead of looking at the last merge in the history")
+	fs.StringVar(&configFile, "config", "", "JSON config file of the planned merge")
+	fs.BoolVar(&opts.forceOverwrite, "force-overwrite", false, "plan the merge with -force-overwrite")
+	fs.BoolVar(&opts.addOnly, "add-only", false, "plan the merge with -add-only")
+	fs.StringVar(&opts.orgPrefix, "org-prefix", "", "plan the merge with -org-prefix")
+	fs.BoolVar(&opts.pruneReplaces, "prune-replaces", false, "plan the merge with -prune-replaces")
+	fs.BoolVar(&opts.pruneExcludes, "prune-excludes", false, "plan the merge with -prune-excludes")
+	fs.BoolVar(&opts.autoPatch, "auto-patch", false, "plan the merge with -auto-patch")
+	fs.BoolVar(&opts.securityOnly, "security-only", false, "plan the merge with -security-only")
+	fs.StringVar(&opts.osvURL, "osv-url", defaultOSVURL, "base URL of the OSV API used by -security-only")
+	fs.StringVar(&packages, "src-packages", "", "plan the merge with -src-packages")
+	fs.BoolVar(&srcOpts.skipTestDeps, "skip-test-deps", false, "plan the merge with -skip-test-deps")
+	fs.BoolVar(&srcOpts.latest, "latest", false, "plan the merge with -latest")
+	fs.BoolVar(&srcOpts.sameMajor, "same-major", false, "plan the merge with -same-major")
+	fs.BoolVar(&srcOpts.deep, "deep", false, "plan the merge with -deep")
+	fs.Var(&srcOpts.rewrites, "rewrite", "plan the merge with -rewrite (repeatable)")
+	netOpts.register(fs)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if destFile == "" || destFile == stdinDest || fs.NArg() != 1 {
+		return errors.New(usage)
+	}
+	path := fs.Arg(0)
+	srcOpts.packages = strings.Fields(packages)
+
+	var (
+		changes []change
+		what    string
+	)
+	if srcFile == "" {
+		rec, err := lastHistoryRecord(destFile)
+		if err != nil {
+			return err
+		}
+		changes = rec.Changes
+		what = fmt.Sprintf("the last merge (%s, from %s)", rec.Time.Local().Format("2006-01-02 15:04"), rec.Src)
+	} else {
+		opts.noHistory = true
+		opts.emit = emitFile
+		opts.output = ioutil.Discard
+		opts.results = new([]fileResult)
+		src, err := opts.prepare(ctx, configFile, srcFile, &netOpts, srcOpts)
+		if err != nil {
+			return err
+		}
+		if err := mergeInto(ctx, destFile, src, &opts); err != nil {
+			return err
+		}
+		changes = (*opts.results)[0].Changes
+		what = "a merge of " + srcFile
+	}
+	return writeWhy(os.Stdout, path, what, changes)
+}
+
+// writeWhy prints the changes to path with the steps that led to them.
+func writeWhy(w io.Writer, path, what string, changes []change) error {
+	var found bool
+	for _, c := range changes {
+		if c.Path != path {
+			continue
+		}
+		found = true
+		fmt.Fprintf(w, "%s %s %s", c.Kind, c.Action, c.Path)
+		if c.Version != "" {
+			fmt.Fprintf(w, " %s", c.Version)
+		}
+		switch {
+		case c.From != "" && c.To != "":
+			fmt.Fprintf(w, ": %s -> %s", c.From, c.To)
+		case c.To != "":
+			fmt.Fprintf(w, ": %s", c.To)
+		}
+		fmt.Fprintln(w)
+		if len(c.Why) == 0 {
+			fmt.Fprintln(w, "\tno recorded cause (e.g. a -rego-bundle modification)")
+		}
+		for _, line := range c.Why {
+			fmt.Fprintf(w, "\t%s\n", line)
+		}
+	}
+	if !found {
+		_, err := fmt.Fprintf(w, "%s isn't changed by %s\n", path, what)
+		return err
+	}
+	_, err := fmt.Fprintf(w, "by %s\n", what)
+	return err
+}
+
+// lastHistoryRecord returns the last record in the history of the module at
+// destFile.
+func lastHistoryRecord(destFile string) (*historyRecord, error) {
+	dest, err := parseModFile(destFile)
+	if err != nil {
+		return nil, err
+	}
+	root, err := repoRoot(filepath.Dir(destFile))
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(filepath.Join(root, historyFile))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var last *historyRecord
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for sc.Scan() {
+		var rec historyRecord
+		if err := json.Unmarshal(sc.Bytes(), &rec); err != nil {
+			return nil, fmt.Errorf("%s: %w", historyFile, err)
+		}
+		if rec.Module == dest.Module.Mod.Path {
+			last = &rec
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	if last == nil {
+		return nil, fmt.Errorf("no merge into %s in %s", dest.Module.Mod.Path, filepath.Join(root, historyFile))
+	}
+	return last, nil
+}
+
+// explain sets the Why of every change to the trace of its module.
+func explain(changes []change, t trace) {
+	for i := range changes {
+		changes[i].Why = t[changes[i].Path]
+	}
+}
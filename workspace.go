@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/mod/modfile"
+)
+
+// destWorkspace returns the go.work file of the workspace the module of
+// destFile is used in, as the go command finds it from the module's
+// directory (honoring GOWORK), or "" if the module isn't part of one or the
+// go command isn't installed.
+func destWorkspace(ctx context.Context, destFile string) (string, error) {
+	if _, err := exec.LookPath("go"); err != nil {
+		return "", nil
+	}
+	dir, err := filepath.Abs(filepath.Dir(destFile))
+	if err != nil {
+		return "", err
+	}
+	workFile, err := workspaceFile(ctx, dir)
+	if workFile == "" || err != nil {
+		return "", err
+	}
+	content, err := ioutil.ReadFile(workFile)
+	if err != nil {
+		return "", err
+	}
+	work, err := modfile.ParseWork(workFile, content, nil)
+	if err != nil {
+		return "", err
+	}
+	for _, u := range work.Use {
+		used := filepath.FromSlash(u.Path)
+		if !filepath.IsAbs(used) {
+			used = filepath.Join(filepath.Dir(workFile), used)
+		}
+		if filepath.Clean(used) == dir {
+			return workFile, nil
+		}
+	}
+	return "", nil
+}
+
+// workspaceFile returns the go.work file the go command uses in dir
+// (honoring GOWORK), or "" if there is none.
+func workspaceFile(ctx context.Context, dir string) (string, error) {
+	cmd := exec.CommandContext(ctx, "go", "env", "GOWORK")
+	cmd.Dir = dir
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("go env GOWORK: %s", strings.TrimSpace(stderr.String()))
+	}
+	workFile := strings.TrimSpace(string(out))
+	if workFile == "off" {
+		return "", nil
+	}
+	return workFile, nil
+}
+
+// syncWorkSum adds the checksums of the workspace's build list missing from
+// the go.sum files of its modules to its go.work.sum file, by downloading the
+// modules with the go command in workspace mode, so go work sync has nothing
+// left to add after a merge. Offline, only the module cache is used.
+func syncWorkSum(ctx context.Context, workFile string, offline bool) error {
+	sumFile := workFile + ".sum"
+	before := countLines(sumFile)
+	cmd := exec.CommandContext(ctx, "go", "mod", "download")
+	cmd.Dir = filepath.Dir(workFile)
+	// -mod=mod isn't allowed in workspace mode.
+	cmd.Env = append(os.Environ(), "GOWORK="+workFile, "GOFLAGS=-mod=readonly")
+	if offline {
+		cmd.Env = append(cmd.Env, "GOPROXY=off")
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("go mod download (workspace %s): %s", workFile, strings.TrimSpace(stderr.String()))
+	}
+	if added := countLines(sumFile) - before; added > 0 {
+		fmt.Fprintf(os.Stderr, "(workspace) %s: %d checksum%s added\n", sumFile, added, plural(added))
+	}
+	return nil
+}
+
+// countLines returns the number of lines of the file at path, or 0 if it
+// can't be read.
+func countLines(path string) int {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+	var n int
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		n++
+	}
+	return n
+}